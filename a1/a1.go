@@ -0,0 +1,257 @@
+// Package a1 formats and parses cell references and ranges in A1 notation,
+// as used by Google Sheets:
+// "B3," "A2:H200," "A:H," "2:200,"
+// and any of those after a sheet name and "!",
+// as in "'My binder'!A2:H200."
+//
+// Row and column numbers in this package are zero-based,
+// so row 0, column 0 is "A1."
+package a1
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ErrSyntax is wrapped by the errors from Parse and ColNum
+// for malformed input.
+var ErrSyntax = errors.New("malformed A1 notation")
+
+// ColName returns the name of the zero-based column number col:
+// A through Z for the first 26 columns,
+// AA through AZ for the next 26,
+// then BA through BZ,
+// and so on.
+// It panics if col is negative.
+func ColName(col int) string {
+	if col < 0 {
+		panic(fmt.Sprintf("negative column number %d", col))
+	}
+	if col < 26 {
+		return string(rune('A' + col))
+	}
+	return ColName(col/26-1) + ColName(col%26)
+}
+
+// ColNum is the inverse of ColName:
+// it turns a column name like "A" or "ab"
+// (case doesn't matter)
+// into a zero-based column number.
+func ColNum(name string) (int, error) {
+	if name == "" {
+		return 0, fmt.Errorf("%w: empty column name", ErrSyntax)
+	}
+	n := 0
+	for _, c := range strings.ToUpper(name) {
+		if c < 'A' || c > 'Z' {
+			return 0, fmt.Errorf("%w: bad column name %q", ErrSyntax, name)
+		}
+		n = n*26 + int(c-'A'+1)
+		if n > 1<<30 {
+			return 0, fmt.Errorf("%w: column name %q too long", ErrSyntax, name)
+		}
+	}
+	return n - 1, nil
+}
+
+// simpleSheetName matches sheet names that needn't be quoted.
+var simpleSheetName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// cellLike matches sheet names that would be mistaken for cell references
+// (in A1 or R1C1 notation)
+// if they weren't quoted.
+var cellLike = regexp.MustCompile(`^(?i:[a-z]{1,3}[0-9]+|r[0-9]*c[0-9]*)$`)
+
+// QuoteSheet returns the sheet name as it must appear before the "!" in a range:
+// in single quotes
+// (with any single quotes in it doubled)
+// unless it's a plain name like "Sheet1."
+// An empty name stays empty.
+func QuoteSheet(name string) string {
+	if name == "" || (simpleSheetName.MatchString(name) && !cellLike.MatchString(name)) {
+		return name
+	}
+	return "'" + strings.ReplaceAll(name, "'", "''") + "'"
+}
+
+// Cell returns the A1 notation for a single cell,
+// with the sheet name
+// (quoted as needed)
+// if it's not empty.
+// (With no sheet name,
+// the Sheets API takes the cell to be in the first sheet.)
+func Cell(sheet string, row, col int) string {
+	return Range{Sheet: sheet, StartRow: row, StartCol: col, EndRow: row, EndCol: col}.String()
+}
+
+// A Range is a rectangle of cells.
+// Its bounds are zero-based and inclusive.
+// A bound of -1 means there's no limit on that side,
+// as with the rows in "A:H" and the columns in "2:200."
+type Range struct {
+	Sheet              string // Empty means the first sheet.
+	StartRow, StartCol int
+	EndRow, EndCol     int
+}
+
+// SheetRange is the Range for the whole of the named sheet.
+func SheetRange(sheet string) Range {
+	return Range{Sheet: sheet, StartRow: -1, StartCol: -1, EndRow: -1, EndCol: -1}
+}
+
+// String returns r in A1 notation.
+// A range of a single cell is written as just that cell, like "B3."
+// (But a single row or column is written as "5:5" or "C:C.")
+// A range with no bounds at all is just the sheet name,
+// which the Sheets API takes to mean the whole sheet.
+// It's always quoted,
+// since a plain name like "Cards" or "Sheet1" would otherwise look like a cell or column.
+//
+// Parse(r.String()) returns r
+// for any r whose bounds are each -1 or more
+// and that has at least one bound
+// (or a sheet name)
+// at each end.
+func (r Range) String() string {
+	var (
+		start = corner(r.StartRow, r.StartCol)
+		end   = corner(r.EndRow, r.EndCol)
+		s     string
+	)
+	switch {
+	case start == "" && end == "":
+		if r.Sheet == "" {
+			return ""
+		}
+		return "'" + strings.ReplaceAll(r.Sheet, "'", "''") + "'"
+	case start == end && r.StartRow >= 0 && r.StartCol >= 0:
+		s = start
+	default:
+		s = start + ":" + end
+	}
+	if r.Sheet == "" {
+		return s
+	}
+	return QuoteSheet(r.Sheet) + "!" + s
+}
+
+func corner(row, col int) string {
+	var s string
+	if col >= 0 {
+		s = ColName(col)
+	}
+	if row >= 0 {
+		s += strconv.Itoa(row + 1)
+	}
+	return s
+}
+
+// HasRow tells whether row is within the bounds of r.
+func (r Range) HasRow(row int) bool {
+	return (r.StartRow < 0 || row >= r.StartRow) && (r.EndRow < 0 || row <= r.EndRow)
+}
+
+// HasCol tells whether col is within the bounds of r.
+func (r Range) HasCol(col int) bool {
+	return (r.StartCol < 0 || col >= r.StartCol) && (r.EndCol < 0 || col <= r.EndCol)
+}
+
+// SplitSheet separates the sheet name from the rest of an A1-notation range,
+// undoing the quoting of QuoteSheet.
+// If there's no "!",
+// the sheet name is empty
+// and rest is all of s.
+func SplitSheet(s string) (sheet, rest string, err error) {
+	if strings.HasPrefix(s, "'") {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			if s[i] != '\'' {
+				b.WriteByte(s[i])
+				continue
+			}
+			if i+1 < len(s) && s[i+1] == '\'' {
+				b.WriteByte('\'')
+				i++
+				continue
+			}
+			// The closing quote.
+			switch {
+			case i+1 == len(s):
+				return b.String(), "", nil
+			case s[i+1] == '!':
+				return b.String(), s[i+2:], nil
+			}
+			return "", "", fmt.Errorf("%w: unexpected %q after quoted sheet name in %q", ErrSyntax, s[i+1:], s)
+		}
+		return "", "", fmt.Errorf("%w: unterminated sheet name in %q", ErrSyntax, s)
+	}
+	if i := strings.LastIndex(s, "!"); i >= 0 {
+		return s[:i], s[i+1:], nil
+	}
+	return "", s, nil
+}
+
+// cornerRegex matches one end of a range:
+// a column, a row, or both.
+var cornerRegex = regexp.MustCompile(`^([A-Za-z]*)([0-9]*)$`)
+
+// Parse parses a range in A1 notation,
+// like "B3," "A2:H200," "A:H," or "2:200,"
+// optionally preceded by a sheet name and "!"
+// (see SplitSheet).
+// A sheet name by itself
+// (with "!" or without, if it's quoted)
+// means the whole sheet.
+// Missing bounds are -1.
+func Parse(s string) (Range, error) {
+	sheet, rest, err := SplitSheet(strings.TrimSpace(s))
+	if err != nil {
+		return Range{}, err
+	}
+	if rest == "" {
+		if sheet == "" {
+			return Range{}, fmt.Errorf("%w: empty range", ErrSyntax)
+		}
+		return SheetRange(sheet), nil
+	}
+
+	start, end, ok := strings.Cut(rest, ":")
+	if !ok {
+		end = start
+	}
+	r := Range{Sheet: sheet}
+	if r.StartRow, r.StartCol, err = parseCorner(start); err != nil {
+		return Range{}, err
+	}
+	if r.EndRow, r.EndCol, err = parseCorner(end); err != nil {
+		return Range{}, err
+	}
+	return r, nil
+}
+
+// parseCorner parses one end of a range.
+// A missing row or column is returned as -1.
+func parseCorner(s string) (row, col int, err error) {
+	m := cornerRegex.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || (m[1] == "" && m[2] == "") {
+		return 0, 0, fmt.Errorf("%w: bad cell reference %q", ErrSyntax, s)
+	}
+
+	row, col = -1, -1
+	if m[1] != "" {
+		if col, err = ColNum(m[1]); err != nil {
+			return 0, 0, err
+		}
+	}
+	if m[2] != "" {
+		n, err := strconv.Atoi(m[2])
+		if err != nil || n < 1 {
+			return 0, 0, fmt.Errorf("%w: bad row number in %q", ErrSyntax, s)
+		}
+		row = n - 1
+	}
+	return row, col, nil
+}
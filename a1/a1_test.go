@@ -0,0 +1,272 @@
+package a1
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+)
+
+func TestColName(t *testing.T) {
+	cases := []struct {
+		col  int
+		want string
+	}{
+		{0, "A"},
+		{25, "Z"},
+		{26, "AA"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+		{18277, "ZZZ"},
+	}
+	for _, c := range cases {
+		if got := ColName(c.col); got != c.want {
+			t.Errorf("ColName(%d) = %q, want %q", c.col, got, c.want)
+		}
+	}
+}
+
+func TestColNameRoundTrip(t *testing.T) {
+	f := func(n uint16) bool {
+		col := int(n)
+		got, err := ColNum(ColName(col))
+		return err == nil && got == col
+	}
+	if err := quick.Check(f, nil); err != nil {
+		t.Error(err)
+	}
+
+	// Every name is some column's,
+	// whatever its case.
+	g := func(n uint16) bool {
+		name := ColName(int(n))
+		col, err := ColNum(strings.ToLower(name))
+		return err == nil && ColName(col) == name
+	}
+	if err := quick.Check(g, nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestColNumErrors(t *testing.T) {
+	for _, name := range []string{"", "A1", "A-B", "É", strings.Repeat("Z", 10)} {
+		if _, err := ColNum(name); !errors.Is(err, ErrSyntax) {
+			t.Errorf("ColNum(%q): got error %v, want ErrSyntax", name, err)
+		}
+	}
+}
+
+func TestQuoteSheet(t *testing.T) {
+	cases := []struct {
+		name, want string
+	}{
+		{"", ""},
+		{"Sheet1", "Sheet1"},
+		{"Cards", "Cards"},
+		{"my_binder", "my_binder"},
+		{"My binder", "'My binder'"},
+		{"Archer's cards", "'Archer''s cards'"},
+		{"'quoted'", "'''quoted'''"},
+		{"Trades!", "'Trades!'"},
+		{"2024", "'2024'"},
+		{"1st binder", "'1st binder'"},
+
+		// These look like cell references.
+		{"A1", "'A1'"},
+		{"ab12", "'ab12'"},
+		{"XFD1048576", "'XFD1048576'"},
+		{"R1C1", "'R1C1'"},
+		{"rc", "'rc'"},
+		{"R2C", "'R2C'"},
+		{"C3", "'C3'"},
+	}
+	for _, c := range cases {
+		if got := QuoteSheet(c.name); got != c.want {
+			t.Errorf("QuoteSheet(%q) = %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+func TestSplitSheet(t *testing.T) {
+	cases := []struct {
+		s, sheet, rest string
+	}{
+		{"A1", "", "A1"},
+		{"Sheet1!A1:B2", "Sheet1", "A1:B2"},
+		{"'My binder'!A:H", "My binder", "A:H"},
+		{"'Archer''s cards'!2:200", "Archer's cards", "2:200"},
+		{"'Trades!'!B3", "Trades!", "B3"},
+		{"'My binder'", "My binder", ""},
+	}
+	for _, c := range cases {
+		sheet, rest, err := SplitSheet(c.s)
+		if err != nil {
+			t.Errorf("SplitSheet(%q): %s", c.s, err)
+			continue
+		}
+		if sheet != c.sheet || rest != c.rest {
+			t.Errorf("SplitSheet(%q) = %q, %q; want %q, %q", c.s, sheet, rest, c.sheet, c.rest)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		s    string
+		want Range
+	}{
+		{"B3", Range{StartRow: 2, StartCol: 1, EndRow: 2, EndCol: 1}},
+		{"A2:H200", Range{StartRow: 1, StartCol: 0, EndRow: 199, EndCol: 7}},
+		{"A:H", Range{StartRow: -1, StartCol: 0, EndRow: -1, EndCol: 7}},
+		{"2:200", Range{StartRow: 1, StartCol: -1, EndRow: 199, EndCol: -1}},
+		{"'My binder'!A2:H200", Range{Sheet: "My binder", StartRow: 1, StartCol: 0, EndRow: 199, EndCol: 7}},
+		{"'My binder'", SheetRange("My binder")},
+		{"Cards!", SheetRange("Cards")},
+		{" b3 ", Range{StartRow: 2, StartCol: 1, EndRow: 2, EndCol: 1}},
+	}
+	for _, c := range cases {
+		got, err := Parse(c.s)
+		if err != nil {
+			t.Errorf("Parse(%q): %s", c.s, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("Parse(%q) = %#v, want %#v", c.s, got, c.want)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, s := range []string{"", "A0", "A1:", ":B2", "A1B", "1A", "'unterminated!A1", "'x'y!A1", "A-1"} {
+		if _, err := Parse(s); !errors.Is(err, ErrSyntax) {
+			t.Errorf("Parse(%q): got error %v, want ErrSyntax", s, err)
+		}
+	}
+}
+
+// sheetNames are the sheet names that TestRangeRoundTrip chooses from.
+var sheetNames = []string{
+	"",
+	"Sheet1",
+	"Cards",
+	"My binder",
+	"Archer's cards",
+	"'",
+	"Trades!",
+	"A1",
+	"R1C1",
+	"rc",
+	"ZZZ",
+	"2024",
+	" padded ",
+	"Binder: 2",
+}
+
+// testRange is a Range that TestRangeRoundTrip can generate with testing/quick.
+type testRange Range
+
+// Generate implements quick.Generator.
+// It makes a Range that String can round-trip through Parse:
+// each bound -1 or more,
+// and at least one bound at each end,
+// or else no bounds but a sheet name.
+func (testRange) Generate(rnd *rand.Rand, _ int) reflect.Value {
+	bound := func() int {
+		if rnd.Intn(4) == 0 {
+			return -1
+		}
+		return rnd.Intn(1000)
+	}
+	var r Range
+	for {
+		r = Range{
+			Sheet:    sheetNames[rnd.Intn(len(sheetNames))],
+			StartRow: bound(),
+			StartCol: bound(),
+			EndRow:   bound(),
+			EndCol:   bound(),
+		}
+		if rnd.Intn(10) == 0 && r.Sheet != "" {
+			r = SheetRange(r.Sheet)
+		}
+		startOK := r.StartRow >= 0 || r.StartCol >= 0
+		endOK := r.EndRow >= 0 || r.EndCol >= 0
+		if startOK && endOK {
+			break
+		}
+		if !startOK && !endOK && r.Sheet != "" {
+			break
+		}
+	}
+	return reflect.ValueOf(testRange(r))
+}
+
+func TestRangeRoundTrip(t *testing.T) {
+	f := func(tr testRange) bool {
+		r := Range(tr)
+		s := r.String()
+		got, err := Parse(s)
+		if err != nil {
+			t.Logf("Parse(%q) (from %#v): %s", s, r, err)
+			return false
+		}
+		if got != r {
+			t.Logf("Parse(%q) = %#v, want %#v", s, got, r)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRangeString(t *testing.T) {
+	cases := []struct {
+		r    Range
+		want string
+	}{
+		{Range{StartRow: 2, StartCol: 1, EndRow: 2, EndCol: 1}, "B3"},
+		{Range{StartRow: 4, StartCol: -1, EndRow: 4, EndCol: -1}, "5:5"},
+		{Range{StartRow: -1, StartCol: 2, EndRow: -1, EndCol: 2}, "C:C"},
+		{Range{Sheet: "My binder", StartRow: 1, StartCol: 0, EndRow: 199, EndCol: 7}, "'My binder'!A2:H200"},
+		{Range{Sheet: "A1", StartRow: 0, StartCol: 0, EndRow: 0, EndCol: 0}, "'A1'!A1"},
+		{SheetRange("My binder"), "'My binder'"},
+		{SheetRange("Cards"), "'Cards'"},
+	}
+	for _, c := range cases {
+		if got := c.r.String(); got != c.want {
+			t.Errorf("%#v.String() = %q, want %q", c.r, got, c.want)
+		}
+	}
+}
+
+func TestCell(t *testing.T) {
+	if got := Cell("Archer's cards", 9, 27); got != "'Archer''s cards'!AB10" {
+		t.Errorf("got %q", got)
+	}
+	if got := Cell("", 0, 0); got != "A1" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHasRowCol(t *testing.T) {
+	r := Range{StartRow: 1, StartCol: -1, EndRow: 9, EndCol: -1}
+	for _, c := range []struct {
+		row  int
+		want bool
+	}{{0, false}, {1, true}, {9, true}, {10, false}} {
+		if got := r.HasRow(c.row); got != c.want {
+			t.Errorf("HasRow(%d) = %v, want %v", c.row, got, c.want)
+		}
+	}
+	for _, col := range []int{0, 100} {
+		if !r.HasCol(col) {
+			t.Errorf("HasCol(%d) = false, want true", col)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An alertThreshold says how much a card's price must change
+// (up or down)
+// to trigger an alert.
+type alertThreshold struct {
+	amount  float64
+	percent bool // If true, amount is a percentage of the old price rather than an absolute amount.
+}
+
+// parseAlertThreshold parses the value of the -alert-threshold flag.
+// It is either an absolute amount, like "2.50" (or "$2.50"),
+// or a percentage, like "20%".
+func parseAlertThreshold(s string) (alertThreshold, error) {
+	var t alertThreshold
+	s = strings.TrimSpace(s)
+	if strings.HasSuffix(s, "%") {
+		t.percent = true
+		s = strings.TrimSuffix(s, "%")
+	}
+	s = strings.TrimPrefix(s, "$")
+	amount, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return t, fmt.Errorf("parsing alert threshold %q: %w", s, err)
+	}
+	if amount <= 0 {
+		return t, fmt.Errorf("alert threshold must be positive")
+	}
+	t.amount = amount
+	return t, nil
+}
+
+// exceeded tells whether a price change crosses the threshold.
+func (t alertThreshold) exceeded(c priceChange) bool {
+	diff := math.Abs(c.diff())
+	if !t.percent {
+		return diff >= t.amount
+	}
+	if c.OldPrice == 0 {
+		return c.NewPrice > 0
+	}
+	return 100*diff/c.OldPrice >= t.amount
+}
+
+// A priceChange describes the change in a card's price during a run.
+// Changes at least as big as the alert threshold trigger alerts.
+type priceChange struct {
+	Sheet    string  `json:"sheet"`
+	Row      int     `json:"row"` // One-based, as in the spreadsheet UI.
+	Card     string  `json:"card"`
+	Set      string  `json:"set,omitempty"`
+	Foil     bool    `json:"foil,omitempty"`
+	OldPrice float64 `json:"old_price"`
+	NewPrice float64 `json:"new_price"`
+
+	// If the card has a watch target that the new price reached
+	// (see watchTarget),
+	// this is it.
+	// Such changes always trigger alerts.
+	Watch string `json:"watch,omitempty"`
+}
+
+func (a priceChange) String() string {
+	name := a.Card
+	if a.Set != "" {
+		name += " (" + a.Set + ")"
+	}
+	if a.Foil {
+		name += " [foil]"
+	}
+	s := fmt.Sprintf("%s: $%.2f -> $%.2f (%+.2f)", name, a.OldPrice, a.NewPrice, a.diff())
+	if a.Watch != "" {
+		s += " [watch " + a.Watch + "]"
+	}
+	return s
+}
+
+// diff is the amount of the change.
+func (a priceChange) diff() float64 {
+	return a.NewPrice - a.OldPrice
+}
+
+// priceChangeFor returns a priceChange for the given row result,
+// noting whether the new price reached the row's watch target.
+// The boolean result is false if the row was not updated
+// or if the old or new price can't be parsed.
+func priceChangeFor(sheetName string, rownum int, res rowResult) (priceChange, bool) {
+	if !res.updated {
+		return priceChange{}, false
+	}
+	oldPrice, ok := parsePrice(res.oldPrice)
+	if !ok {
+		return priceChange{}, false
+	}
+	newPrice, ok := parsePrice(res.newPrice)
+	if !ok {
+		return priceChange{}, false
+	}
+	c := priceChange{
+		Sheet:    sheetName,
+		Row:      rownum + 1,
+		Card:     res.cardName,
+		Set:      res.setCode,
+		Foil:     res.foil,
+		OldPrice: oldPrice,
+		NewPrice: newPrice,
+	}
+	if t, ok := parseWatch(res.watch); ok && t.crossed(oldPrice, newPrice) {
+		c.Watch = t.String()
+	}
+	return c, true
+}
+
+// An alertSink is somewhere to send price alerts.
+type alertSink interface {
+	send(ctx context.Context, alerts []priceChange) error
+}
+
+// parseAlertSink parses the value of an -alert flag,
+// which is one of:
+//
+//   - "stdout"
+//   - "webhook:URL"
+//   - "email:ADDRESS"
+//
+// Email requires the "smtp" section of the config file.
+func parseAlertSink(s string, cfg *config, client *http.Client) (alertSink, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch kind {
+	case "stdout":
+		return writerSink{w: os.Stdout}, nil
+	case "webhook":
+		if arg == "" {
+			return nil, fmt.Errorf("missing URL in %q", s)
+		}
+		return webhookSink{url: arg, client: client}, nil
+	case "email":
+		if arg == "" {
+			return nil, fmt.Errorf("missing address in %q", s)
+		}
+		if cfg.SMTP == nil {
+			return nil, fmt.Errorf("email alerts require an smtp section in the config file")
+		}
+		return emailSink{smtp: *cfg.SMTP, to: arg}, nil
+	}
+	return nil, fmt.Errorf("unknown alert sink %q", s)
+}
+
+// A writerSink writes alerts as lines of text.
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) send(_ context.Context, alerts []priceChange) error {
+	for _, a := range alerts {
+		if _, err := fmt.Fprintln(s.w, a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// A webhookSink POSTs alerts as a JSON object to a URL.
+// The object has a single field, "alerts,"
+// which is an array of priceChange objects.
+type webhookSink struct {
+	url    string
+	client *http.Client
+}
+
+func (s webhookSink) send(ctx context.Context, alerts []priceChange) error {
+	body, err := json.Marshal(map[string]any{"alerts": alerts})
+	if err != nil {
+		return fmt.Errorf("encoding alerts: %w", err)
+	}
+	return postJSON(ctx, s.client, s.url, body)
+}
+
+// postJSON POSTs a JSON body to a URL,
+// treating any non-2xx response as an error.
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("posting to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("posting to %s: status %d", url, resp.StatusCode)
+	}
+	return nil
+}
+
+// smtpConfig is the "smtp" section of the config file,
+// needed for sending email.
+type smtpConfig struct {
+	Addr     string `json:"addr"` // Host and port of the SMTP server, e.g. "smtp.example.com:587".
+	From     string `json:"from"`
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+// send sends an email message with the given subject and body.
+func (c smtpConfig) send(to, subject, body string) error {
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := strings.Cut(c.Addr, ":")
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+	msg := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\n\r\n%s", c.From, to, subject, body)
+	if err := smtp.SendMail(c.Addr, auth, c.From, []string{to}, []byte(msg)); err != nil {
+		return fmt.Errorf("sending email to %s: %w", to, err)
+	}
+	return nil
+}
+
+// An emailSink sends alerts in an email message.
+type emailSink struct {
+	smtp smtpConfig
+	to   string
+}
+
+func (s emailSink) send(_ context.Context, alerts []priceChange) error {
+	buf := new(bytes.Buffer)
+	for _, a := range alerts {
+		fmt.Fprintln(buf, a)
+	}
+	subject := fmt.Sprintf("Majic: %d price alert(s)", len(alerts))
+	return s.smtp.send(s.to, subject, buf.String())
+}
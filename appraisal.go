@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"time"
+)
+
+// appraisal implements the "report" subcommand.
+// It writes an HTML document listing every card in the selected sheets
+// (see readCollection)
+// with its printing, condition, quantity, and current value,
+// plus a grand total and the date,
+// suitable for insurance documentation.
+// Proxies are left out,
+// except for a count of them.
+// (To get a PDF,
+// open the file in a web browser and print it.)
+//
+// The values are whatever's in the Price column,
+// so run majic first to bring them up to date.
+func (r *runner) appraisal(ctx context.Context, title, outfile string, _ []string) error {
+	rows, err := r.readCollection(ctx)
+	if err != nil {
+		return err
+	}
+	sets, err := r.scryfall.sets(ctx)
+	if err != nil {
+		return err
+	}
+
+	data := appraisalData{
+		Title: title,
+		Date:  time.Now().Format("January 2, 2006"),
+	}
+	for _, row := range rows {
+		if row.Proxy {
+			data.Proxies += row.Quantity
+			continue
+		}
+		item := appraisalItem{
+			Card:      row.CardName,
+			Set:       sets.setName(row.SetCode),
+			Number:    row.CollectorNumber,
+			Foil:      row.Foil,
+			Condition: conditionName(row.Condition),
+			Language:  languageName(row.Language),
+			Quantity:  row.Quantity,
+		}
+		if row.Price != nil {
+			item.Price = row.Price
+			item.Value = *row.Price * float64(row.Quantity)
+			data.Total += item.Value
+		} else {
+			data.Unpriced++
+		}
+		data.Cards += row.Quantity
+		data.Items = append(data.Items, item)
+	}
+	sort.SliceStable(data.Items, func(i, j int) bool {
+		return data.Items[i].Value > data.Items[j].Value
+	})
+
+	var w io.Writer = os.Stdout
+	if outfile != "" && outfile != "-" {
+		f, err := os.Create(outfile)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", outfile, err)
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := appraisalTmpl.Execute(w, data); err != nil {
+		return fmt.Errorf("writing report: %w", err)
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+type appraisalData struct {
+	Title    string
+	Date     string
+	Items    []appraisalItem // Most valuable first.
+	Cards    int             // The total quantity.
+	Total    float64
+	Unpriced int // How many rows have no price.
+	Proxies  int // How many proxies were left out.
+}
+
+type appraisalItem struct {
+	Card, Set, Number   string
+	Foil                bool
+	Condition, Language string
+	Quantity            int
+	Price               *float64 // Per copy; nil if unknown.
+	Value               float64  // Price times quantity.
+}
+
+var appraisalTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"money": func(f float64) string { return priceString(&f) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: Georgia, serif; margin: 2em; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9em; }
+th, td { border-bottom: 1px solid #ccc; padding: 0.3em 0.5em; text-align: left; }
+td.num, th.num { text-align: right; }
+tfoot td { font-weight: bold; border-top: 2px solid black; }
+@media print { thead { display: table-header-group; } tr { page-break-inside: avoid; } }
+</style>
+</head>
+<body>
+<h1>{{ .Title }}</h1>
+<p>As of {{ .Date }}.
+{{ .Cards }} cards ({{ len .Items }} entries),
+valued at ${{ money .Total }}.
+{{- if .Unpriced }}
+{{ .Unpriced }} of the entries have no price and are not included in the total.
+{{- end }}
+{{- if .Proxies }}
+{{ .Proxies }} {{ if eq .Proxies 1 }}proxy is{{ else }}proxies are{{ end }} not included.
+{{- end }}
+Values are current market prices for the given condition, from Scryfall.</p>
+<table>
+<thead>
+<tr><th>Card</th><th>Set</th><th>Number</th><th>Finish</th><th>Condition</th><th>Language</th><th class="num">Qty</th><th class="num">Price</th><th class="num">Value</th></tr>
+</thead>
+<tbody>
+{{- range .Items }}
+<tr><td>{{ .Card }}</td><td>{{ .Set }}</td><td>{{ .Number }}</td><td>{{ if .Foil }}Foil{{ else }}Nonfoil{{ end }}</td><td>{{ .Condition }}</td><td>{{ .Language }}</td><td class="num">{{ .Quantity }}</td>
+{{- if .Price }}<td class="num">{{ money .Price }}</td><td class="num">{{ money .Value }}</td>{{ else }}<td></td><td></td>{{ end }}</tr>
+{{- end }}
+</tbody>
+<tfoot>
+<tr><td colspan="6">Total</td><td class="num">{{ .Cards }}</td><td></td><td class="num">{{ money .Total }}</td></tr>
+</tfoot>
+</table>
+</body>
+</html>
+`))
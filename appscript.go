@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// appScript implements the "appscript" subcommand.
+// It writes a Google Apps Script
+// to paste into the spreadsheet's script editor
+// (Extensions > Apps Script).
+// The script adds a "majic" menu to the spreadsheet
+// for starting a run, or pricing the selected rows,
+// by calling the /webhook endpoint of a majic server
+// (see serve.go and webhook.go)
+// at the given URL.
+// It can also install an edit trigger
+// that sends edited rows to the server,
+// so they're priced right away.
+//
+// The secret defaults to the value of -webhook-secret.
+func (r *runner) appScript(_ context.Context, secret, outfile, serverURL string, _ []string) error {
+	if secret == "" {
+		secret = r.webhookSecret
+	}
+	if secret == "" {
+		return fmt.Errorf("no secret (use -secret or -webhook-secret)")
+	}
+	if !strings.HasPrefix(serverURL, "http://") && !strings.HasPrefix(serverURL, "https://") {
+		return fmt.Errorf("server URL %q must begin with http:// or https://", serverURL)
+	}
+
+	var w io.Writer = os.Stdout
+	if outfile != "" && outfile != "-" {
+		f, err := os.Create(outfile)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", outfile, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	err := appScriptTmpl.Execute(w, map[string]string{
+		"URL":    strings.TrimSuffix(serverURL, "/") + "/webhook",
+		"Secret": secret,
+	})
+	if err != nil {
+		return fmt.Errorf("writing script: %w", err)
+	}
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// appScriptTmpl is the Apps Script written by appScript.
+// (The js function escapes strings for JavaScript.)
+var appScriptTmpl = template.Must(template.New("").Parse(`// Generated by "majic appscript."
+// Paste this into Extensions > Apps Script in your spreadsheet.
+// Anyone who can edit the spreadsheet can see the secret below.
+
+var MAJIC_URL = "{{ js .URL }}";
+var MAJIC_SECRET = "{{ js .Secret }}";
+
+function onOpen() {
+  SpreadsheetApp.getUi()
+    .createMenu("majic")
+    .addItem("Update prices now", "majicUpdateAll")
+    .addItem("Update selected rows", "majicUpdateSelection")
+    .addSeparator()
+    .addItem("Update edited rows automatically", "majicInstallTrigger")
+    .addToUi();
+}
+
+function majicUpdateAll() {
+  majicPost({});
+  SpreadsheetApp.getActive().toast("Price update started.", "majic");
+}
+
+function majicUpdateSelection() {
+  var range = SpreadsheetApp.getActiveRange();
+  majicPostRange(range);
+  SpreadsheetApp.getActive().toast("Updating " + range.getNumRows() + " row(s).", "majic");
+}
+
+// Edit triggers that fetch URLs must be installed
+// (rather than simply named onEdit),
+// so they can have the needed permission.
+function majicInstallTrigger() {
+  var ss = SpreadsheetApp.getActive();
+  var triggers = ScriptApp.getProjectTriggers();
+  for (var i = 0; i < triggers.length; i++) {
+    if (triggers[i].getHandlerFunction() == "majicOnEdit") {
+      ss.toast("Already installed.", "majic");
+      return;
+    }
+  }
+  ScriptApp.newTrigger("majicOnEdit").forSpreadsheet(ss).onEdit().create();
+  ss.toast("Edited rows will now be priced automatically.", "majic");
+}
+
+function majicOnEdit(e) {
+  majicPostRange(e.range);
+}
+
+function majicPostRange(range) {
+  var rows = [];
+  for (var i = 0; i < range.getNumRows(); i++) {
+    rows.push(range.getRow() + i);
+  }
+  majicPost({sheet: range.getSheet().getName(), rows: rows});
+}
+
+function majicPost(body) {
+  UrlFetchApp.fetch(MAJIC_URL, {
+    method: "post",
+    contentType: "application/json",
+    headers: {Authorization: "Bearer " + MAJIC_SECRET},
+    payload: JSON.stringify(body)
+  });
+}
+`))
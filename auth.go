@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/sheets/v4"
+)
+
+// authOpts holds the settings that determine how majic authenticates to Google.
+type authOpts struct {
+	authcode       string // Auth code if needed to obtain an OAuth token.
+	credsFile      string // The file containing Google auth credentials for this application.
+	credKeySpec    string // Where to find the key for encrypted credential files; see loadCredKey.
+	tokenSpec      string // Where to store an OAuth token; see parseTokenStore.
+	serviceAccount string // If set, a service-account JSON key file to use instead of the OAuth flow.
+	localServer    bool   // Whether to get the auth code via a localhost callback server instead of prompting for it.
+}
+
+// sheetsHTTPClient produces an HTTP client that is authenticated for the Google Sheets API.
+//
+// Normally this uses the OAuth flow:
+// the user authorizes majic (once) to access their spreadsheets,
+// producing a token that is stored for subsequent runs
+// (in a file, the OS keychain, or an environment variable;
+// see tokenstore.go).
+// The token is refreshed as needed,
+// and if it stops working altogether
+// the user is prompted to authorize majic again.
+//
+// If a service-account key file is given instead,
+// it is used to authenticate directly with no user interaction.
+// In that case the spreadsheet must be shared with the service account's email address
+// (found in the key file as "client_email").
+//
+// Any of these files may be encrypted;
+// see credkey.go.
+func sheetsHTTPClient(ctx context.Context, opts authOpts) (*http.Client, error) {
+	ckey, err := loadCredKey(opts.credKeySpec)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.serviceAccount != "" {
+		key, err := ckey.readFile(opts.serviceAccount)
+		if err != nil {
+			return nil, fmt.Errorf("reading service-account key from %s: %w", opts.serviceAccount, err)
+		}
+		conf, err := google.JWTConfigFromJSON(key, sheets.SpreadsheetsScope)
+		if err != nil {
+			return nil, fmt.Errorf("parsing service-account key in %s: %w", opts.serviceAccount, err)
+		}
+		return conf.Client(ctx), nil
+	}
+
+	creds, err := ckey.readFile(opts.credsFile)
+	if err != nil {
+		return nil, fmt.Errorf("reading credentials from %s: %w", opts.credsFile, err)
+	}
+	conf, err := google.ConfigFromJSON(creds, sheets.SpreadsheetsScope)
+	if err != nil {
+		return nil, fmt.Errorf("parsing credentials in %s: %w", opts.credsFile, err)
+	}
+
+	store, err := parseTokenStore(opts.tokenSpec, ckey)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := oauthToken(ctx, conf, store, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// The token source from conf refreshes the access token when it expires.
+	// Wrapping it in a persistingTokenSource means the refreshed token gets saved too.
+	src := &persistingTokenSource{
+		src:   conf.TokenSource(ctx, tok),
+		store: store,
+		last:  tok,
+	}
+	return oauth2.NewClient(ctx, src), nil
+}
+
+// maxAuthAttempts is how many times oauthToken will try to obtain a working token
+// before giving up.
+const maxAuthAttempts = 3
+
+// oauthToken obtains an OAuth token,
+// either from the token store or by exchanging an auth code for one.
+//
+// If no token is stored and no auth code was given on the command line,
+// the user is sent to the authorization URL and asked for the resulting code
+// (or, with opts.localServer, the browser delivers the code to a localhost server).
+// If the stored token no longer works
+// (e.g. because it was revoked),
+// it is discarded and the user is asked to authorize majic again.
+func oauthToken(ctx context.Context, conf *oauth2.Config, store tokenStore, opts authOpts) (*oauth2.Token, error) {
+	authcode := opts.authcode
+
+	for attempt := 1; ; attempt++ {
+		tok, err := store.load()
+		if err != nil {
+			return nil, fmt.Errorf("loading OAuth token from %s: %w", store, err)
+		}
+
+		if tok == nil {
+			if attempt > maxAuthAttempts {
+				return nil, fmt.Errorf("could not obtain an OAuth token")
+			}
+			switch {
+			case authcode != "":
+				tok, err = conf.Exchange(ctx, authcode)
+				authcode = "" // It's good for only one exchange.
+				if err != nil {
+					return nil, fmt.Errorf("exchanging auth code for token: %w", err)
+				}
+
+			case opts.localServer:
+				// Instead of prompting for the auth code,
+				// get it (and a token) via a localhost callback server.
+				// See authserver.go.
+				tok, err = localServerToken(ctx, conf)
+				if err != nil {
+					return nil, fmt.Errorf("authorizing via local server: %w", err)
+				}
+
+			default:
+				authcode, err = promptAuthCode(conf.AuthCodeURL("state-token", oauth2.AccessTypeOffline))
+				if err != nil {
+					return nil, fmt.Errorf("getting auth code: %w", err)
+				}
+				continue
+			}
+			if err := store.save(tok); err != nil {
+				return nil, err
+			}
+		}
+
+		// Make sure the token still works.
+		// This refreshes it if it has expired,
+		// which fails if the refresh token has been revoked.
+		_, err = conf.TokenSource(ctx, tok).Token()
+
+		var retrieveErr *oauth2.RetrieveError
+		if errors.As(err, &retrieveErr) && attempt < maxAuthAttempts {
+			slog.Warn("Stored OAuth token no longer works, reauthorizing", "err", retrieveErr)
+			if err := store.remove(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("checking OAuth token: %w", err)
+		}
+		return tok, nil
+	}
+}
+
+// promptAuthCode asks the user to visit the given URL to authorize majic,
+// then reads the resulting auth code from the standard input.
+func promptAuthCode(authURL string) (string, error) {
+	fmt.Fprintf(os.Stderr, "To authorize majic to use your spreadsheets, visit this URL:\n\n  %s\n\nthen enter the authorization code here: ", authURL)
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("reading auth code: %w", err)
+	}
+	code := strings.TrimSpace(line)
+	if code == "" {
+		return "", fmt.Errorf("no auth code entered")
+	}
+	return code, nil
+}
+
+// A persistingTokenSource is an oauth2.TokenSource that wraps another one.
+// Whenever the wrapped source produces a new token
+// (because the old one expired and had to be refreshed),
+// the new token is saved in a tokenStore,
+// so the next run of majic can start with it.
+type persistingTokenSource struct {
+	src   oauth2.TokenSource
+	store tokenStore
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (p *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := p.src.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.last != nil && tok.AccessToken == p.last.AccessToken {
+		return tok, nil
+	}
+	p.last = tok
+
+	if err := p.store.save(tok); err != nil {
+		// Not fatal: we still have a working token for this run.
+		slog.Warn("Could not save refreshed OAuth token", "err", err)
+	}
+	return tok, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+
+	"golang.org/x/oauth2"
+)
+
+// localServerToken completes the OAuth flow without making the user copy an auth code.
+// It starts an HTTP server on a localhost port,
+// sends the user's browser to the authorization URL
+// with that server as the redirect target,
+// and waits for Google to redirect the browser back to it with the auth code.
+// The code is then exchanged for a token.
+func localServerToken(ctx context.Context, conf *oauth2.Config) (*oauth2.Token, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listening on localhost: %w", err)
+	}
+	defer ln.Close()
+
+	// Make a copy of conf with the redirect URL pointing at our listener.
+	// (Google permits any port on the loopback address for desktop-app credentials.)
+	c := *conf
+	c.RedirectURL = fmt.Sprintf("http://%s/", ln.Addr())
+
+	// The state parameter protects against some other page
+	// sending a forged request to our listener.
+	var stateBytes [16]byte
+	if _, err := rand.Read(stateBytes[:]); err != nil {
+		return nil, fmt.Errorf("generating state parameter: %w", err)
+	}
+	state := hex.EncodeToString(stateBytes[:])
+
+	type result struct {
+		code string
+		err  error
+	}
+	ch := make(chan result, 1)
+
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			q := req.URL.Query()
+			if q.Get("state") != state {
+				http.Error(w, "state mismatch", http.StatusBadRequest)
+				return
+			}
+			var res result
+			if e := q.Get("error"); e != "" {
+				res.err = fmt.Errorf("authorization failed: %s", e)
+				fmt.Fprintln(w, "Authorization failed. You may close this window.")
+			} else {
+				res.code = q.Get("code")
+				fmt.Fprintln(w, "Majic is now authorized. You may close this window.")
+			}
+			select {
+			case ch <- res:
+			default:
+			}
+		}),
+	}
+	go srv.Serve(ln)
+	defer srv.Close()
+
+	authURL := c.AuthCodeURL(state, oauth2.AccessTypeOffline)
+	fmt.Fprintf(os.Stderr, "Opening your browser to authorize majic. If it doesn't open, visit this URL:\n\n  %s\n\n", authURL)
+	openBrowser(authURL)
+
+	var res result
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res = <-ch:
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+
+	tok, err := c.Exchange(ctx, res.code)
+	if err != nil {
+		return nil, fmt.Errorf("exchanging auth code for token: %w", err)
+	}
+	return tok, nil
+}
+
+// openBrowser tries to open the given URL in the user's web browser.
+// Failure is silently ignored,
+// since the caller also tells the user the URL to visit.
+func openBrowser(u string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", u)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", u)
+	default:
+		cmd = exec.Command("xdg-open", u)
+	}
+	if err := cmd.Start(); err == nil {
+		go cmd.Wait()
+	}
+}
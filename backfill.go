@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mtgjsonBase is where MTGJSON's files are.
+// See https://mtgjson.com/downloads/all-files/.
+const mtgjsonBase = "https://mtgjson.com/api/v5"
+
+// backfill implements the "backfill" subcommand.
+// It fills in the history
+// (see history.go)
+// for the days before the first recorded run,
+// using the daily price archives from MTGJSON,
+// so that the dashboard and the chart
+// (see -chart-sheet)
+// start out with months of data
+// instead of just today's.
+//
+// The value for each day is computed the way a run computes it
+// (see runner.addValue):
+// the total of the prices of the rows in the selected sheets
+// (adjusted for condition, and leaving out proxies),
+// using the rows' current contents.
+// MTGJSON's prices,
+// like scryfall's,
+// are TCGplayer's.
+// Rows are matched to MTGJSON's cards by set code, name, and collector number,
+// so rows with no set code can't be.
+// Those,
+// and rows whose cards have no price for a day,
+// count at their current price.
+//
+// MTGJSON's price archive covers the last 90 days,
+// and it's big
+// (several hundred megabytes compressed),
+// so this takes a while.
+// The base URL can be changed for a mirror.
+// With dryRun,
+// the days and values are printed instead of added to the history.
+func (r *runner) backfill(ctx context.Context, dryRun bool, baseURL string, _ []string) error {
+	if r.history == nil && !dryRun {
+		return fmt.Errorf("no history to backfill (see -history)")
+	}
+
+	rows, err := r.readCollection(ctx)
+	if err != nil {
+		return err
+	}
+
+	client := &http.Client{Transport: apiTransport}
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	// Find the MTGJSON UUID of each row's card,
+	// one set at a time.
+	var (
+		bfRows   []*backfillRow
+		bySet    = make(map[string][]*backfillRow)
+		uuids    = make(map[string][]*backfillRow)
+		noSet    int
+		notFound int
+	)
+	for _, row := range rows {
+		if row.Proxy {
+			continue
+		}
+		br := &backfillRow{collectionRow: row, condMult: 1}
+		if row.Price != nil {
+			br.current = *row.Price
+		}
+		if m, ok := r.cfg.conditionMultiplier(row.Condition); ok {
+			br.condMult = m
+		}
+		bfRows = append(bfRows, br)
+		if row.SetCode == "" {
+			noSet++
+			continue
+		}
+		code := strings.ToUpper(unalias(row.SetCode))
+		bySet[code] = append(bySet[code], br)
+	}
+	for code, setRows := range bySet {
+		cards, err := mtgjsonSetCards(ctx, client, baseURL, code)
+		if err != nil {
+			slog.Warn("Could not get MTGJSON set", "set", code, "err", err)
+			notFound += len(setRows)
+			continue
+		}
+		for _, br := range setRows {
+			uuid := cards.uuid(br.CardName, br.CollectorNumber)
+			if uuid == "" {
+				notFound++
+				continue
+			}
+			uuids[uuid] = append(uuids[uuid], br)
+		}
+	}
+	if noSet > 0 || notFound > 0 {
+		slog.Info("Some rows will count at their current prices", "no_set_code", noSet, "not_in_mtgjson", notFound)
+	}
+	if len(uuids) == 0 {
+		return fmt.Errorf("no rows match MTGJSON's cards")
+	}
+
+	dates, err := mtgjsonPrices(ctx, client, baseURL+"/AllPrices.json.gz", uuids)
+	if err != nil {
+		return err
+	}
+
+	// Make a run for each day,
+	// using the prices in effect that day.
+	// (MTGJSON may skip a day for some cards.)
+	var runs []historyRun
+	for _, date := range dates {
+		when, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			return fmt.Errorf("parsing MTGJSON date %q: %w", date, err)
+		}
+		var value float64
+		for _, br := range bfRows {
+			value += br.priceOn(date)
+		}
+		runs = append(runs, historyRun{
+			Start:      when,
+			End:        when,
+			Value:      value,
+			Backfilled: true,
+		})
+	}
+
+	if dryRun {
+		for _, run := range runs {
+			fmt.Printf("%s\t%.2f\n", run.End.Format("2006-01-02"), run.Value)
+		}
+		return nil
+	}
+
+	n, dropped, err := r.history.backfill(runs)
+	if err != nil {
+		return err
+	}
+	if dropped > 0 {
+		slog.Warn("History is full, not adding the earliest days", "days", dropped, "limit", maxHistoryRuns)
+	}
+	slog.Info("Backfilled history", "days", n)
+	if n > 0 {
+		r.refreshChart(ctx)
+	}
+	return nil
+}
+
+// A backfillRow is a row of the collection,
+// with its price history from MTGJSON.
+type backfillRow struct {
+	collectionRow
+	current  float64            // The row's current price.
+	condMult float64            // The multiplier for the card's condition.
+	prices   map[string]float64 // Keyed by date, as YYYY-MM-DD. Not adjusted for condition.
+	dates    []string           // The keys of prices, in order.
+}
+
+// priceOn tells what the row's price was on the given date
+// (YYYY-MM-DD):
+// the latest price on or before that date,
+// or failing that,
+// the earliest one after it,
+// or failing that,
+// the row's current price.
+func (br *backfillRow) priceOn(date string) float64 {
+	if len(br.dates) == 0 {
+		return br.current
+	}
+	i := sort.SearchStrings(br.dates, date)
+	switch {
+	case i < len(br.dates) && br.dates[i] == date:
+	case i > 0:
+		i--
+	}
+	return br.prices[br.dates[i]] * br.condMult
+}
+
+// mtgjsonCards maps the cards of an MTGJSON set
+// from lowercase name to the card's printings in the set.
+type mtgjsonCards map[string][]mtgjsonCard
+
+type mtgjsonCard struct {
+	UUID     string `json:"uuid"`
+	Name     string `json:"name"`     // The full name, like "Fire // Ice."
+	FaceName string `json:"faceName"` // For a card with more than one face, this face's name.
+	Number   string `json:"number"`
+	Side     string `json:"side"` // For a card with more than one face, "a," "b," etc.
+}
+
+// uuid returns the MTGJSON UUID of the card with the given name
+// (or the name of its front face)
+// and, if it's not empty,
+// collector number.
+// With no number,
+// it's the first printing in the set.
+// The result is empty if there's no such card.
+func (c mtgjsonCards) uuid(name, number string) string {
+	cards := c[strings.ToLower(normalizeFaces(name))]
+	for _, card := range cards {
+		if number == "" || strings.EqualFold(card.Number, strings.TrimSpace(number)) {
+			return card.UUID
+		}
+	}
+	return ""
+}
+
+// mtgjsonSetCards gets the cards
+// (and tokens)
+// in the MTGJSON set with the given code.
+func mtgjsonSetCards(ctx context.Context, client *http.Client, baseURL, code string) (mtgjsonCards, error) {
+	var set struct {
+		Data struct {
+			Cards  []mtgjsonCard `json:"cards"`
+			Tokens []mtgjsonCard `json:"tokens"`
+		} `json:"data"`
+	}
+	if err := mtgjsonGet(ctx, client, baseURL+"/"+code+".json", func(rd io.Reader) error {
+		return json.NewDecoder(rd).Decode(&set)
+	}); err != nil {
+		return nil, err
+	}
+
+	result := make(mtgjsonCards)
+	for _, card := range append(set.Data.Cards, set.Data.Tokens...) {
+		if card.Side != "" && card.Side != "a" {
+			// Prices are for the card as a whole,
+			// under the UUID of its front face.
+			continue
+		}
+		key := strings.ToLower(card.Name)
+		result[key] = append(result[key], card)
+		if card.FaceName != "" {
+			key := strings.ToLower(card.FaceName)
+			result[key] = append(result[key], card)
+		}
+	}
+	return result, nil
+}
+
+// mtgjsonPrices reads MTGJSON's price archive at the given URL,
+// recording the TCGplayer retail prices of the cards with the given UUIDs
+// in their rows
+// (in the finish of each row;
+// see pricesObj.price).
+// It returns all the dates that have prices,
+// in order.
+//
+// The archive is far too big to decode all at once,
+// so it's decoded a card at a time,
+// keeping only the ones in uuids.
+func mtgjsonPrices(ctx context.Context, client *http.Client, u string, uuids map[string][]*backfillRow) ([]string, error) {
+	// The price data for a card,
+	// keyed by finish
+	// ("normal," "foil," or "etched")
+	// and then by date.
+	type cardPrices struct {
+		Paper struct {
+			TCGplayer struct {
+				Retail map[string]map[string]float64 `json:"retail"`
+			} `json:"tcgplayer"`
+		} `json:"paper"`
+	}
+
+	allDates := make(map[string]bool)
+
+	err := mtgjsonGet(ctx, client, u, func(rd io.Reader) error {
+		dec := json.NewDecoder(rd)
+		if _, err := dec.Token(); err != nil { // The opening {.
+			return err
+		}
+		for dec.More() {
+			tok, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			if tok != "data" {
+				// E.g. "meta."
+				var skip json.RawMessage
+				if err := dec.Decode(&skip); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if _, err := dec.Token(); err != nil { // The opening { of data.
+				return err
+			}
+			for dec.More() {
+				tok, err := dec.Token()
+				if err != nil {
+					return err
+				}
+				uuid, _ := tok.(string)
+				rows, ok := uuids[uuid]
+				if !ok {
+					var skip json.RawMessage
+					if err := dec.Decode(&skip); err != nil {
+						return err
+					}
+					continue
+				}
+				var cp cardPrices
+				if err := dec.Decode(&cp); err != nil {
+					return fmt.Errorf("decoding prices for %s: %w", uuid, err)
+				}
+				retail := cp.Paper.TCGplayer.Retail
+				for _, br := range rows {
+					byDate := retail["normal"]
+					if br.Foil {
+						byDate = retail["foil"]
+						if len(byDate) == 0 {
+							byDate = retail["etched"]
+						}
+					}
+					br.prices = byDate
+					br.dates = nil
+					for date := range byDate {
+						br.dates = append(br.dates, date)
+						allDates[date] = true
+					}
+					sort.Strings(br.dates)
+				}
+			}
+			if _, err := dec.Token(); err != nil { // The closing } of data.
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("reading MTGJSON prices: %w", err)
+	}
+
+	var dates []string
+	for date := range allDates {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return dates, nil
+}
+
+// mtgjsonGet gets the MTGJSON file at the given URL
+// and passes its contents to read.
+// A file whose name ends in .gz is decompressed.
+func mtgjsonGet(ctx context.Context, client *http.Client, u string, read func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("creating request for %s: %w", u, err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("getting %s: %w", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return apiStatusError("mtgjson", resp.StatusCode, "")
+	}
+
+	var rd io.Reader = bufio.NewReader(resp.Body)
+	if strings.HasSuffix(u, ".gz") {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			return fmt.Errorf("decompressing %s: %w", u, err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+	return read(rd)
+}
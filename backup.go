@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// backupPrefix begins the title of each backup sheet made by backupSheets.
+// Sheets with titles like this are skipped by "-sheetname all" and the like
+// (see resolveSheetNames).
+const backupPrefix = "majic backup "
+
+// backupTitle is the title of a backup of the named sheet
+// made at the given time,
+// e.g. "majic backup (Cards) 2024-05-01 09:30:00."
+// Titles of backups of the same sheet sort in time order.
+func backupTitle(sheetName string, t time.Time) string {
+	return backupPrefix + "(" + sheetName + ") " + t.Format("2006-01-02 15:04:05")
+}
+
+// backupSheets copies each of the named sheets,
+// before a run writes anything to them
+// (see -backup),
+// so that whatever the run does can be undone by hand
+// even if "majic rollback" can't help
+// (for instance, after a -sort or dedupe).
+//
+// Each copy is a hidden sheet in the same spreadsheet,
+// made with the Sheets API's duplicateSheet request,
+// which preserves formatting, formulas, and notes
+// and needs no more permissions than majic already has.
+// (Copying the whole file with the Drive API would need Drive access too.)
+//
+// Only the newest r.backups backups of each sheet are kept;
+// older ones are deleted.
+func (r *runner) backupSheets(ctx context.Context, sheetNames []string) error {
+	ss, err := r.svc.get(ctx, r.sheetKey, "sheets.properties(sheetId,title)")
+	if err != nil {
+		return fmt.Errorf("listing sheets: %w", err)
+	}
+
+	var (
+		now     = time.Now()
+		copies  []*sheets.Request
+		backups = make(map[string][]*sheets.SheetProperties) // Existing backups of each sheet.
+	)
+	for _, sh := range ss.Sheets {
+		if strings.HasPrefix(sh.Properties.Title, backupPrefix) {
+			continue
+		}
+		prefix := backupPrefix + "(" + sh.Properties.Title + ") "
+		for _, other := range ss.Sheets {
+			if strings.HasPrefix(other.Properties.Title, prefix) {
+				backups[sh.Properties.Title] = append(backups[sh.Properties.Title], other.Properties)
+			}
+		}
+	}
+
+	var titles []string
+	for _, name := range sheetNames {
+		var props *sheets.SheetProperties
+		for _, sh := range ss.Sheets {
+			if name == "" || sh.Properties.Title == name {
+				props = sh.Properties
+				break
+			}
+		}
+		if props == nil {
+			return fmt.Errorf("no sheet named %q", name)
+		}
+		req := &sheets.DuplicateSheetRequest{
+			SourceSheetId:    props.SheetId,
+			NewSheetName:     backupTitle(props.Title, now),
+			InsertSheetIndex: int64(len(ss.Sheets) + len(copies)), // At the end.
+		}
+		req.ForceSendFields = []string{"SourceSheetId"}
+		copies = append(copies, &sheets.Request{DuplicateSheet: req})
+		titles = append(titles, props.Title)
+	}
+
+	resp, err := r.svc.batchUpdate(ctx, r.sheetKey, &sheets.BatchUpdateSpreadsheetRequest{Requests: copies})
+	if err != nil {
+		return fmt.Errorf("copying sheets: %w", err)
+	}
+
+	// Hide the new copies,
+	// so they don't clutter the spreadsheet's tabs,
+	// and delete the oldest backups.
+	var reqs []*sheets.Request
+	for i, reply := range resp.Replies {
+		if reply.DuplicateSheet == nil {
+			continue
+		}
+		props := reply.DuplicateSheet.Properties
+		slog.Info("Backed up sheet", "sheet", titles[i], "backup", props.Title)
+		hidden := &sheets.SheetProperties{SheetId: props.SheetId, Hidden: true}
+		hidden.ForceSendFields = []string{"SheetId"}
+		reqs = append(reqs, &sheets.Request{
+			UpdateSheetProperties: &sheets.UpdateSheetPropertiesRequest{Properties: hidden, Fields: "hidden"},
+		})
+	}
+	for _, title := range titles {
+		old := backups[title]
+		sort.Slice(old, func(i, j int) bool { return old[i].Title < old[j].Title })
+		// There's one more backup now,
+		// so keep r.backups-1 of the old ones.
+		for len(old) > r.backups-1 {
+			del := &sheets.DeleteSheetRequest{SheetId: old[0].SheetId}
+			del.ForceSendFields = []string{"SheetId"}
+			reqs = append(reqs, &sheets.Request{DeleteSheet: del})
+			old = old[1:]
+		}
+	}
+	if err := r.batchUpdate(ctx, reqs...); err != nil {
+		return fmt.Errorf("hiding and pruning backups: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import "sync/atomic"
+
+// A lookupBudget limits how many calls to the card APIs a run may make
+// (see -max-lookups).
+// That lets someone on a slow or metered connection
+// price a big collection a piece at a time,
+// over several runs.
+//
+// The card APIs' rateLimitedRoundTrippers spend from the budget,
+// one unit per call
+// (so a cached response costs nothing).
+// Once it's used up,
+// processSheet stops starting new rows,
+// writes the updates it has,
+// and the run ends early but successfully.
+// The rows it didn't get to are still due,
+// so the next run gets to them
+// (skipping the rows priced recently,
+// or starting with the stalest ones; see runner.rowOrder).
+//
+// A nil *lookupBudget is unlimited.
+type lookupBudget struct {
+	max  int64 // Zero for no limit.
+	used atomic.Int64
+}
+
+// spend records one API call.
+func (b *lookupBudget) spend() {
+	if b != nil {
+		b.used.Add(1)
+	}
+}
+
+// exhausted tells whether the budget has been used up.
+func (b *lookupBudget) exhausted() bool {
+	return b != nil && b.max > 0 && b.used.Load() >= b.max
+}
+
+// reset restores the full budget,
+// at the start of a run.
+func (b *lookupBudget) reset() {
+	if b != nil {
+		b.used.Store(0)
+	}
+}
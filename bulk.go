@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobg/subcmd/v2"
+)
+
+// Scryfall publishes its whole database once a day as "bulk data"
+// (see https://scryfall.com/docs/api/bulk-data).
+// With -bulk,
+// majic looks cards up in a local copy of the "default cards" file
+// (one entry per printing, in English where there is one)
+// before resorting to the API,
+// which makes a run over a big collection much faster.
+// The copy is made and refreshed with "majic bulk sync,"
+// which downloads the file only when scryfall has published a new one.
+const (
+	bulkDataPath      = "/bulk-data/default-cards"
+	bulkDataFilename  = "default-cards.json"
+	bulkIndexFilename = "bulk-index.json"
+	bulkStateFilename = "bulk-state.json"
+)
+
+// bulkInfo is scryfall's description of a bulk-data file.
+type bulkInfo struct {
+	DownloadURI string    `json:"download_uri"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// A bulkIndex maps bulkKey(name, setCode) to the card with that name in that set.
+type bulkIndex map[string]*respObj
+
+func bulkKey(name, setCode string) string {
+	return strings.ToLower(name) + "|" + strings.ToLower(setCode)
+}
+
+// lookup finds the card with the given name in the given set,
+// if it's in the index.
+// Rows with no set code aren't looked up,
+// since which printing the API would choose for them
+// isn't something the index can tell.
+func (idx bulkIndex) lookup(name, setCode string) (*respObj, bool) {
+	if idx == nil || setCode == "" {
+		return nil, false
+	}
+	obj, ok := idx[bulkKey(normalizeFaces(name), unalias(setCode))]
+	return obj, ok
+}
+
+// add adds a card to the index,
+// under its full name
+// and, for a card with more than one face,
+// under the name of its front face too
+// (as namedCard accepts).
+func (idx bulkIndex) add(obj *respObj) {
+	idx[bulkKey(obj.Name, obj.Set)] = obj
+	if len(obj.CardFaces) > 0 {
+		key := bulkKey(obj.CardFaces[0].Name, obj.Set)
+		if _, ok := idx[key]; !ok {
+			idx[key] = obj
+		}
+	}
+}
+
+// loadBulkIndex reads the index written by "majic bulk sync."
+func loadBulkIndex() (bulkIndex, error) {
+	filename, err := cacheFile(bulkIndexFilename)
+	if err != nil {
+		return nil, fmt.Errorf("finding cache directory: %w", err)
+	}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no bulk data; run \"majic bulk sync\" first")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	var idx bulkIndex
+	if err := json.NewDecoder(bufio.NewReader(f)).Decode(&idx); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return idx, nil
+}
+
+// bulk implements the "bulk" subcommand,
+// which has subcommands of its own.
+func (r *runner) bulk(ctx context.Context, args []string) error {
+	return subcmd.Run(ctx, bulkCmd{r: r}, args)
+}
+
+type bulkCmd struct {
+	r *runner
+}
+
+func (c bulkCmd) Subcmds() subcmd.Map {
+	return subcmd.Commands(
+		"sync", c.r.bulkSync, "download scryfall's bulk card data for use with -bulk, if there's a new version", subcmd.Params(
+			"-force", subcmd.Bool, false, "download and index the data even if it hasn't changed",
+		),
+	)
+}
+
+// bulkState records what "majic bulk sync" last downloaded,
+// so the next one can tell whether there's anything new.
+// It's kept in the user cache directory
+// alongside the data and its index.
+type bulkState struct {
+	// UpdatedAt is the bulkInfo.UpdatedAt of the data that's been indexed.
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// ETag is the HTTP entity tag of the downloaded data file,
+	// for asking the server whether it has changed.
+	ETag string `json:"etag,omitempty"`
+
+	// PartETag is the entity tag of the data in an incomplete download,
+	// for making sure the rest of the download is of the same version.
+	PartETag string `json:"part_etag,omitempty"`
+}
+
+func readBulkState(filename string) (*bulkState, error) {
+	var st bulkState
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filename, err)
+	}
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return &st, nil
+}
+
+func (st *bulkState) write(filename string) error {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return fmt.Errorf("encoding bulk-data state: %w", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	return nil
+}
+
+// bulkSync implements "majic bulk sync."
+//
+// If scryfall has published new bulk data since the last sync
+// (or if force is true),
+// it downloads the data
+// (see downloadBulk)
+// and indexes it
+// (see indexBulk).
+//
+// The new index is written to a temporary file
+// and renamed into place only once it's complete,
+// so a run using -bulk in the meantime
+// (or after a failed sync)
+// still finds the previous index.
+func (r *runner) bulkSync(ctx context.Context, force bool, _ []string) error {
+	var info bulkInfo
+	if err := r.scryfall.get(ctx, bulkDataPath, nil, &info); err != nil {
+		return fmt.Errorf("getting bulk-data information: %w", err)
+	}
+
+	var dataFile, indexFile, stateFile string
+	for _, x := range []struct {
+		name string
+		dest *string
+	}{
+		{name: bulkDataFilename, dest: &dataFile},
+		{name: bulkIndexFilename, dest: &indexFile},
+		{name: bulkStateFilename, dest: &stateFile},
+	} {
+		filename, err := cacheFile(x.name)
+		if err != nil {
+			return fmt.Errorf("finding cache directory: %w", err)
+		}
+		*x.dest = filename
+	}
+
+	st, err := readBulkState(stateFile)
+	if err != nil {
+		return err
+	}
+	if force {
+		st.ETag = ""
+	} else if st.UpdatedAt.Equal(info.UpdatedAt) && fileExists(indexFile) {
+		slog.Info("Bulk data is up to date", "updated_at", info.UpdatedAt)
+		return nil
+	}
+
+	changed, err := downloadBulk(ctx, info.DownloadURI, dataFile, st, stateFile)
+	if err != nil {
+		return err
+	}
+	if !changed && fileExists(indexFile) {
+		// Scryfall has updated its description of the data,
+		// but not the data itself.
+		slog.Info("Bulk data is unchanged")
+		st.UpdatedAt = info.UpdatedAt
+		return st.write(stateFile)
+	}
+
+	idx, err := indexBulk(dataFile)
+	if err != nil {
+		return err
+	}
+	for _, obj := range idx {
+		obj.PriceSource, obj.PricedAt = sourceScryfallBulk, info.UpdatedAt
+	}
+
+	tmpFile := indexFile + ".tmp"
+	if err := writeBulkIndex(tmpFile, idx); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpFile, indexFile); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmpFile, indexFile, err)
+	}
+	slog.Info("Indexed bulk data", "cards", len(idx), "updated_at", info.UpdatedAt)
+
+	st.UpdatedAt = info.UpdatedAt
+	return st.write(stateFile)
+}
+
+func writeBulkIndex(filename string, idx bulkIndex) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	if err := json.NewEncoder(w).Encode(idx); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	return f.Close()
+}
+
+func fileExists(filename string) bool {
+	_, err := os.Stat(filename)
+	return err == nil
+}
+
+// downloadBulk downloads the bulk-data file at the given URL into filename,
+// if it has changed since the download recorded in st.
+// It reports whether it did.
+// It updates st as it goes,
+// saving it in stateFile.
+//
+// The file is hundreds of megabytes,
+// so downloadBulk asks for it compressed
+// (and stores it that way),
+// and writes it to filename+".part" as it arrives.
+// If that file already exists
+// (because an earlier download was interrupted),
+// downloadBulk asks the server for just the rest of it,
+// provided it's still the same version
+// (according to its ETag).
+// When the download is complete,
+// the .part file is renamed to filename.
+func downloadBulk(ctx context.Context, u, filename string, st *bulkState, stateFile string) (bool, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return false, fmt.Errorf("creating directory for %s: %w", filename, err)
+	}
+	partFile := filename + ".part"
+
+	var offset int64
+	if fi, err := os.Stat(partFile); err == nil && st.PartETag != "" {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return false, fmt.Errorf("creating bulk-data request: %w", err)
+	}
+
+	// Setting Accept-Encoding ourselves
+	// keeps the transport from decompressing the response,
+	// so byte offsets for resuming refer to the same bytes as the stored file.
+	req.Header.Set("Accept-Encoding", "gzip")
+	switch {
+	case offset > 0:
+		// If the file has changed since the partial download,
+		// If-Range makes the server send the whole new file instead of the rest.
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		req.Header.Set("If-Range", st.PartETag)
+	case st.ETag != "" && fileExists(filename):
+		req.Header.Set("If-None-Match", st.ETag)
+	}
+
+	// This client has no timeout
+	// (unlike the one for card lookups; see -request-timeout),
+	// since the download can take a while.
+	client := &http.Client{Transport: apiTransport}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("downloading bulk data: %w", err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_WRONLY | os.O_CREATE
+	switch resp.StatusCode {
+	case http.StatusNotModified:
+		return false, nil
+
+	case http.StatusPartialContent:
+		if isGzip(partFile) != (resp.Header.Get("Content-Encoding") == "gzip") {
+			// The rest of the file is coming in a different encoding from the first part.
+			// Start over.
+			resp.Body.Close()
+			st.PartETag = ""
+			return downloadBulk(ctx, u, filename, st, stateFile)
+		}
+		slog.Info("Resuming bulk-data download", "offset", offset)
+		flags |= os.O_APPEND
+
+	case http.StatusOK:
+		// A fresh download,
+		// or the server ignored the Range header,
+		// or the file changed since the partial download.
+		flags |= os.O_TRUNC
+		st.PartETag = resp.Header.Get("ETag")
+		if err := st.write(stateFile); err != nil {
+			return false, err
+		}
+
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The .part file is already complete.
+
+	default:
+		return false, apiStatusError("scryfall bulk data", resp.StatusCode, "")
+	}
+
+	if resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		f, err := os.OpenFile(partFile, flags, 0644)
+		if err != nil {
+			return false, fmt.Errorf("opening %s: %w", partFile, err)
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(f, resp.Body); err != nil {
+			// Keep the .part file for next time.
+			return false, fmt.Errorf("downloading bulk data: %w", err)
+		}
+		if err := f.Close(); err != nil {
+			return false, fmt.Errorf("closing %s: %w", partFile, err)
+		}
+	}
+
+	if err := os.Rename(partFile, filename); err != nil {
+		return false, fmt.Errorf("renaming %s to %s: %w", partFile, filename, err)
+	}
+	st.ETag, st.PartETag = st.PartETag, ""
+	return true, st.write(stateFile)
+}
+
+// isGzip tells whether the named file begins with the gzip "magic number."
+func isGzip(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic, err := bufio.NewReader(f).Peek(2)
+	return err == nil && magic[0] == 0x1f && magic[1] == 0x8b
+}
+
+// indexBulk reads the bulk-data file in filename
+// (compressed or not)
+// and builds a bulkIndex from it.
+// The file is a single JSON array,
+// which indexBulk decodes one element at a time
+// rather than reading it all into memory first.
+func indexBulk(filename string) (bulkIndex, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	var rd io.Reader = bufio.NewReader(f)
+	if isGzip(filename) {
+		gz, err := gzip.NewReader(rd)
+		if err != nil {
+			return nil, fmt.Errorf("decompressing %s: %w", filename, err)
+		}
+		defer gz.Close()
+		rd = gz
+	}
+
+	dec := json.NewDecoder(rd)
+	if _, err := dec.Token(); err != nil { // The opening [.
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	idx := make(bulkIndex)
+	for dec.More() {
+		var obj respObj
+		if err := dec.Decode(&obj); err != nil {
+			return nil, fmt.Errorf("decoding %s: %w", filename, err)
+		}
+		idx.add(&obj)
+	}
+	return idx, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// cardKingdomPricelistURL is where Card Kingdom publishes its prices,
+// including what it pays for each card (its buylist).
+// Each entry includes the card's scryfall ID,
+// so there's no need to match names and sets.
+const cardKingdomPricelistURL = "https://api.cardkingdom.com/api/pricelist"
+
+// buylistCacheMaxAge is how long a downloaded buylist is used before fetching it again.
+// Card Kingdom updates its prices daily.
+const buylistCacheMaxAge = 24 * time.Hour
+
+// A buylistClient gets buylist prices,
+// for the optional Buylist column.
+type buylistClient struct {
+	client *http.Client
+	url    string
+}
+
+// buylistPrices are the prices from a buylist,
+// keyed by buylistKey.
+// The dealer's retail prices are kept too,
+// for comparing with other sources
+// (see compare.go).
+type buylistPrices struct {
+	Fetched time.Time          `json:"fetched"`
+	Prices  map[string]float64 `json:"prices"`
+	Retail  map[string]float64 `json:"retail"`
+}
+
+func buylistKey(scryfallID string, foil bool) string {
+	return fmt.Sprintf("%s|%v", scryfallID, foil)
+}
+
+// price returns the buylist price of the card with the given scryfall ID,
+// in the given finish.
+// The boolean result is false if the card isn't on the buylist.
+func (p *buylistPrices) price(scryfallID string, foil bool) (float64, bool) {
+	if p == nil || scryfallID == "" {
+		return 0, false
+	}
+	price, ok := p.Prices[buylistKey(scryfallID, foil)]
+	return price, ok
+}
+
+// retail returns the dealer's selling price for the card with the given scryfall ID,
+// in the given finish.
+// The boolean result is false if the dealer doesn't list the card.
+func (p *buylistPrices) retail(scryfallID string, foil bool) (float64, bool) {
+	if p == nil || scryfallID == "" {
+		return 0, false
+	}
+	price, ok := p.Retail[buylistKey(scryfallID, foil)]
+	return price, ok
+}
+
+// prices returns the buylist,
+// from the on-disk cache if it's fresh enough
+// (as with the set list; see scryfallClient.sets)
+// and from Card Kingdom otherwise.
+func (bc *buylistClient) prices(ctx context.Context) (*buylistPrices, error) {
+	filename, err := cacheFile("buylist.json")
+	if err != nil {
+		slog.Debug("No cache for buylist", "err", err)
+	} else if p, err := readBuylist(filename); err == nil && p.Retail != nil && time.Since(p.Fetched) < buylistCacheMaxAge {
+		return p, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", bc.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating buylist request: %w", err)
+	}
+	resp, err := bc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getting buylist: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getting buylist: %w", apiStatusError("Card Kingdom", resp.StatusCode, ""))
+	}
+
+	// Card Kingdom writes numbers and booleans as strings.
+	var list struct {
+		Data []struct {
+			ScryfallID  string `json:"scryfall_id"`
+			IsFoil      string `json:"is_foil"`
+			PriceRetail string `json:"price_retail"`
+			PriceBuy    string `json:"price_buy"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("decoding buylist: %w", err)
+	}
+
+	p := &buylistPrices{
+		Fetched: time.Now(),
+		Prices:  make(map[string]float64),
+		Retail:  make(map[string]float64),
+	}
+	for _, d := range list.Data {
+		if d.ScryfallID == "" {
+			continue
+		}
+		key := buylistKey(d.ScryfallID, isTrue(d.IsFoil))
+		if price, err := strconv.ParseFloat(d.PriceBuy, 64); err == nil && price > 0 {
+			p.Prices[key] = price
+		}
+		if price, err := strconv.ParseFloat(d.PriceRetail, 64); err == nil && price > 0 {
+			p.Retail[key] = price
+		}
+	}
+
+	if filename != "" {
+		if err := p.write(filename); err != nil {
+			slog.Warn("Could not cache buylist", "err", err)
+		}
+	}
+
+	return p, nil
+}
+
+func readBuylist(filename string) (*buylistPrices, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var p buylistPrices
+	if err := json.NewDecoder(f).Decode(&p); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return &p, nil
+}
+
+func (p *buylistPrices) write(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", filename, err)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(p); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	return f.Close()
+}
+
+// buylistReport implements the "buylist" subcommand.
+// It lists the cards in the selected sheets
+// (see readCollection)
+// whose Buylist price is at least the given percentage of their Price,
+// best first.
+// Those are the ones it might make sense to sell.
+// (Proxies aren't listed, since they can't be sold.)
+func (r *runner) buylistReport(ctx context.Context, percent float64, _ []string) error {
+	rows, err := r.readCollection(ctx)
+	if err != nil {
+		return err
+	}
+
+	type sellable struct {
+		row     collectionRow
+		buylist float64
+		ratio   float64
+	}
+	var result []sellable
+	for _, row := range rows {
+		if row.Price == nil || *row.Price <= 0 || row.Proxy {
+			continue
+		}
+		buylist, ok := parsePrice(row.column(r.cfg.heading(buylistField)))
+		if !ok {
+			continue
+		}
+		if ratio := buylist / *row.Price; 100*ratio >= percent {
+			result = append(result, sellable{row: row, buylist: buylist, ratio: ratio})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].ratio > result[j].ratio
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Card\tSet\tFoil\tQuantity\tPrice\tBuylist\t%")
+	for _, s := range result {
+		var foil string
+		if s.row.Foil {
+			foil = "foil"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.2f\t%.2f\t%.0f%%\n", s.row.CardName, s.row.SetCode, foil, s.row.Quantity, *s.row.Price, s.buylist, 100*s.ratio)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A responseCache holds scryfall responses,
+// keyed by card identity
+// (see cacheKey),
+// so that rows for the same card,
+// and re-runs within the cache's time-to-live,
+// don't need another API call.
+// It's saved as a JSON file between runs.
+//
+// A nil *responseCache is a valid cache that holds nothing.
+type responseCache struct {
+	filename string
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	dirty   bool // Whether entries has changed since being loaded or saved.
+}
+
+type cacheEntry struct {
+	Fetched time.Time `json:"fetched"`
+	Card    *respObj  `json:"card"`
+}
+
+// loadResponseCache loads the cache in the given file.
+// It's not an error for the file not to exist.
+func loadResponseCache(filename string, ttl time.Duration) (*responseCache, error) {
+	c := &responseCache{
+		filename: filename,
+		ttl:      ttl,
+		entries:  make(map[string]cacheEntry),
+	}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&c.entries); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// cacheKey makes a cache key from the parts of a card's identity
+// (name, set code, collector number, etc.).
+// Parts are compared case-insensitively.
+func cacheKey(parts ...string) string {
+	for i, p := range parts {
+		parts[i] = strings.ToLower(strings.TrimSpace(p))
+	}
+	return strings.Join(parts, "|")
+}
+
+// get returns the cached response for the given key,
+// or nil if there isn't one that's fresh enough.
+func (c *responseCache) get(key string) *respObj {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || time.Since(e.Fetched) > c.ttl {
+		return nil
+	}
+	return e.Card
+}
+
+// put adds a response to the cache.
+func (c *responseCache) put(key string, card *respObj) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries[key] = cacheEntry{Fetched: time.Now(), Card: card}
+	c.dirty = true
+}
+
+// save writes the cache to its file,
+// leaving out expired entries.
+func (c *responseCache) save() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.dirty {
+		return nil
+	}
+
+	for k, e := range c.entries {
+		if time.Since(e.Fetched) > c.ttl {
+			delete(c.entries, k)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(c.filename), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", c.filename, err)
+	}
+
+	// Write to a temporary file and rename it,
+	// so an interrupted save doesn't leave a corrupt cache.
+	tmpname := c.filename + ".tmp"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", tmpname, err)
+	}
+	defer os.Remove(tmpname)
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(c.entries); err != nil {
+		return fmt.Errorf("writing %s: %w", tmpname, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tmpname, err)
+	}
+	if err := os.Rename(tmpname, c.filename); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmpname, c.filename, err)
+	}
+
+	c.dirty = false
+	return nil
+}
+
+// cacheFile returns the path of a file in majic's cache directory
+// (e.g. $HOME/.cache/majic on Linux).
+func cacheFile(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "majic", name), nil
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/bobg/majic/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// updateChart rewrites the sheet named by r.chartSheet
+// with the date and total value of each run in the history
+// (skipping failed and partial runs, as the dashboard does),
+// then adds a line chart of those values to the sheet,
+// or refreshes the one that's already there.
+// The sheet is created if it doesn't exist.
+//
+// This is called at the end of each full run,
+// after the run is recorded in the history.
+func (r *runner) updateChart(ctx context.Context) error {
+	ss, err := r.svc.get(ctx, r.sheetKey, "sheets(properties(sheetId,title),charts(chartId))")
+	if err != nil {
+		return fmt.Errorf("listing sheets: %w", err)
+	}
+	var (
+		sheetID int64
+		chartID int64 = -1
+		found   bool
+	)
+	for _, sh := range ss.Sheets {
+		if sh.Properties.Title != r.chartSheet {
+			continue
+		}
+		found = true
+		sheetID = sh.Properties.SheetId
+		if len(sh.Charts) > 0 {
+			chartID = sh.Charts[0].ChartId
+		}
+		break
+	}
+	if !found {
+		resp, err := r.svc.batchUpdate(ctx, r.sheetKey, &sheets.BatchUpdateSpreadsheetRequest{
+			Requests: []*sheets.Request{{
+				AddSheet: &sheets.AddSheetRequest{
+					Properties: &sheets.SheetProperties{Title: r.chartSheet},
+				},
+			}},
+		})
+		if err != nil {
+			return fmt.Errorf("adding sheet %q: %w", r.chartSheet, err)
+		}
+		sheetID = resp.Replies[0].AddSheet.Properties.SheetId
+	}
+
+	// Rewrite the data from scratch,
+	// since old runs drop out of the history.
+	values := [][]any{{"Date", "Value"}}
+	for _, run := range r.history.runs() {
+		if run.Err != "" || run.Partial {
+			continue
+		}
+		values = append(values, []any{run.End.Format("2006-01-02 15:04:05"), run.Value})
+	}
+	columns := a1.Range{Sheet: r.chartSheet, StartRow: -1, StartCol: 0, EndRow: -1, EndCol: 1}
+	if err := r.svc.clearValues(ctx, r.sheetKey, columns.String()); err != nil {
+		return fmt.Errorf("clearing sheet %q: %w", r.chartSheet, err)
+	}
+	// USER_ENTERED makes Google Sheets parse the dates as dates.
+	vr := &sheets.ValueRange{Values: values}
+	if err := r.svc.updateValues(ctx, r.sheetKey, a1.Cell(r.chartSheet, 0, 0), vr, "USER_ENTERED"); err != nil {
+		return fmt.Errorf("writing sheet %q: %w", r.chartSheet, err)
+	}
+
+	spec := valueChartSpec(sheetID, int64(len(values)))
+	req := &sheets.Request{}
+	if chartID >= 0 {
+		req.UpdateChartSpec = &sheets.UpdateChartSpecRequest{ChartId: chartID, Spec: spec}
+		req.UpdateChartSpec.ForceSendFields = []string{"ChartId"}
+	} else {
+		anchor := &sheets.GridCoordinate{SheetId: sheetID, RowIndex: 0, ColumnIndex: 3}
+		anchor.ForceSendFields = []string{"SheetId", "RowIndex"}
+		req.AddChart = &sheets.AddChartRequest{
+			Chart: &sheets.EmbeddedChart{
+				Spec: spec,
+				Position: &sheets.EmbeddedObjectPosition{
+					OverlayPosition: &sheets.OverlayPosition{AnchorCell: anchor},
+				},
+			},
+		}
+	}
+	if err := r.batchUpdate(ctx, req); err != nil {
+		return fmt.Errorf("updating chart: %w", err)
+	}
+	return nil
+}
+
+// valueChartSpec is the spec of a line chart of the first numRows rows
+// (including the heading)
+// of columns A (date) and B (value)
+// in the sheet with the given ID.
+func valueChartSpec(sheetID, numRows int64) *sheets.ChartSpec {
+	column := func(col int64) *sheets.ChartData {
+		gr := &sheets.GridRange{
+			SheetId:          sheetID,
+			StartRowIndex:    0,
+			EndRowIndex:      numRows,
+			StartColumnIndex: col,
+			EndColumnIndex:   col + 1,
+		}
+		gr.ForceSendFields = []string{"SheetId", "StartRowIndex", "StartColumnIndex"}
+		return &sheets.ChartData{
+			SourceRange: &sheets.ChartSourceRange{Sources: []*sheets.GridRange{gr}},
+		}
+	}
+	return &sheets.ChartSpec{
+		Title: "Collection value",
+		BasicChart: &sheets.BasicChartSpec{
+			ChartType:   "LINE",
+			HeaderCount: 1,
+			Axis: []*sheets.BasicChartAxis{
+				{Position: "BOTTOM_AXIS", Title: "Date"},
+				{Position: "LEFT_AXIS", Title: "Value"},
+			},
+			Domains: []*sheets.BasicChartDomain{{Domain: column(0)}},
+			Series:  []*sheets.BasicChartSeries{{Series: column(1), TargetAxis: "LEFT_AXIS"}},
+		},
+	}
+}
+
+// refreshChart calls updateChart,
+// if r.chartSheet is set,
+// logging any error.
+// Partial runs (see webhook.go) don't change the chart,
+// so they're skipped.
+func (r *runner) refreshChart(ctx context.Context) {
+	if r.chartSheet == "" || r.history == nil || r.only != nil {
+		return
+	}
+	if err := r.updateChart(ctx); err != nil {
+		slog.Warn("Could not update chart", "sheet", r.chartSheet, "err", err)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// A coalescingCatalog is a cardCatalog that shares lookups:
+// if it's asked for a card while a lookup of the same card is already in progress,
+// it waits for that one's result instead of calling the API again.
+// That happens when a run,
+// requests to the server's /cards endpoint
+// (see serve.go),
+// and rows arriving at /webhook
+// (see webhook.go)
+// all want the same card at about the same time.
+// (Once a lookup finishes,
+// later ones for the same card are satisfied by the response cache.)
+//
+// The shared lookup doesn't belong to any one caller,
+// so it isn't canceled along with the context of the caller that started it
+// (it has a time limit of its own instead).
+// Each caller stops waiting for it when the caller's own context is done.
+type coalescingCatalog struct {
+	cardCatalog
+	group singleflight.Group
+}
+
+// sharedLookupTimeout is the time limit for a lookup shared by coalescingCatalog callers.
+// It's generous because the lookup may wait its turn at the rate limiter
+// and make more than one API call.
+const sharedLookupTimeout = 2 * time.Minute
+
+func (c *coalescingCatalog) card(ctx context.Context, name, setCode, number, lang string, foil, cheapest bool) (*respObj, error) {
+	key := cacheKey(name, setCode, number, lang, fmt.Sprintf("foil=%v,cheapest=%v", foil, cheapest))
+	ch := c.group.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), sharedLookupTimeout)
+		defer cancel()
+		return c.cardCatalog.card(ctx, name, setCode, number, lang, foil, cheapest)
+	})
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-ch:
+		obj, _ := res.Val.(*respObj)
+		return obj, res.Err
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// blockingCatalog is a cardCatalog whose lookups wait for release to be closed.
+type blockingCatalog struct {
+	once    sync.Once
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingCatalog) card(ctx context.Context, name, _, _, _ string, _, _ bool) (*respObj, error) {
+	b.once.Do(func() { close(b.started) })
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-b.release:
+		return &respObj{Name: name}, nil
+	}
+}
+
+// TestCoalescingCatalogCancel checks that when the caller that started a shared lookup gives up,
+// the lookup goes on for the other callers.
+func TestCoalescingCatalogCancel(t *testing.T) {
+	b := &blockingCatalog{started: make(chan struct{}), release: make(chan struct{})}
+	c := &coalescingCatalog{cardCatalog: b}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	err1 := make(chan error)
+	go func() {
+		_, err := c.card(ctx1, "Opt", "", "", "", false, false)
+		err1 <- err
+	}()
+	<-b.started
+
+	type result struct {
+		obj *respObj
+		err error
+	}
+	res2 := make(chan result)
+	go func() {
+		obj, err := c.card(context.Background(), "Opt", "", "", "", false, false)
+		res2 <- result{obj: obj, err: err}
+	}()
+	time.Sleep(10 * time.Millisecond) // Let the second caller join the lookup.
+
+	cancel1()
+	if err := <-err1; !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v for the canceled caller, want %v", err, context.Canceled)
+	}
+
+	close(b.release)
+	res := <-res2
+	if res.err != nil {
+		t.Fatal(res.err)
+	}
+	if res.obj == nil || res.obj.Name != "Opt" {
+		t.Errorf("got %+v, want the card Opt", res.obj)
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A collectionRow is one row of a sheet,
+// as read by readCollection.
+type collectionRow struct {
+	Sheet       string   `json:"sheet,omitempty"`
+	Row         int      `json:"row"` // One-based, as displayed in Google Sheets.
+	CardName    string   `json:"card_name"`
+	SetCode     string   `json:"set_code,omitempty"`
+	Foil        bool     `json:"foil"`
+	Price       *float64 `json:"price,omitempty"` // Nil if there's no price.
+	LastUpdated string   `json:"last_updated,omitempty"`
+
+	// These are from optional columns.
+	Quantity        int      `json:"quantity"` // 1 if there's no Quantity column.
+	Condition       string   `json:"condition,omitempty"`
+	Language        string   `json:"language,omitempty"`
+	CollectorNumber string   `json:"collector_number,omitempty"`
+	PurchasePrice   *float64 `json:"purchase_price,omitempty"`
+	Proxy           bool     `json:"proxy,omitempty"` // See rowResult.proxy.
+
+	// Columns holds the contents of every column in the row,
+	// including the ones above,
+	// keyed by column heading.
+	Columns map[string]any `json:"columns"`
+}
+
+// readCollection reads the rows of all the selected sheets
+// (as with a normal run, respecting -sheetname, -range, -stop-at-blank, and -filter).
+// Rows with no card name are left out.
+func (r *runner) readCollection(ctx context.Context) ([]collectionRow, error) {
+	sheetNames, err := r.targetSheets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []collectionRow
+	for _, sheetName := range sheetNames {
+		rows, err := r.readSheetCollection(ctx, sheetName)
+		if err != nil {
+			return nil, fmt.Errorf("reading sheet %q: %w", sheetName, err)
+		}
+		result = append(result, rows...)
+	}
+	return result, nil
+}
+
+func (r *runner) readSheetCollection(ctx context.Context, sheetName string) ([]collectionRow, error) {
+	resp, err := r.readSheet(ctx, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Values) == 0 {
+		return nil, nil
+	}
+	rows, headerRow, endRow, err := r.layout(resp.Values)
+	if err != nil {
+		return nil, err
+	}
+	headings := rows[headerRow]
+	columnHeadings := headingCols(headings)
+
+	col := func(field string) int {
+		if c, ok := columnHeadings[strings.ToLower(r.cfg.heading(field))]; ok {
+			return c
+		}
+		return -1
+	}
+	cardNameCol := col(cardNameField)
+	if cardNameCol < 0 {
+		return nil, fmt.Errorf("no %q column", r.cfg.heading(cardNameField))
+	}
+
+	var filter *rowFilter
+	if r.filter != nil {
+		filter, err = r.filter.bind(func(field string) (int, bool) {
+			c := col(field)
+			return c, c >= 0
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var result []collectionRow
+	for rownum := headerRow + 1; rownum < endRow; rownum++ {
+		row := rows[rownum]
+		cell := func(c int) string {
+			if c < 0 || c >= len(row) {
+				return ""
+			}
+			return fmt.Sprint(row[c])
+		}
+
+		cr := collectionRow{
+			Sheet:       sheetName,
+			Row:         rownum + 1,
+			CardName:    cell(cardNameCol),
+			SetCode:     cell(col(setCodeField)),
+			Foil:        isTrue(cell(col(foilField))),
+			LastUpdated: cell(col(lastUpdatedField)),
+			Quantity:    1,
+
+			Condition:       cell(col(conditionField)),
+			Language:        cell(col(languageField)),
+			CollectorNumber: cell(col(collectorNumberField)),
+			Proxy:           isTrue(cell(col(proxyField))),
+
+			Columns: make(map[string]any),
+		}
+		if cr.CardName == "" {
+			continue
+		}
+		if filter != nil {
+			ok, err := filter.match(row)
+			if err != nil {
+				return nil, fmt.Errorf("evaluating filter in row %d: %w", rownum+1, err)
+			}
+			if !ok {
+				continue
+			}
+		}
+		if p, ok := parsePrice(cell(col(priceField))); ok && !r.priceFormat.isMissing(cell(col(priceField))) {
+			cr.Price = &p
+		}
+		if p, ok := parsePrice(cell(col(purchasePriceField))); ok {
+			cr.PurchasePrice = &p
+		}
+		if q, err := strconv.Atoi(strings.TrimSpace(cell(col(quantityField)))); err == nil {
+			cr.Quantity = q
+		}
+		for c, h := range headings {
+			heading, ok := h.(string)
+			if !ok || heading == "" || c >= len(row) {
+				continue
+			}
+			cr.Columns[heading] = row[c]
+		}
+
+		result = append(result, cr)
+	}
+
+	return result, nil
+}
+
+// column returns the contents of the column with the given heading,
+// ignoring upper- and lowercase differences,
+// or "" if there's no such column.
+func (cr collectionRow) column(heading string) string {
+	for h, val := range cr.Columns {
+		if strings.EqualFold(h, heading) {
+			return fmt.Sprint(val)
+		}
+	}
+	return ""
+}
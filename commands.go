@@ -0,0 +1,62 @@
+package main
+
+import "github.com/bobg/subcmd/v2"
+
+// Subcmds implements subcmd.Cmd.
+// It lists the subcommands that can follow the global flags on the command line,
+// to do something other than the usual price update.
+func (r *runner) Subcmds() subcmd.Map {
+	return subcmd.Commands(
+		"appscript", r.appScript, "write a Google Apps Script that adds a majic menu to the spreadsheet", subcmd.Params(
+			"-secret", subcmd.String, "", "webhook secret (default is the value of -webhook-secret)",
+			"-o", subcmd.String, "", "output file (default is standard output)",
+			"url", subcmd.String, "", "URL of the majic server, as reachable from Google",
+		),
+		"backfill", r.backfill, "fill in the history for the days before the first run, from MTGJSON's price archives", subcmd.Params(
+			"-n", subcmd.Bool, false, "print the values for each day instead of adding them to the history",
+			"-url", subcmd.String, mtgjsonBase, "base URL for MTGJSON's files",
+		),
+		"buylist", r.buylistReport, "list cards a dealer would buy for at least some percentage of their price", subcmd.Params(
+			"-percent", subcmd.Float64, 60.0, "minimum buylist price, as a percentage of the Price column",
+		),
+		"bulk", r.bulk, "manage the local copy of scryfall's card data (see -bulk)", nil,
+		"compare", r.compare, "compare each card's prices from different sources", subcmd.Params(
+			"-spread", subcmd.Float64, 25.0, "mark cards whose retail prices differ by at least this percentage",
+		),
+		"deck", r.deck, "work with decklists (see \"majic deck -help\")", nil,
+		"dedupe", r.dedupe, "merge rows for the same card, adding their quantities", subcmd.Params(
+			"-n", subcmd.Bool, false, "report duplicates without changing the sheet",
+		),
+		"export", r.export, "write the contents of the sheet in another format", subcmd.Params(
+			"-format", subcmd.String, "json", `output format: "json," "moxfield," "archidekt," or "deckbox"`,
+			"-o", subcmd.String, "", "output file (default is standard output)",
+		),
+		"import", r.importCards, "add the cards from another site's collection export to the sheet", subcmd.Params(
+			"-append", subcmd.Bool, false, "add every card as a new row, without merging into existing rows",
+			"-format", subcmd.String, "deckbox", `input format: "deckbox" or "tcgplayer"`,
+			"file", subcmd.String, "-", "CSV file to import (default is standard input)",
+		),
+		"install-service", r.installService, "run majic daily, with the flags given here, via systemd (Linux) or launchd (macOS)", subcmd.Params(
+			"-at", subcmd.String, "03:00", "time of day to run, as HH:MM",
+			"-n", subcmd.Bool, false, "write the files to standard output instead of installing them",
+		),
+		"printings", r.printings, "list the printings of a card, with prices", subcmd.Params(
+			"-foil", subcmd.Bool, false, "with -insert, mark the new rows as foil",
+			"-insert", subcmd.Bool, false, "add a row to the sheet for each printing",
+			"name", subcmd.String, "", "card name",
+		),
+		"report", r.appraisal, "write an HTML appraisal of the collection, e.g. for insurance", subcmd.Params(
+			"-o", subcmd.String, "", "output file (default is standard output)",
+			"-title", subcmd.String, "Magic: The Gathering collection appraisal", "title of the report",
+		),
+		"rollback", r.rollback, "restore the cells changed by the last run to their earlier values", subcmd.Params(
+			"-n", subcmd.Bool, false, "list the cells that would be restored without changing the sheet",
+		),
+		"serve", r.serve, "run an HTTP server for starting runs and looking up prices", subcmd.Params(
+			"-addr", subcmd.String, "localhost:8080", "address on which to listen",
+		),
+		"stats", r.stats, "summarize the collection by set, rarity, and color", subcmd.Params(
+			"-top", subcmd.Int, 20, "list this many of the most valuable cards",
+		),
+	)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// compare implements the "compare" subcommand.
+// For each card in the selected sheets
+// (see readCollection)
+// it prints the price from each source majic knows about:
+// TCGplayer (by way of Scryfall),
+// and Card Kingdom's retail and buylist prices
+// (see buylist.go).
+//
+// The spread is the difference between the highest and lowest retail price,
+// as a percentage of the lowest.
+// Cards are listed with the largest spreads first,
+// and ones whose spread is at least the given percentage are marked with a *.
+// Those are the cards worth buying in one market and selling in another.
+func (r *runner) compare(ctx context.Context, spread float64, _ []string) error {
+	rows, err := r.readCollection(ctx)
+	if err != nil {
+		return err
+	}
+	ck, err := r.buylist.prices(ctx)
+	if err != nil {
+		return err
+	}
+
+	type comparison struct {
+		row                        collectionRow
+		tcgplayer, ckRetail, ckBuy string
+		spread                     float64 // A fraction; -1 if there's nothing to compare.
+	}
+	var result []comparison
+	for _, row := range rows {
+		cheapest := row.SetCode == "" && r.cheapest
+		obj, err := r.scryfall.card(ctx, row.CardName, row.SetCode, row.CollectorNumber, row.Language, row.Foil, cheapest)
+		if errors.Is(err, majicerr.ErrCardNotFound) {
+			slog.Warn("Card not found", "sheet", row.Sheet, "row", row.Row, "card", row.CardName, "set", row.SetCode)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("looking up %s: %w", row.CardName, err)
+		}
+
+		c := comparison{row: row, spread: -1}
+
+		var retail []float64
+		if p, ok := parsePrice(obj.Prices.price(row.Foil)); ok {
+			c.tcgplayer = fmt.Sprintf("%.2f", p)
+			retail = append(retail, p)
+		}
+		if p, ok := ck.retail(obj.ID, row.Foil); ok {
+			c.ckRetail = fmt.Sprintf("%.2f", p)
+			retail = append(retail, p)
+		}
+		if p, ok := ck.price(obj.ID, row.Foil); ok {
+			c.ckBuy = fmt.Sprintf("%.2f", p)
+		}
+		if len(retail) > 1 {
+			lo, hi := min(retail[0], retail[1]), max(retail[0], retail[1])
+			if lo > 0 {
+				c.spread = (hi - lo) / lo
+			}
+		}
+
+		result = append(result, c)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].spread > result[j].spread
+	})
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tCard\tSet\tFoil\tTCGplayer\tCK retail\tCK buylist\tSpread")
+	for _, c := range result {
+		var mark, foil, spreadStr string
+		if c.row.Foil {
+			foil = "foil"
+		}
+		if c.spread >= 0 {
+			spreadStr = fmt.Sprintf("%.0f%%", 100*c.spread)
+			if 100*c.spread >= spread {
+				mark = "*"
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", mark, c.row.CardName, c.row.SetCode, foil, c.tcgplayer, c.ckRetail, c.ckBuy, spreadStr)
+	}
+	return tw.Flush()
+}
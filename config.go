@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// These are the names of the logical fields that majic knows about.
+// Each one is normally found in a column whose heading is the same as the field name
+// (compared case-insensitively),
+// but that can be changed with a config file or the -heading flag.
+const (
+	cardNameField    = "card name"
+	setCodeField     = "set code"
+	foilField        = "foil"
+	lastUpdatedField = "last updated"
+	priceField       = "price"
+
+	// These fields are optional.
+	// If there's no column for one,
+	// the feature it controls is simply not used.
+	buylistField         = "buylist" // What a dealer will pay for the card; see buylist.go.
+	conditionField       = "condition"
+	collectorNumberField = "collector number" // Also a metadata field; see metadata.go.
+	etchedPriceField     = "etched price"     // See otherPrices.
+	foilPriceField       = "foil price"       // See otherPrices.
+	forceField           = "force"
+	languageField        = "language"
+	previousPriceField   = "previous price"
+	productIDField       = "product id"   // Marks a row as sealed product; see sealed.go.
+	proxyField           = "proxy"        // Marks a row as a proxy, which isn't priced; see rowResult.proxy.
+	quantityField        = "quantity"     // How many copies of the card; 1 if missing.
+	reprintRiskField     = "reprint risk" // Upcoming printings of the card; see reprint.go.
+	setNameField         = "set name"     // An alternative to the set-code field.
+	sourceField          = "source"       // Where the price came from; see priceSource.
+	statusField          = "status"       // Where to say what happened to a row; see rowStatus.
+	watchField           = "watch"        // A target price; see watchTarget.
+
+	// These are for tracking a card's value against what was paid for it.
+	purchasePriceField   = "purchase price"
+	gainLossField        = "gain/loss"
+	gainLossPercentField = "gain/loss %"
+)
+
+// A config holds settings that can be read from a JSON file
+// (named with the -config flag).
+// A config file looks like this:
+//
+//	{
+//	  "headings": {
+//	    "card name": "Kartenname",
+//	    "price":     "Preis"
+//	  },
+//	  "conditions": {
+//	    "NM": 1,
+//	    "LP": 0.8
+//	  },
+//	  "price_columns": {
+//	    "EUR":  "eur",
+//	    "TIX":  "tix"
+//	  },
+//	  "computed_columns": {
+//	    "Trade value": "round(price * 0.8, 2)"
+//	  },
+//	  "template_columns": {
+//	    "Display": "{{.Name}} ({{.Set}}) {{if .Foil}}★{{end}}"
+//	  },
+//	  "smtp": {
+//	    "addr":     "smtp.example.com:587",
+//	    "from":     "majic@example.com",
+//	    "username": "majic",
+//	    "password": "swordfish"
+//	  },
+//	  "profiles": {
+//	    "cube": {
+//	      "sheetkey":  "1AbC…",
+//	      "sheetname": "Cube",
+//	      "headings":  {"price": "Value"}
+//	    }
+//	  }
+//	}
+type config struct {
+	// Headings maps a logical field name (like "card name")
+	// to the heading of the spreadsheet column holding that field.
+	// Fields that are not mentioned keep their default headings.
+	Headings map[string]string `json:"headings"`
+
+	// Conditions maps a card condition
+	// (as found in the optional Condition column)
+	// to a multiplier for the card's market price,
+	// which is for near-mint copies.
+	// These add to and override defaultConditions.
+	Conditions map[string]float64 `json:"conditions,omitempty"`
+
+	// PriceColumns maps the heading of a column
+	// to the price to write there,
+	// by its name in scryfall's "prices" object:
+	// "usd," "usd_foil," "usd_etched," "eur," "eur_foil," or "tix."
+	// That way one lookup can fill several price columns.
+	// Columns the sheet doesn't have are ignored.
+	// If one of them is the Price column,
+	// that's the price used for it
+	// (instead of the USD price in the row's finish).
+	PriceColumns map[string]string `json:"price_columns,omitempty"`
+
+	// ComputedColumns maps the heading of a column
+	// to an expression for its value,
+	// computed from the card's data for each row that's priced.
+	// Columns the sheet doesn't have are ignored.
+	// See computedColumn.
+	ComputedColumns map[string]string `json:"computed_columns,omitempty"`
+
+	// TemplateColumns maps the heading of a column
+	// to a Go template for its value,
+	// executed on the card's data for each row that's priced.
+	// Columns the sheet doesn't have are ignored.
+	// See computedColumn.
+	TemplateColumns map[string]string `json:"template_columns,omitempty"`
+
+	computed []computedColumn // Parsed from ComputedColumns and TemplateColumns by readConfig.
+
+	// SMTP holds the settings for sending email.
+	// It's needed only for email alerts.
+	SMTP *smtpConfig `json:"smtp,omitempty"`
+
+	// Profiles are named bundles of settings,
+	// one of which can be selected with -profile.
+	// See sheetProfile.
+	Profiles map[string]*sheetProfile `json:"profiles,omitempty"`
+}
+
+// A sheetProfile bundles the settings for one spreadsheet,
+// so that one installation of majic can look after several
+// (say, your collection, a friend's, and a cube)
+// with nothing more than -profile NAME on the command line.
+//
+// Each field stands in for the command-line flag in its JSON tag,
+// except that a flag given explicitly wins.
+// Empty fields leave the flag alone.
+type sheetProfile struct {
+	SheetKey  string `json:"sheetkey,omitempty"`
+	SheetName string `json:"sheetname,omitempty"`
+	Creds     string `json:"creds,omitempty"`
+	Token     string `json:"token,omitempty"`
+
+	// Headings are added to
+	// (and override)
+	// the ones at the top level of the config file.
+	Headings map[string]string `json:"headings,omitempty"`
+}
+
+// useProfile applies the named profile:
+// it sets the flags named by the profile's fields
+// (those not already given on the command line)
+// and merges the profile's headings into c.
+func (c *config) useProfile(name string) error {
+	p, ok := c.Profiles[name]
+	if !ok {
+		return fmt.Errorf("no profile named %q in config file", name)
+	}
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	for _, s := range []struct{ flag, val string }{
+		{"sheetkey", p.SheetKey},
+		{"sheetname", p.SheetName},
+		{"creds", p.Creds},
+		{"token", p.Token},
+	} {
+		if s.val == "" || given[s.flag] {
+			continue
+		}
+		if err := flag.Set(s.flag, s.val); err != nil {
+			return fmt.Errorf("setting -%s from profile %s: %w", s.flag, name, err)
+		}
+	}
+
+	for field, heading := range p.Headings {
+		if c.Headings == nil {
+			c.Headings = make(map[string]string)
+		}
+		c.Headings[strings.ToLower(strings.TrimSpace(field))] = heading
+	}
+	return nil
+}
+
+// readConfig parses the JSON config file at filename.
+func readConfig(filename string) (*config, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	var cfg config
+	if err := json.NewDecoder(f).Decode(&cfg); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+
+	// Fields are looked up in lowercase
+	// (see heading),
+	// as with -heading.
+	if len(cfg.Headings) > 0 {
+		headings := make(map[string]string, len(cfg.Headings))
+		for field, heading := range cfg.Headings {
+			headings[strings.ToLower(strings.TrimSpace(field))] = heading
+		}
+		cfg.Headings = headings
+	}
+
+	for heading, field := range cfg.PriceColumns {
+		if _, ok := (pricesObj{}).field(field); !ok {
+			return nil, fmt.Errorf("unknown price %q for column %q in %s", field, heading, filename)
+		}
+	}
+	cfg.computed, err = parseComputedColumns(cfg.ComputedColumns)
+	if err != nil {
+		return nil, fmt.Errorf("in %s: %w", filename, err)
+	}
+	templated, err := parseTemplateColumns(cfg.TemplateColumns)
+	if err != nil {
+		return nil, fmt.Errorf("in %s: %w", filename, err)
+	}
+	for _, c := range templated {
+		if _, ok := cfg.ComputedColumns[c.heading]; ok {
+			return nil, fmt.Errorf("column %q is in both computed_columns and template_columns in %s", c.heading, filename)
+		}
+	}
+	cfg.computed = append(cfg.computed, templated...)
+	return &cfg, nil
+}
+
+// heading tells the column heading to look for when finding the given logical field.
+func (c *config) heading(field string) string {
+	if h, ok := c.Headings[field]; ok {
+		return h
+	}
+	return field
+}
+
+// setHeading parses a "field=Heading" string
+// (the argument to a -heading flag)
+// and records the mapping in c.
+func (c *config) setHeading(s string) error {
+	field, heading, ok := strings.Cut(s, "=")
+	if !ok {
+		return fmt.Errorf(`malformed heading mapping %q, want "field=Heading"`, s)
+	}
+	if c.Headings == nil {
+		c.Headings = make(map[string]string)
+	}
+	c.Headings[strings.ToLower(strings.TrimSpace(field))] = strings.TrimSpace(heading)
+	return nil
+}
+
+// defaultConditions are the price multipliers for common card conditions.
+// Keys are lowercase.
+var defaultConditions = map[string]float64{
+	"nm":                1,
+	"near mint":         1,
+	"m":                 1,
+	"mint":              1,
+	"lp":                0.85,
+	"lightly played":    0.85,
+	"ex":                0.85,
+	"excellent":         0.85,
+	"mp":                0.7,
+	"moderately played": 0.7,
+	"played":            0.7,
+	"hp":                0.5,
+	"heavily played":    0.5,
+	"dmg":               0.3,
+	"damaged":           0.3,
+	"poor":              0.3,
+}
+
+// conditionMultiplier tells how to adjust a card's market price for the given condition.
+// A blank condition means near mint.
+// The boolean result is false for an unknown condition.
+func (c *config) conditionMultiplier(cond string) (float64, bool) {
+	cond = strings.ToLower(strings.TrimSpace(cond))
+	if cond == "" {
+		return 1, true
+	}
+	for k, v := range c.Conditions {
+		if strings.ToLower(k) == cond {
+			return v, true
+		}
+	}
+	m, ok := defaultConditions[cond]
+	return m, ok
+}
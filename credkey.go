@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+// A credKey decrypts credential files
+// (the -creds file, the -service-account key file, and a -token file)
+// that have been encrypted with age (https://age-encryption.org),
+// and encrypts the token file when majic saves a new token.
+//
+// Encrypted files are recognized by their age header,
+// so plain files keep working with or without a key.
+//
+// A nil *credKey is valid and has no key:
+// it reads plain files and refuses encrypted ones.
+type credKey struct {
+	identities []age.Identity
+	recipient  age.Recipient
+}
+
+// loadCredKey reads the key named by the -cred-key flag.
+// That's a filename,
+// or "env:VAR" for the environment variable VAR.
+// Either way the key is an age secret key ("AGE-SECRET-KEY-1…"),
+// as made by age-keygen,
+// or else a passphrase.
+//
+// An empty spec means no key,
+// and loadCredKey returns nil.
+func loadCredKey(spec string) (*credKey, error) {
+	if spec == "" {
+		return nil, nil
+	}
+
+	var val string
+	if name, ok := strings.CutPrefix(spec, "env:"); ok {
+		val = os.Getenv(name)
+		if val == "" {
+			return nil, fmt.Errorf("-cred-key variable %s is not set", name)
+		}
+	} else {
+		data, err := os.ReadFile(spec)
+		if err != nil {
+			return nil, fmt.Errorf("reading -cred-key file: %w", err)
+		}
+		val = string(data)
+	}
+
+	if strings.Contains(val, "AGE-SECRET-KEY-") {
+		ids, err := age.ParseIdentities(strings.NewReader(val))
+		if err != nil {
+			return nil, fmt.Errorf("parsing age key: %w", err)
+		}
+		x, ok := ids[0].(*age.X25519Identity)
+		if !ok {
+			return nil, fmt.Errorf("unsupported age key type %T", ids[0])
+		}
+		return &credKey{identities: ids, recipient: x.Recipient()}, nil
+	}
+
+	// Otherwise it's a passphrase.
+	// Only trailing newlines are trimmed,
+	// since other spaces may be part of it.
+	pass := strings.TrimRight(val, "\r\n")
+	if pass == "" {
+		return nil, fmt.Errorf("empty -cred-key passphrase")
+	}
+	id, err := age.NewScryptIdentity(pass)
+	if err != nil {
+		return nil, fmt.Errorf("using passphrase: %w", err)
+	}
+	r, err := age.NewScryptRecipient(pass)
+	if err != nil {
+		return nil, fmt.Errorf("using passphrase: %w", err)
+	}
+	return &credKey{identities: []age.Identity{id}, recipient: r}, nil
+}
+
+// These begin age's binary and "armored" (text) formats.
+const (
+	ageHeader      = "age-encryption.org/v1\n"
+	ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+)
+
+// readFile reads the named file,
+// decrypting it in memory if it's encrypted.
+// The name may also be "env:VAR"
+// for the contents of the environment variable VAR
+// (handy in containers; see envflags.go).
+// Callers add the name to any error.
+func (k *credKey) readFile(filename string) ([]byte, error) {
+	var data []byte
+	if name, ok := strings.CutPrefix(filename, "env:"); ok {
+		val := os.Getenv(name)
+		if val == "" {
+			return nil, fmt.Errorf("variable %s is not set", name)
+		}
+		data = []byte(val)
+	} else {
+		var err error
+		data, err = os.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var src io.Reader
+	switch {
+	case bytes.HasPrefix(data, []byte(ageHeader)):
+		src = bytes.NewReader(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte(ageArmorHeader)):
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	default:
+		return data, nil
+	}
+
+	if k == nil {
+		return nil, errors.New("file is encrypted; supply the key with -cred-key")
+	}
+	r, err := age.Decrypt(src, k.identities...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting: %w", err)
+	}
+	return data, nil
+}
+
+// encrypt encrypts data for storing in a file
+// (in age's binary format).
+// With no key,
+// it returns data unchanged.
+func (k *credKey) encrypt(data []byte) ([]byte, error) {
+	if k == nil {
+		return data, nil
+	}
+	buf := new(bytes.Buffer)
+	w, err := age.Encrypt(buf, k.recipient)
+	if err != nil {
+		return nil, fmt.Errorf("encrypting: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, fmt.Errorf("encrypting: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encrypting: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// daemon calls r.runOnce repeatedly,
+// waiting about interval between runs,
+// until ctx is canceled
+// (which happens when the process receives SIGINT or SIGTERM).
+//
+// A failed run does not stop the daemon.
+// The error is logged and the next run happens on schedule.
+func daemon(ctx context.Context, r *runner, interval time.Duration) error {
+	for {
+		r.runAndLog(ctx)
+		if ctx.Err() != nil {
+			slog.Info("Shutting down")
+			return nil
+		}
+
+		wait := jitter(interval)
+		slog.Info("Waiting for next run", "wait", wait.Round(time.Second))
+
+		if !r.wait(ctx, wait) {
+			slog.Info("Shutting down")
+			return nil
+		}
+	}
+}
+
+// wait waits for the given duration,
+// or until a run is requested from the dashboard
+// (see dashboard.go),
+// handling any requests to price particular rows in the meantime
+// (see webhook.go).
+// It returns false if ctx is canceled.
+func (r *runner) wait(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timer.C:
+			return true
+		case <-r.runNow:
+			return true
+		case rr := <-r.rowRequests:
+			r.runRequestedRows(ctx, rr)
+		}
+	}
+}
+
+// runAndLog calls r.runOnce and logs the result,
+// for the daemon and the server
+// (see serve.go),
+// which keep going after a failed run.
+func (r *runner) runAndLog(ctx context.Context) {
+	slog.Info("Starting run")
+	err := r.runOnce(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if err != nil {
+		runsCompleted.WithLabelValues("error").Inc()
+		slog.Error("Run failed", "err", err)
+		return
+	}
+	runsCompleted.WithLabelValues("ok").Inc()
+	updated, skipped, errored := r.progress.counts()
+	slog.Info("Run complete", "updated", updated, "skipped", skipped, "errors", errored)
+}
+
+// jitter returns a duration within 10% of d, chosen at random.
+// This keeps a daemon from hitting the APIs at exactly the same moment every day
+// (as would a lot of other clients scheduled at, say, midnight).
+func jitter(d time.Duration) time.Duration {
+	spread := int64(d / 10)
+	if spread <= 0 {
+		return d
+	}
+	return d - time.Duration(spread) + time.Duration(rand.Int63n(2*spread))
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// serveDashboard serves a small web page on the given address
+// (see the -dashboard-addr flag)
+// showing the state of a daemon:
+// the last run,
+// the value of the collection over time
+// (from the history; see history.go),
+// recent errors,
+// and a button that starts a run right away.
+// Like serveMetrics,
+// it runs in the background
+// and logs an error if the server fails.
+func serveDashboard(addr string, r *runner) {
+	mux := http.NewServeMux()
+	addDashboardHandlers(mux, r)
+	addWebhookHandler(mux, r)
+
+	go func() {
+		slog.Info("Serving dashboard", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Dashboard server failed", "err", err)
+		}
+	}()
+}
+
+// addDashboardHandlers adds the dashboard's pages to mux.
+// The "Run now" button sends on r.runNow,
+// which must not be nil.
+// It works only in a browser on the same machine as majic
+// (see mayStartRun);
+// from elsewhere,
+// start a run through /webhook.
+func addDashboardHandlers(mux *http.ServeMux, r *runner) {
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := dashboardTmpl.Execute(w, newDashboardData(r.history.runs())); err != nil {
+			slog.Error("Could not render dashboard", "err", err)
+		}
+	})
+	mux.HandleFunc("/run", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			http.Error(w, "use POST", http.StatusMethodNotAllowed)
+			return
+		}
+		if !r.mayStartRun(req) {
+			http.Error(w, "runs can be started only from this machine, or with the webhook secret", http.StatusForbidden)
+			return
+		}
+		select {
+		case r.runNow <- struct{}{}:
+			slog.Info("Run requested from dashboard")
+		default:
+			// A run has already been requested.
+		}
+		http.Redirect(w, req, "/", http.StatusSeeOther)
+	})
+}
+
+// dashboardData is what the dashboard template displays.
+type dashboardData struct {
+	Last   *historyRun
+	Recent []historyRun // The most recent runs, newest first.
+
+	// The collection value over time,
+	// as the points of an SVG polyline
+	// in a chartWidth×chartHeight box.
+	ChartPoints        string
+	MinValue, MaxValue float64
+}
+
+// These are the dimensions of the dashboard's value chart,
+// and the number of recent runs it lists.
+const (
+	chartWidth      = 600
+	chartHeight     = 150
+	dashboardRecent = 10
+)
+
+func newDashboardData(runs []historyRun) dashboardData {
+	var d dashboardData
+	if len(runs) == 0 {
+		return d
+	}
+	d.Last = &runs[len(runs)-1]
+	for i := len(runs) - 1; i >= 0 && len(d.Recent) < dashboardRecent; i-- {
+		d.Recent = append(d.Recent, runs[i])
+	}
+
+	// Failed runs may have stopped partway through,
+	// and partial runs cover only a few rows,
+	// so their values aren't comparable.
+	var ok []historyRun
+	for _, run := range runs {
+		if run.Err == "" && !run.Partial {
+			ok = append(ok, run)
+		}
+	}
+	if len(ok) < 2 {
+		return d
+	}
+
+	d.MinValue, d.MaxValue = ok[0].Value, ok[0].Value
+	for _, run := range ok {
+		d.MinValue = min(d.MinValue, run.Value)
+		d.MaxValue = max(d.MaxValue, run.Value)
+	}
+	var (
+		t0     = ok[0].End
+		tspan  = ok[len(ok)-1].End.Sub(t0)
+		vspan  = d.MaxValue - d.MinValue
+		points []string
+	)
+	for _, run := range ok {
+		var x, y float64
+		if tspan > 0 {
+			x = chartWidth * float64(run.End.Sub(t0)) / float64(tspan)
+		}
+		y = chartHeight / 2
+		if vspan > 0 {
+			y = chartHeight * (1 - (run.Value-d.MinValue)/vspan)
+		}
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x, y))
+	}
+	d.ChartPoints = strings.Join(points, " ")
+	return d
+}
+
+var dashboardTmpl = template.Must(template.New("").Funcs(template.FuncMap{
+	"when": func(t time.Time) string { return t.Local().Format("2006-01-02 15:04:05") },
+	"took": func(run historyRun) time.Duration { return run.End.Sub(run.Start).Round(time.Second) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>majic</title>
+<meta http-equiv="refresh" content="60">
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+td, th { padding: 0.2em 0.8em; text-align: left; }
+.error { color: #a00; }
+svg { border: 1px solid #ccc; }
+</style>
+</head>
+<body>
+<h1>majic</h1>
+
+<form method="POST" action="/run"><button type="submit">Run now</button></form>
+
+{{ with .Last }}
+<h2>Last run</h2>
+<p>
+Finished {{ when .End }} (took {{ took . }}):
+{{ .Updated }} updated, {{ .Skipped }} skipped, {{ .Errors }} errors.
+Collection value {{ printf "%.2f" .Value }}.
+</p>
+{{ with .Err }}<p class="error">Failed: {{ . }}</p>{{ end }}
+{{ with .RowErrors }}
+<table>
+<tr><th>Sheet</th><th>Row</th><th>Card</th><th>Error</th></tr>
+{{ range . }}<tr><td>{{ .Sheet }}</td><td>{{ .Row }}</td><td>{{ .Card }}</td><td class="error">{{ .Err }}</td></tr>
+{{ end }}
+</table>
+{{ end }}
+{{ else }}
+<p>No runs yet.</p>
+{{ end }}
+
+{{ with .ChartPoints }}
+<h2>Collection value</h2>
+<p>{{ printf "%.2f" $.MinValue }} – {{ printf "%.2f" $.MaxValue }}</p>
+<svg width="600" height="150" viewBox="0 0 600 150">
+<polyline fill="none" stroke="#06c" stroke-width="2" points="{{ . }}"/>
+</svg>
+{{ end }}
+
+{{ with .Recent }}
+<h2>Recent runs</h2>
+<table>
+<tr><th>Finished</th><th>Updated</th><th>Skipped</th><th>Errors</th><th>Value</th><th></th></tr>
+{{ range . }}<tr><td>{{ when .End }}</td><td>{{ .Updated }}</td><td>{{ .Skipped }}</td><td>{{ .Errors }}</td><td>{{ printf "%.2f" .Value }}</td><td class="error">{{ .Err }}</td></tr>
+{{ end }}
+</table>
+{{ end }}
+</body>
+</html>
+`))
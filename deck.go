@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/bobg/majic/majicerr"
+	"github.com/bobg/subcmd/v2"
+)
+
+// deck implements the "deck" subcommand,
+// which has subcommands of its own.
+func (r *runner) deck(ctx context.Context, args []string) error {
+	return subcmd.Run(ctx, deckCmd{r: r}, args)
+}
+
+type deckCmd struct {
+	r *runner
+}
+
+func (c deckCmd) Subcmds() subcmd.Map {
+	return subcmd.Commands(
+		"price", c.r.deckPrice, "price a deck or cube as a whole, from a decklist file or a sheet", subcmd.Params(
+			"-top", subcmd.Int, 10, "list this many of the most expensive cards",
+			"deck?", subcmd.String, "-", "decklist file, or the name of a sheet in the spreadsheet (default is standard input)",
+		),
+	)
+}
+
+// A deckCard is a line of a decklist:
+// some number of copies of a card,
+// in one of the deck's boards.
+type deckCard struct {
+	board    string // "main," "side," etc.; see deckBoards.
+	quantity int
+	name     string
+	setCode  string // Optional.
+	number   string // Optional collector number.
+	foil     bool
+	proxy    bool // From a sheet's Proxy column; see rowResult.proxy.
+}
+
+// deckBoards maps the headings that can start a section of a decklist
+// to the names of boards.
+var deckBoards = map[string]string{
+	"deck":        "main",
+	"main":        "main",
+	"mainboard":   "main",
+	"maindeck":    "main",
+	"sideboard":   "side",
+	"side":        "side",
+	"commander":   "commander",
+	"companion":   "companion",
+	"maybeboard":  "maybe",
+	"maybe":       "maybe",
+	"considering": "maybe",
+}
+
+// deckPrice implements the "deck price" subcommand.
+// It prices a deck
+// (or a cube, or any other list of cards)
+// as a unit:
+// the total cost,
+// the cost of each board,
+// and the most expensive cards.
+//
+// The deck is a decklist file
+// (see readDecklist)
+// or, if there's no file by that name,
+// a sheet in the spreadsheet,
+// with the usual Card name, Set code, Foil, and Quantity columns
+// and an optional Board column
+// (whose cells are like the section headings in a decklist;
+// a blank one means the main deck).
+// If the sheet has a Proxy column,
+// the proxies are left out of the cost
+// (and of the costs of the boards),
+// and the cost with them is reported separately.
+//
+// Prices are looked up afresh
+// (subject to -cheapest),
+// not read from the sheet,
+// but they come from the response cache when they can,
+// so pricing variations of a deck over and over is cheap.
+func (r *runner) deckPrice(ctx context.Context, top int, deck string, _ []string) error {
+	cards, err := r.readDeck(ctx, deck)
+	if err != nil {
+		return err
+	}
+	if len(cards) == 0 {
+		return fmt.Errorf("no cards in %s", deck)
+	}
+
+	type pricedCard struct {
+		deckCard
+		price float64
+	}
+
+	var (
+		priced   []pricedCard
+		total    statsGroup
+		proxies  statsGroup
+		boards   = make(map[string]*statsGroup)
+		notFound []string
+		catalog  = r.catalogs[magicGame]
+	)
+	for _, card := range cards {
+		obj, err := catalog.card(ctx, card.name, card.setCode, card.number, "", card.foil, card.setCode == "" && r.cheapest)
+		if err != nil {
+			if !errors.Is(err, majicerr.ErrCardNotFound) && !errors.Is(err, majicerr.ErrAmbiguous) {
+				return fmt.Errorf("looking up %q: %w", card.name, err)
+			}
+			notFound = append(notFound, card.name)
+		}
+
+		row := collectionRow{Quantity: card.quantity}
+		if err == nil {
+			if p, ok := parsePrice(obj.Prices.price(card.foil)); ok {
+				row.Price = &p
+				priced = append(priced, pricedCard{deckCard: card, price: p})
+			}
+		}
+		if card.proxy {
+			proxies.add(row)
+			continue
+		}
+		g, ok := boards[card.board]
+		if !ok {
+			g = &statsGroup{name: card.board}
+			boards[card.board] = g
+		}
+		g.add(row)
+		total.add(row)
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "Cards:\t%d\n", total.count)
+	fmt.Fprintf(tw, "Cost:\t%.2f\n", total.value)
+	if total.unpriced > 0 {
+		fmt.Fprintf(tw, "Without prices:\t%d\n", total.unpriced)
+	}
+	if len(notFound) > 0 {
+		fmt.Fprintf(tw, "Not found:\t%s\n", strings.Join(notFound, ", "))
+	}
+	if proxies.count > 0 {
+		fmt.Fprintf(tw, "Proxies:\t%d\n", proxies.count)
+		fmt.Fprintf(tw, "Cost with proxies:\t%.2f\n", total.value+proxies.value)
+	}
+
+	if len(boards) > 1 {
+		writeStatsGroups(tw, "Board", boards)
+	}
+
+	if top > 0 && len(priced) > 0 {
+		sort.SliceStable(priced, func(i, j int) bool {
+			return priced[i].price > priced[j].price
+		})
+		if len(priced) > top {
+			priced = priced[:top]
+		}
+		fmt.Fprintf(tw, "\nMost expensive\tSet\tFoil\tQuantity\tPrice\tTotal\n")
+		for _, c := range priced {
+			var foil string
+			if c.foil {
+				foil = "foil"
+			}
+			name := c.name
+			if c.proxy {
+				name += " (proxy)"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%.2f\t%.2f\n", name, c.setCode, foil, c.quantity, c.price, c.price*float64(c.quantity))
+		}
+	}
+
+	return tw.Flush()
+}
+
+// readDeck reads the cards of the deck named on the "deck price" command line:
+// a decklist file, standard input ("-"), or else a sheet.
+func (r *runner) readDeck(ctx context.Context, deck string) ([]deckCard, error) {
+	if deck == "-" {
+		return readDecklist(os.Stdin)
+	}
+	f, err := os.Open(deck)
+	if err == nil {
+		defer f.Close()
+		return readDecklist(f)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("opening %s: %w", deck, err)
+	}
+
+	rows, err := r.readSheetCollection(ctx, deck)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a file, and reading it as a sheet: %w", deck, err)
+	}
+	var cards []deckCard
+	for _, row := range rows {
+		board := "main"
+		if b := strings.TrimSpace(row.column(r.cfg.heading("board"))); b != "" {
+			if name, ok := deckBoards[strings.ToLower(b)]; ok {
+				board = name
+			} else {
+				board = b
+			}
+		}
+		cards = append(cards, deckCard{
+			board:    board,
+			quantity: row.Quantity,
+			name:     row.CardName,
+			setCode:  row.SetCode,
+			number:   row.CollectorNumber,
+			foil:     row.Foil,
+			proxy:    row.Proxy,
+		})
+	}
+	return cards, nil
+}
+
+// readDecklist parses a decklist
+// in the plain-text format that MTG Arena, MTGO, Moxfield, Archidekt, and others
+// import and export,
+// with one card per line:
+//
+//	4 Lightning Bolt
+//	1x Sol Ring (CMR) 472 *F*
+//
+// Each line is a number of copies
+// (1 if it's missing),
+// the card name,
+// and optionally the set code in parentheses,
+// the collector number,
+// and *F* (or *E*, for etched) for a foil.
+//
+// Lines like "Sideboard" or "Commander:" start a new board.
+// Without any such headings,
+// a blank line after the main deck starts the sideboard,
+// as in MTGO's format.
+// A line starting with "SB:" is a sideboard card.
+// Other lines starting with "//" or "#" are comments.
+func readDecklist(rd io.Reader) ([]deckCard, error) {
+	var (
+		cards      []deckCard
+		board      = "main"
+		sawHeading bool
+		sc         = bufio.NewScanner(rd)
+		lineno     int
+	)
+	for sc.Scan() {
+		lineno++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			if !sawHeading && board == "main" && len(cards) > 0 {
+				board = "side"
+			}
+			continue
+		}
+
+		heading := strings.ToLower(strings.TrimSpace(strings.TrimSuffix(strings.TrimLeft(line, "/# "), ":")))
+		if name, ok := deckBoards[heading]; ok {
+			board = name
+			sawHeading = true
+			continue
+		}
+		if strings.HasPrefix(line, "//") || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		cardBoard := board
+		if rest, ok := strings.CutPrefix(line, "SB:"); ok {
+			cardBoard = "side"
+			line = strings.TrimSpace(rest)
+		}
+
+		m := decklistLineRegex.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("line %d: can't parse %q", lineno, line)
+		}
+		card := deckCard{
+			board:    cardBoard,
+			quantity: 1,
+			name:     strings.TrimSpace(m[2]),
+			setCode:  strings.ToLower(m[3]),
+			number:   m[4],
+			foil:     m[5] != "",
+		}
+		if m[1] != "" {
+			q, err := strconv.Atoi(m[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: bad quantity %q: %w", lineno, m[1], err)
+			}
+			card.quantity = q
+		}
+		cards = append(cards, card)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading decklist: %w", err)
+	}
+	return cards, nil
+}
+
+// decklistLineRegex matches a card line in a decklist.
+// The submatches are the quantity, name, set code, collector number, and foil marker,
+// all but the name optional.
+var decklistLineRegex = regexp.MustCompile(`^(?:(\d+)x?\s+)?(.+?)(?:\s+\((\w+)\)(?:\s+([^\s*]\S*))?)?(?:\s+(\*[FE]\*))?$`)
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strconv"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// dedupe implements the "dedupe" subcommand.
+// It finds rows for the same card
+// (the same name, set, finish, and condition),
+// adds the quantities of the later ones to the first one,
+// and deletes the later ones.
+// This cleans up sheets built from more than one import.
+// With dryRun,
+// it only reports what it would do.
+func (r *runner) dedupe(ctx context.Context, dryRun bool, _ []string) error {
+	t, err := r.openTable(ctx)
+	if err != nil {
+		return err
+	}
+	quantityCol := t.col(quantityField)
+	if quantityCol < 0 {
+		// Without a place to record the merged quantities,
+		// deleting rows would lose cards.
+		return fmt.Errorf("no %q column", r.cfg.heading(quantityField))
+	}
+
+	var (
+		groups = make(map[string][]int) // Maps a card's key to its row numbers.
+		keys   []string                 // The keys of groups, in order of appearance.
+	)
+	for rownum := t.headerRow + 1; rownum < t.endRow; rownum++ {
+		name := t.cell(rownum, cardNameField)
+		if name == "" {
+			continue
+		}
+		key := cacheKey(name, t.cell(rownum, setCodeField), t.cell(rownum, setNameField), strconv.FormatBool(isTrue(t.cell(rownum, foilField))), conditionCode(t.cell(rownum, conditionField)))
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], rownum)
+	}
+
+	// Each group of duplicates is merged into its first row.
+	type merge struct {
+		rownums []int
+		total   int
+	}
+	var merges []merge
+	for _, key := range keys {
+		rownums := groups[key]
+		if len(rownums) < 2 {
+			continue
+		}
+		m := merge{rownums: rownums}
+		for _, rownum := range rownums {
+			m.total += atoiOr(t.cell(rownum, quantityField), 1)
+		}
+		merges = append(merges, m)
+		slog.Info("Merging rows", "card", t.cell(rownums[0], cardNameField), "set", t.cell(rownums[0], setCodeField), "rows", len(rownums), "quantity", m.total)
+	}
+	if len(merges) == 0 {
+		slog.Info("No duplicate rows")
+		return nil
+	}
+	if dryRun {
+		return nil
+	}
+
+	// Leave alone any group with a row that has changed since it was read.
+	// Otherwise a merged quantity could be dropped by writeUpdates
+	// while its duplicates are deleted anyway.
+	var check []cellUpdate
+	for _, m := range merges {
+		for _, rownum := range m.rownums {
+			check = append(check, cellUpdate{row: rownum, col: quantityCol})
+		}
+	}
+	check, err = r.dropConflicts(ctx, t.sheetName, t.orig, check)
+	if err != nil {
+		return err
+	}
+	unchanged := make(map[int]bool)
+	for _, u := range check {
+		unchanged[u.row] = true
+	}
+
+	var (
+		updates  []cellUpdate
+		toDelete []int
+	)
+	for _, m := range merges {
+		ok := true
+		for _, rownum := range m.rownums {
+			ok = ok && unchanged[rownum]
+		}
+		if !ok {
+			continue
+		}
+		updates = append(updates, cellUpdate{row: m.rownums[0], col: quantityCol, val: m.total})
+		toDelete = append(toDelete, m.rownums[1:]...)
+	}
+
+	if err := r.writeUpdates(ctx, t.sheetName, t.orig, updates); err != nil {
+		return err
+	}
+	if err := r.deleteRows(ctx, t.sheetName, toDelete); err != nil {
+		return err
+	}
+
+	slog.Info("Dedupe done", "merged", len(updates), "deleted", len(toDelete))
+	return nil
+}
+
+// deleteRows deletes the given rows
+// (zero-based, in any order)
+// from the named sheet.
+func (r *runner) deleteRows(ctx context.Context, sheetName string, rownums []int) error {
+	if len(rownums) == 0 {
+		return nil
+	}
+	id, err := r.sheetID(ctx, sheetName)
+	if err != nil {
+		return err
+	}
+
+	// Delete from the bottom up,
+	// so each deletion leaves the numbers of the remaining rows unchanged.
+	sort.Sort(sort.Reverse(sort.IntSlice(rownums)))
+
+	var reqs []*sheets.Request
+	for _, rownum := range rownums {
+		reqs = append(reqs, &sheets.Request{
+			DeleteDimension: &sheets.DeleteDimensionRequest{
+				Range: &sheets.DimensionRange{
+					SheetId:         id,
+					Dimension:       "ROWS",
+					StartIndex:      int64(rownum),
+					EndIndex:        int64(rownum + 1),
+					ForceSendFields: []string{"SheetId", "StartIndex"}, // See columnRange.
+				},
+			},
+		})
+	}
+	if err := r.batchUpdate(ctx, reqs...); err != nil {
+		return fmt.Errorf("deleting rows: %w", err)
+	}
+	sheetWrites.Add(float64(len(rownums)))
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// envPrefix begins the names of environment variables that set flags;
+// see setFlagsFromEnv.
+const envPrefix = "MAJIC_"
+
+// setFlagsFromEnv sets each flag not given on the command line
+// from the environment variable with the corresponding name,
+// if there is one:
+// MAJIC_ followed by the flag name in upper case,
+// with dashes changed to underscores.
+// So MAJIC_SHEETKEY sets -sheetkey
+// and MAJIC_MAX_AGE sets -max-age.
+//
+// That lets majic run in a container,
+// or anywhere else configured with environment variables,
+// without a long command line.
+// Together with "env:VAR" in -creds, -service-account, and -token,
+// it needs no files at all.
+//
+// A repeatable flag like -heading can be given only once this way.
+//
+// This must be called after flag.Parse
+// and before anything (like -profile) that checks which flags were given,
+// since flags set here count as given.
+func setFlagsFromEnv() error {
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if err != nil || given[f.Name] {
+			return
+		}
+		name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		val, ok := os.LookupEnv(name)
+		if !ok {
+			return
+		}
+		if e := flag.Set(f.Name, val); e != nil {
+			err = fmt.Errorf("setting -%s from $%s: %w", f.Name, name, e)
+		}
+	})
+	return err
+}
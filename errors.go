@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bobg/majic/majicerr"
+	"google.golang.org/api/googleapi"
+)
+
+// The kinds of trouble there can be looking up a card
+// are defined in the majicerr package,
+// so programs using majic can recognize them too.
+// Errors from processRow wrap them
+// (usually inside a rowError),
+// and callers use errors.Is and errors.As to decide what to do:
+// skip just the one row,
+// stop the run
+// (see abortsRun),
+// or in serve mode,
+// choose an HTTP status for the response
+// (see cardHandler).
+
+// abortsRun tells whether err,
+// from processing a row,
+// should stop the run instead of skipping just that row.
+// That's when all the rows after it would fail the same way,
+// as when an API is rate-limiting us or rejecting our credentials.
+func abortsRun(err error) bool {
+	var swerr majicerr.SheetWriteError
+	return errors.Is(err, majicerr.ErrRateLimited) || errors.Is(err, majicerr.ErrAuth) || errors.As(err, &swerr)
+}
+
+// apiStatusError is the error for an unsuccessful HTTP status from the named API,
+// with optional details from the response.
+// It wraps majicerr.ErrRateLimited or majicerr.ErrAuth if the status calls for it.
+func apiStatusError(api string, status int, details string) error {
+	msg := fmt.Sprintf("%s API status %d", api, status)
+	if details != "" {
+		msg += ": " + details
+	}
+	switch status {
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%s: %w", msg, majicerr.ErrRateLimited)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%s: %w", msg, majicerr.ErrAuth)
+	}
+	return errors.New(msg)
+}
+
+// googleAPIError is like apiStatusError
+// for the errors returned by the Google Sheets API client,
+// which carry the HTTP status in a googleapi.Error.
+// The result wraps both err
+// (so callers can still get at the googleapi.Error with errors.As)
+// and the majicerr sentinel.
+func googleAPIError(err error) error {
+	var gerr *googleapi.Error
+	if !errors.As(err, &gerr) {
+		return err
+	}
+	switch gerr.Code {
+	case http.StatusTooManyRequests:
+		return fmt.Errorf("%w: %w", err, majicerr.ErrRateLimited)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return fmt.Errorf("%w: %w", err, majicerr.ErrAuth)
+	}
+	return err
+}
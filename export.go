@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// export implements the "export" subcommand.
+// It writes the contents of the selected sheets
+// (see readCollection)
+// in the given format
+// to the named file,
+// or to the standard output if the name is "" or "-".
+func (r *runner) export(ctx context.Context, format, outfile string, _ []string) error {
+	rows, err := r.readCollection(ctx)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if outfile != "" && outfile != "-" {
+		f, err := os.Create(outfile)
+		if err != nil {
+			return fmt.Errorf("creating %s: %w", outfile, err)
+		}
+		defer f.Close()
+		w = f
+	}
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rows); err != nil {
+			return fmt.Errorf("writing JSON: %w", err)
+		}
+
+	default:
+		profile, ok := csvProfiles[format]
+		if !ok {
+			return fmt.Errorf("unknown export format %q", format)
+		}
+		var sets *setCatalog
+		if profile.needSets {
+			sets, err = r.scryfall.sets(ctx)
+			if err != nil {
+				return err
+			}
+		}
+		cw := csv.NewWriter(w)
+		if err := cw.Write(profile.header); err != nil {
+			return fmt.Errorf("writing CSV: %w", err)
+		}
+		for _, row := range rows {
+			if err := cw.Write(profile.row(row, sets)); err != nil {
+				return fmt.Errorf("writing CSV: %w", err)
+			}
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			return fmt.Errorf("writing CSV: %w", err)
+		}
+	}
+
+	if f, ok := w.(*os.File); ok && f != os.Stdout {
+		return f.Close()
+	}
+	return nil
+}
+
+// A csvProfile describes the CSV format that a collection-tracking website
+// uses for importing collections.
+type csvProfile struct {
+	header   []string
+	row      func(collectionRow, *setCatalog) []string
+	needSets bool // Whether row needs a setCatalog (for set names).
+}
+
+// csvProfiles are the CSV formats that the export subcommand can write,
+// keyed by the value of its -format flag.
+var csvProfiles = map[string]csvProfile{
+	// See https://moxfield.com/help/importing-collection.
+	"moxfield": {
+		header: []string{"Count", "Name", "Edition", "Condition", "Language", "Foil", "Collector Number", "Purchase Price"},
+		row: func(cr collectionRow, _ *setCatalog) []string {
+			return []string{
+				strconv.Itoa(cr.Quantity),
+				cr.CardName,
+				strings.ToLower(cr.SetCode),
+				conditionName(cr.Condition),
+				languageName(cr.Language),
+				ifFoil(cr.Foil, "foil", ""),
+				cr.CollectorNumber,
+				priceString(cr.PurchasePrice),
+			}
+		},
+	},
+
+	// See https://archidekt.com/collection/import.
+	"archidekt": {
+		header: []string{"Quantity", "Name", "Finish", "Condition", "Language", "Edition Code", "Collector Number", "Purchase Price"},
+		row: func(cr collectionRow, _ *setCatalog) []string {
+			lang, _ := languageCode(cr.Language)
+			return []string{
+				strconv.Itoa(cr.Quantity),
+				cr.CardName,
+				ifFoil(cr.Foil, "Foil", "Normal"),
+				conditionCode(cr.Condition),
+				strings.ToUpper(lang),
+				strings.ToLower(cr.SetCode),
+				cr.CollectorNumber,
+				priceString(cr.PurchasePrice),
+			}
+		},
+	},
+
+	// See https://deckbox.org/help/import_export.
+	// Deckbox wants set names rather than codes.
+	"deckbox": {
+		header: []string{"Count", "Name", "Edition", "Card Number", "Condition", "Language", "Foil", "My Price"},
+		row: func(cr collectionRow, sets *setCatalog) []string {
+			var edition string
+			if cr.SetCode != "" {
+				edition = sets.setName(cr.SetCode)
+			}
+			return []string{
+				strconv.Itoa(cr.Quantity),
+				cr.CardName,
+				edition,
+				cr.CollectorNumber,
+				conditionName(cr.Condition),
+				languageName(cr.Language),
+				ifFoil(cr.Foil, "foil", ""),
+				priceString(cr.PurchasePrice),
+			}
+		},
+		needSets: true,
+	},
+}
+
+// conditionCodes maps the lowercase names of card conditions
+// (as in defaultConditions)
+// to standard abbreviations.
+var conditionCodes = map[string]string{
+	"nm":                "NM",
+	"near mint":         "NM",
+	"m":                 "M",
+	"mint":              "M",
+	"lp":                "LP",
+	"lightly played":    "LP",
+	"ex":                "LP",
+	"excellent":         "LP",
+	"mp":                "MP",
+	"moderately played": "MP",
+	"played":            "MP",
+	"hp":                "HP",
+	"heavily played":    "HP",
+	"dmg":               "DMG",
+	"damaged":           "DMG",
+	"poor":              "DMG",
+}
+
+// conditionCode returns the standard abbreviation for a card condition.
+// A blank condition means near mint.
+// Unknown conditions are returned unchanged.
+func conditionCode(cond string) string {
+	cond = strings.TrimSpace(cond)
+	if cond == "" {
+		return "NM"
+	}
+	if code, ok := conditionCodes[strings.ToLower(cond)]; ok {
+		return code
+	}
+	return cond
+}
+
+// conditionName returns the full name of a card condition.
+func conditionName(cond string) string {
+	switch code := conditionCode(cond); code {
+	case "M":
+		return "Mint"
+	case "NM":
+		return "Near Mint"
+	case "LP":
+		return "Lightly Played"
+	case "MP":
+		return "Moderately Played"
+	case "HP":
+		return "Heavily Played"
+	case "DMG":
+		return "Damaged"
+	default:
+		return code
+	}
+}
+
+// languageName returns the English name of a language
+// (given as in the Language column; see languageCode).
+// Unknown languages are returned unchanged.
+func languageName(lang string) string {
+	code, ok := languageCode(lang)
+	if !ok {
+		return lang
+	}
+	name := languages[code][0]
+	return strings.ToUpper(name[:1]) + name[1:]
+}
+
+func ifFoil(foil bool, yes, no string) string {
+	if foil {
+		return yes
+	}
+	return no
+}
+
+// priceString formats a price for CSV output.
+// A nil price is empty.
+func priceString(p *float64) string {
+	if p == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*p, 'f', 2, 64)
+}
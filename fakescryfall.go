@@ -0,0 +1,178 @@
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// fakeScryfallFixtures are canned scryfall card objects
+// served by newFakeScryfall.
+// They include a card with two printings (Lightning Bolt),
+// a multi-face card (Fire // Ice),
+// a card with only an etched foil price (Counterspell from The List),
+// and a token with no prices (Goblin, an "extra").
+//
+//go:embed fixtures/scryfall.json
+var fakeScryfallFixtures []byte
+
+// newFakeScryfall starts a local HTTP server that imitates the parts of the scryfall API majic uses
+// (/cards/named, /cards/search, /cards/{set}/{number}, and /sets),
+// answering from the given cards
+// (or from fakeScryfallFixtures if cards is nil).
+// It's for trying majic,
+// and exercising processRow and friends
+// (see row_test.go),
+// without calling the real API.
+// Point a scryfallClient's baseURL at the server's URL.
+// Close the server when done with it.
+//
+// Unknown cards get a 404 error object,
+// as from the real scryfall.
+// Searches understand only the parts of the query syntax that majic sends:
+// an exact name (!"…"),
+// set:CODE terms,
+// and lang:CODE
+// (where only English is known).
+// A search with no exact name finds all the cards in the sets given by set:CODE terms.
+func newFakeScryfall(cards []respObj) (*httptest.Server, error) {
+	if cards == nil {
+		if err := json.Unmarshal(fakeScryfallFixtures, &cards); err != nil {
+			return nil, fmt.Errorf("decoding fixtures: %w", err)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/cards/named", func(w http.ResponseWriter, req *http.Request) {
+		var (
+			q       = req.URL.Query()
+			name    = q.Get("exact")
+			setCode = q.Get("set")
+		)
+		matches := fakeScryfallMatches(cards, name, []string{setCode}, true)
+		if len(matches) == 0 {
+			fakeScryfallNotFound(w, fmt.Sprintf("No cards found matching “%s”", name))
+			return
+		}
+		writeJSON(w, http.StatusOK, matches[0])
+	})
+	mux.HandleFunc("/cards/search", func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query().Get("q")
+		m := fakeScryfallNameRegex.FindStringSubmatch(q)
+		if m == nil {
+			// A query row (see expandQueries).
+			// Only a search by set is understood.
+			var matches []respObj
+			for _, sm := range fakeScryfallSetRegex.FindAllStringSubmatch(q, -1) {
+				for _, c := range cards {
+					if strings.EqualFold(c.Set, sm[1]) {
+						matches = append(matches, c)
+					}
+				}
+			}
+			if len(matches) == 0 {
+				fakeScryfallNotFound(w, "Your query didn’t match any cards.")
+				return
+			}
+			writeJSON(w, http.StatusOK, listObj{Data: matches, TotalCards: len(matches)})
+			return
+		}
+		name := strings.ReplaceAll(m[1], `\"`, `"`)
+		if lm := fakeScryfallLangRegex.FindStringSubmatch(q); lm != nil && lm[1] != "en" {
+			fakeScryfallNotFound(w, "Your query didn’t match any cards.")
+			return
+		}
+		var setCodes []string
+		for _, sm := range fakeScryfallSetRegex.FindAllStringSubmatch(q, -1) {
+			setCodes = append(setCodes, sm[1])
+		}
+		if len(setCodes) == 0 {
+			setCodes = []string{""}
+		}
+
+		// Only /cards/named finds a multi-face card by its front face.
+		matches := fakeScryfallMatches(cards, name, setCodes, false)
+		if len(matches) == 0 {
+			fakeScryfallNotFound(w, "Your query didn’t match any cards.")
+			return
+		}
+		if req.URL.Query().Get("order") == "usd" {
+			sort.SliceStable(matches, func(i, j int) bool {
+				pi, _ := parsePrice(matches[i].Prices.USD)
+				pj, _ := parsePrice(matches[j].Prices.USD)
+				return pi < pj
+			})
+		}
+		writeJSON(w, http.StatusOK, listObj{Data: matches})
+	})
+	mux.HandleFunc("/cards/", func(w http.ResponseWriter, req *http.Request) {
+		// /cards/{set}/{number}.
+		setCode, number, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/cards/"), "/")
+		if ok {
+			for _, c := range cards {
+				if strings.EqualFold(c.Set, setCode) && c.CollectorNumber == number {
+					writeJSON(w, http.StatusOK, c)
+					return
+				}
+			}
+		}
+		fakeScryfallNotFound(w, "No card found with the given ID or set code and collector number.")
+	})
+	mux.HandleFunc("/sets", func(w http.ResponseWriter, req *http.Request) {
+		var (
+			list struct {
+				Data []setObj `json:"data"`
+			}
+			seen = make(map[string]bool)
+		)
+		for _, c := range cards {
+			if !seen[c.Set] {
+				seen[c.Set] = true
+				list.Data = append(list.Data, setObj{Code: c.Set, Name: c.SetName})
+			}
+		}
+		writeJSON(w, http.StatusOK, list)
+	})
+
+	return httptest.NewServer(mux), nil
+}
+
+var (
+	fakeScryfallNameRegex = regexp.MustCompile(`!"((?:[^"\\]|\\.)*)"`)
+	fakeScryfallSetRegex  = regexp.MustCompile(`set:(\w+)`)
+	fakeScryfallLangRegex = regexp.MustCompile(`lang:(\w+)`)
+)
+
+// fakeScryfallMatches returns the cards with the given name
+// in any of the given sets
+// (an empty set code matching any set).
+// With frontFace,
+// the name may also be that of a card's first face.
+func fakeScryfallMatches(cards []respObj, name string, setCodes []string, frontFace bool) []respObj {
+	var result []respObj
+	for _, c := range cards {
+		ok := strings.EqualFold(c.Name, name)
+		if !ok && frontFace && len(c.CardFaces) > 0 {
+			ok = strings.EqualFold(c.CardFaces[0].Name, name)
+		}
+		if !ok {
+			continue
+		}
+		for _, setCode := range setCodes {
+			if setCode == "" || strings.EqualFold(c.Set, setCode) {
+				result = append(result, c)
+				break
+			}
+		}
+	}
+	return result
+}
+
+func fakeScryfallNotFound(w http.ResponseWriter, details string) {
+	writeJSON(w, http.StatusNotFound, errorObj{Status: http.StatusNotFound, Code: "not_found", Details: details})
+}
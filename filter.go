@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// A rowFilter selects the rows to process in a run
+// (see the -filter flag).
+//
+// It's an expression like
+//
+//	set == "NEO" && price > 5
+//
+// written with Go syntax,
+// which conveniently includes everything needed for this:
+// comparisons (==, !=, <, <=, >, >=),
+// logical operators (&&, ||, !),
+// arithmetic (+, -, *, /),
+// parentheses,
+// numbers,
+// quoted strings,
+// and calls to the functions in exprFuncs.
+// Identifiers name columns:
+// they're field names
+// (with underscores in place of spaces, as in card_name or last_updated)
+// or column headings.
+// The identifiers "set" and "name" are shorthand for set_code and card_name.
+//
+// Cell values are compared as numbers if both sides look like numbers
+// (including prices like "$1.50"),
+// and otherwise as strings,
+// ignoring upper- and lowercase differences.
+// A column name by itself is true if its cell is
+// (as with checkboxes; see isTrue).
+type rowFilter struct {
+	expr ast.Expr
+	cols map[string]int // Maps each identifier in expr to its column number. Set by bind.
+}
+
+// filterAliases are shorthand identifiers for some field names.
+var filterAliases = map[string]string{
+	"set":  setCodeField,
+	"name": cardNameField,
+}
+
+// parseFilter parses a filter expression.
+func parseFilter(s string) (*rowFilter, error) {
+	expr, err := parser.ParseExpr(s)
+	if err != nil {
+		return nil, fmt.Errorf("parsing filter %q: %w", s, err)
+	}
+	return &rowFilter{expr: expr}, nil
+}
+
+// bind returns a copy of f that is ready to use on the rows of a particular sheet.
+// The colFor function maps a field name to its column number in the sheet.
+// It's an error for an identifier in f not to name a column.
+func (f *rowFilter) bind(colFor func(field string) (int, bool)) (*rowFilter, error) {
+	result := &rowFilter{expr: f.expr, cols: make(map[string]int)}
+	for _, name := range exprIdents(f.expr) {
+		col, ok := colFor(identField(name))
+		if !ok {
+			return nil, fmt.Errorf("no column for %q in filter", name)
+		}
+		result.cols[name] = col
+	}
+	return result, nil
+}
+
+// exprIdents returns the identifiers in an expression
+// that stand for values:
+// not true and false,
+// and not the names of functions being called.
+func exprIdents(expr ast.Expr) []string {
+	var result []string
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.CallExpr:
+			for _, arg := range n.Args {
+				result = append(result, exprIdents(arg)...)
+			}
+			return false
+		case *ast.Ident:
+			if n.Name != "true" && n.Name != "false" {
+				result = append(result, n.Name)
+			}
+		}
+		return true
+	})
+	return result
+}
+
+// identField is the field name for an identifier in an expression.
+func identField(name string) string {
+	field := strings.ToLower(name)
+	if alias, ok := filterAliases[field]; ok {
+		field = alias
+	}
+	return strings.ReplaceAll(field, "_", " ")
+}
+
+// match tells whether the given row satisfies the filter.
+func (f *rowFilter) match(row []any) (bool, error) {
+	v, err := evalExpr(f.expr, func(name string) any {
+		if col := f.cols[name]; col < len(row) {
+			return fmt.Sprint(row[col])
+		}
+		return ""
+	})
+	if err != nil {
+		return false, err
+	}
+	return truthy(v), nil
+}
+
+// evalExpr evaluates an expression
+// (for a rowFilter, or a computed column; see transform.go).
+// The ident function supplies the values of identifiers.
+// The result is a bool, a float64, or a string.
+func evalExpr(expr ast.Expr, ident func(name string) any) (any, error) {
+	switch e := expr.(type) {
+	case *ast.ParenExpr:
+		return evalExpr(e.X, ident)
+
+	case *ast.BasicLit:
+		switch e.Kind {
+		case token.INT, token.FLOAT:
+			return strconv.ParseFloat(e.Value, 64)
+		case token.STRING, token.CHAR:
+			return strconv.Unquote(e.Value)
+		}
+
+	case *ast.Ident:
+		switch e.Name {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return ident(e.Name), nil
+
+	case *ast.CallExpr:
+		id, ok := e.Fun.(*ast.Ident)
+		if !ok {
+			break
+		}
+		fn, ok := exprFuncs[id.Name]
+		if !ok {
+			return nil, fmt.Errorf("unknown function %s", id.Name)
+		}
+		var args []any
+		for _, arg := range e.Args {
+			v, err := evalExpr(arg, ident)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, v)
+		}
+		v, err := fn(args...)
+		if err != nil {
+			return nil, fmt.Errorf("in %s: %w", id.Name, err)
+		}
+		return v, nil
+
+	case *ast.UnaryExpr:
+		x, err := evalExpr(e.X, ident)
+		if err != nil {
+			return nil, err
+		}
+		switch e.Op {
+		case token.NOT:
+			return !truthy(x), nil
+		case token.SUB:
+			n, ok := number(x)
+			if !ok {
+				return nil, fmt.Errorf("cannot negate %q", x)
+			}
+			return -n, nil
+		}
+
+	case *ast.BinaryExpr:
+		x, err := evalExpr(e.X, ident)
+		if err != nil {
+			return nil, err
+		}
+
+		// && and || only evaluate their right-hand sides if needed.
+		switch e.Op {
+		case token.LAND:
+			if !truthy(x) {
+				return false, nil
+			}
+			y, err := evalExpr(e.Y, ident)
+			return truthy(y), err
+		case token.LOR:
+			if truthy(x) {
+				return true, nil
+			}
+			y, err := evalExpr(e.Y, ident)
+			return truthy(y), err
+		}
+
+		y, err := evalExpr(e.Y, ident)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.Op {
+		case token.ADD, token.SUB, token.MUL, token.QUO:
+			a, ok1 := number(x)
+			b, ok2 := number(y)
+			if !ok1 || !ok2 {
+				if e.Op == token.ADD {
+					// Join strings.
+					return fmt.Sprint(x) + fmt.Sprint(y), nil
+				}
+				return nil, fmt.Errorf("cannot do arithmetic on %q and %q", fmt.Sprint(x), fmt.Sprint(y))
+			}
+			switch e.Op {
+			case token.ADD:
+				return a + b, nil
+			case token.SUB:
+				return a - b, nil
+			case token.MUL:
+				return a * b, nil
+			default:
+				if b == 0 {
+					return nil, fmt.Errorf("division by zero")
+				}
+				return a / b, nil
+			}
+		}
+
+		c := compare(x, y)
+		switch e.Op {
+		case token.EQL:
+			return c == 0, nil
+		case token.NEQ:
+			return c != 0, nil
+		case token.LSS:
+			return c < 0, nil
+		case token.LEQ:
+			return c <= 0, nil
+		case token.GTR:
+			return c > 0, nil
+		case token.GEQ:
+			return c >= 0, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unsupported expression at position %d", expr.Pos())
+}
+
+// compare compares two values,
+// numerically if both are numbers
+// and otherwise as strings, without regard to case.
+func compare(x, y any) int {
+	if a, ok := number(x); ok {
+		if b, ok := number(y); ok {
+			switch {
+			case a < b:
+				return -1
+			case a > b:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(strings.ToLower(fmt.Sprint(x)), strings.ToLower(fmt.Sprint(y)))
+}
+
+// number converts a value to a number if it can.
+func number(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		return parsePrice(v)
+	}
+	return 0, false
+}
+
+// truthy tells whether a value counts as true.
+func truthy(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	}
+	return isTrue(v)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bobg/majic/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// sheetID returns the numeric ID of the named sheet
+// (the "gid" in its URL),
+// which some Sheets API requests need instead of its name.
+// An empty name means the first sheet.
+func (r *runner) sheetID(ctx context.Context, sheetName string) (int64, error) {
+	ss, err := r.svc.get(ctx, r.sheetKey, "sheets.properties(sheetId,title)")
+	if err != nil {
+		return 0, fmt.Errorf("listing sheets: %w", err)
+	}
+	for _, sh := range ss.Sheets {
+		if sheetName == "" || sh.Properties.Title == sheetName {
+			return sh.Properties.SheetId, nil
+		}
+	}
+	return 0, fmt.Errorf("no sheet named %q", sheetName)
+}
+
+// batchUpdate sends formatting (and other non-value) requests for the spreadsheet.
+func (r *runner) batchUpdate(ctx context.Context, reqs ...*sheets.Request) error {
+	_, err := r.svc.batchUpdate(ctx, r.sheetKey, &sheets.BatchUpdateSpreadsheetRequest{Requests: reqs})
+	return err
+}
+
+// columnRange is the range of a whole column in the given sheet,
+// excluding the heading row.
+func columnRange(sheetID int64, col int) *sheets.GridRange {
+	return &sheets.GridRange{
+		SheetId:          sheetID,
+		StartRowIndex:    1,
+		StartColumnIndex: int64(col),
+		EndColumnIndex:   int64(col + 1),
+
+		// The first sheet's ID is normally 0,
+		// which would otherwise be left out of the request as an "empty" value.
+		ForceSendFields: []string{"SheetId"},
+	}
+}
+
+// These are the background colors for highlighting price changes.
+var (
+	priceUpColor   = &sheets.Color{Red: 0.85, Green: 0.95, Blue: 0.85}
+	priceDownColor = &sheets.Color{Red: 0.96, Green: 0.8, Blue: 0.8}
+)
+
+// addChangeRules adds conditional-formatting rules to the price column of the given sheet,
+// making a cell green if its price is higher than the one in the Previous price column,
+// and red if it's lower.
+// If the rules are already present
+// (from an earlier run),
+// they are not added again.
+func (r *runner) addChangeRules(ctx context.Context, sheetName string, priceCol, previousPriceCol int) error {
+	ss, err := r.svc.get(ctx, r.sheetKey, "sheets(properties(sheetId,title),conditionalFormats)")
+	if err != nil {
+		return fmt.Errorf("reading conditional formats: %w", err)
+	}
+
+	var sh *sheets.Sheet
+	for _, s := range ss.Sheets {
+		if sheetName == "" || s.Properties.Title == sheetName {
+			sh = s
+			break
+		}
+	}
+	if sh == nil {
+		return fmt.Errorf("no sheet named %q", sheetName)
+	}
+
+	// The formulas are relative to the first cell in the range (row 2).
+	var (
+		price    = fmt.Sprintf("$%s2", a1.ColName(priceCol))
+		previous = fmt.Sprintf("$%s2", a1.ColName(previousPriceCol))
+		upRule   = fmt.Sprintf("=AND(ISNUMBER(%s), ISNUMBER(%s), %s>%s)", price, previous, price, previous)
+		downRule = fmt.Sprintf("=AND(ISNUMBER(%s), ISNUMBER(%s), %s<%s)", price, previous, price, previous)
+	)
+
+	existing := make(map[string]bool)
+	for _, cf := range sh.ConditionalFormats {
+		if cf.BooleanRule == nil || cf.BooleanRule.Condition == nil {
+			continue
+		}
+		for _, v := range cf.BooleanRule.Condition.Values {
+			existing[v.UserEnteredValue] = true
+		}
+	}
+
+	var reqs []*sheets.Request
+	for _, rule := range []struct {
+		formula string
+		color   *sheets.Color
+	}{
+		{formula: upRule, color: priceUpColor},
+		{formula: downRule, color: priceDownColor},
+	} {
+		if existing[rule.formula] {
+			continue
+		}
+		reqs = append(reqs, &sheets.Request{
+			AddConditionalFormatRule: &sheets.AddConditionalFormatRuleRequest{
+				Rule: &sheets.ConditionalFormatRule{
+					Ranges: []*sheets.GridRange{columnRange(sh.Properties.SheetId, priceCol)},
+					BooleanRule: &sheets.BooleanRule{
+						Condition: &sheets.BooleanCondition{
+							Type:   "CUSTOM_FORMULA",
+							Values: []*sheets.ConditionValue{{UserEnteredValue: rule.formula}},
+						},
+						Format: &sheets.CellFormat{BackgroundColor: rule.color},
+					},
+				},
+			},
+		})
+	}
+	if len(reqs) == 0 {
+		return nil
+	}
+	if err := r.batchUpdate(ctx, reqs...); err != nil {
+		return fmt.Errorf("adding conditional formats: %w", err)
+	}
+	return nil
+}
+
+// highlightPrices sets the background colors of the given rows' price cells.
+// A nil color clears the background,
+// so highlights from an earlier run don't linger on cards that didn't move much this time.
+func (r *runner) highlightPrices(ctx context.Context, sheetName string, priceCol int, colors map[int]*sheets.Color) error {
+	id, err := r.sheetID(ctx, sheetName)
+	if err != nil {
+		return err
+	}
+
+	var reqs []*sheets.Request
+	for rownum, color := range colors {
+		reqs = append(reqs, &sheets.Request{
+			RepeatCell: &sheets.RepeatCellRequest{
+				Range: &sheets.GridRange{
+					SheetId:          id,
+					StartRowIndex:    int64(rownum),
+					EndRowIndex:      int64(rownum + 1),
+					StartColumnIndex: int64(priceCol),
+					EndColumnIndex:   int64(priceCol + 1),
+					ForceSendFields:  []string{"SheetId"},
+				},
+				Cell: &sheets.CellData{
+					UserEnteredFormat: &sheets.CellFormat{BackgroundColor: color},
+				},
+				Fields: "userEnteredFormat.backgroundColor",
+			},
+		})
+	}
+	if err := r.batchUpdate(ctx, reqs...); err != nil {
+		return fmt.Errorf("highlighting price changes: %w", err)
+	}
+	return nil
+}
+
+// formatPriceColumn applies r.currencyFormat
+// (a number-format pattern like "$#,##0.00")
+// to the price column of the given sheet.
+// See https://developers.google.com/sheets/api/guides/formats.
+func (r *runner) formatPriceColumn(ctx context.Context, sheetName string, col int) error {
+	id, err := r.sheetID(ctx, sheetName)
+	if err != nil {
+		return err
+	}
+	req := &sheets.Request{
+		RepeatCell: &sheets.RepeatCellRequest{
+			Range: columnRange(id, col),
+			Cell: &sheets.CellData{
+				UserEnteredFormat: &sheets.CellFormat{
+					NumberFormat: &sheets.NumberFormat{
+						Type:    "CURRENCY",
+						Pattern: r.currencyFormat,
+					},
+				},
+			},
+			Fields: "userEnteredFormat.numberFormat",
+		},
+	}
+	if err := r.batchUpdate(ctx, req); err != nil {
+		return fmt.Errorf("setting price format: %w", err)
+	}
+	return nil
+}
+
+// errorNotePrefix begins the notes that setErrorNotes adds,
+// so majic can tell them from notes people have added.
+const errorNotePrefix = "majic: "
+
+// cellNotes returns the notes on the cells in column col of the given sheet,
+// by row number.
+func (r *runner) cellNotes(ctx context.Context, sheetName string, col int) (map[int]string, error) {
+	ss, err := r.svc.get(ctx, r.sheetKey, "sheets(properties(title),data(startRow,startColumn,rowData(values(note))))")
+	if err != nil {
+		return nil, fmt.Errorf("reading notes: %w", err)
+	}
+	result := make(map[int]string)
+	for _, sh := range ss.Sheets {
+		if sh.Properties == nil || sh.Properties.Title != sheetName {
+			continue
+		}
+		for _, data := range sh.Data {
+			c := col - int(data.StartColumn)
+			for i, rd := range data.RowData {
+				if c >= 0 && c < len(rd.Values) && rd.Values[c].Note != "" {
+					result[int(data.StartRow)+i] = rd.Values[c].Note
+				}
+			}
+		}
+	}
+	return result, nil
+}
+
+// setErrorNotes sets the notes on the given rows' cells in column col
+// (the card-name column),
+// explaining why majic couldn't price them
+// (see -error-notes).
+// An empty note removes the note.
+// Callers should leave alone cells with notes that people have added
+// (the ones without errorNotePrefix).
+func (r *runner) setErrorNotes(ctx context.Context, sheetName string, col int, notes map[int]string) error {
+	id, err := r.sheetID(ctx, sheetName)
+	if err != nil {
+		return err
+	}
+
+	var reqs []*sheets.Request
+	for rownum, note := range notes {
+		reqs = append(reqs, &sheets.Request{
+			RepeatCell: &sheets.RepeatCellRequest{
+				Range: &sheets.GridRange{
+					SheetId:          id,
+					StartRowIndex:    int64(rownum),
+					EndRowIndex:      int64(rownum + 1),
+					StartColumnIndex: int64(col),
+					EndColumnIndex:   int64(col + 1),
+					ForceSendFields:  []string{"SheetId"},
+				},
+				Cell:   &sheets.CellData{Note: note},
+				Fields: "note",
+			},
+		})
+	}
+	if err := r.batchUpdate(ctx, reqs...); err != nil {
+		return fmt.Errorf("setting notes: %w", err)
+	}
+	return nil
+}
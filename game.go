@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// A cardCatalog looks up the cards of one game,
+// with their prices.
+// Scryfall is the catalog for Magic;
+// see games for the others.
+//
+// Results are in the form of a scryfall response,
+// which has room for everything majic writes to a sheet.
+// Other catalogs fill in what they can
+// (at least Name and Prices)
+// and leave the rest empty.
+// If there's no such card,
+// the error wraps majicerr.ErrCardNotFound.
+type cardCatalog interface {
+	card(ctx context.Context, name, setCode, number, lang string, foil, cheapest bool) (*respObj, error)
+}
+
+// magicGame is the default game.
+// Features that depend on scryfall's list of Magic sets
+// (the Set name column and -validate-sets)
+// are available only for sheets of this game.
+const magicGame = "magic"
+
+// games are the names of the games majic can price,
+// for use with the -game flag.
+var games = []string{magicGame, pokemonGame, yugiohGame}
+
+// parseGames parses the values of the -game flag.
+// Each is either a game name by itself,
+// which applies to any sheet not otherwise mentioned,
+// or SHEET=GAME,
+// which applies to the named sheet.
+// The result maps sheet names to games,
+// with the default game under the empty string.
+func parseGames(specs []string) (map[string]string, error) {
+	result := map[string]string{"": magicGame}
+	for _, spec := range specs {
+		sheetName, game, ok := strings.Cut(spec, "=")
+		if !ok {
+			sheetName, game = "", spec
+		}
+		game = strings.ToLower(strings.TrimSpace(game))
+		if !slices.Contains(games, game) {
+			return nil, fmt.Errorf("unknown game %q (want one of %s)", game, strings.Join(games, ", "))
+		}
+		result[strings.TrimSpace(sheetName)] = game
+	}
+	return result, nil
+}
+
+// gameFor tells which game the named sheet holds.
+func (r *runner) gameFor(sheetName string) string {
+	if game, ok := r.games[sheetName]; ok {
+		return game
+	}
+	if game, ok := r.games[""]; ok {
+		return game
+	}
+	return magicGame
+}
+
+// catalogFor returns the card catalog for the named sheet.
+// For Magic,
+// that's normally r.scryfall wrapped in a coalescingCatalog
+// (see main).
+func (r *runner) catalogFor(sheetName string) cardCatalog {
+	if cat, ok := r.catalogs[r.gameFor(sheetName)]; ok {
+		return cat
+	}
+	return r.scryfall
+}
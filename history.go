@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A history is a record of past runs,
+// including the value of the collection after each one.
+// It's kept in a JSON file
+// (see the -history flag)
+// and used by the dashboard
+// (see dashboard.go).
+//
+// A nil *history is valid and records nothing.
+type history struct {
+	filename string
+
+	mu      sync.Mutex
+	entries []historyRun
+}
+
+// A historyRun is the record of a single run.
+type historyRun struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Value   float64   `json:"value"` // The total of the prices in the processed sheets after the run.
+	Updated int       `json:"updated"`
+	Skipped int       `json:"skipped"`
+	Errors  int       `json:"errors"`
+	Err     string    `json:"error,omitempty"`   // Why the run failed, if it did.
+	Partial bool      `json:"partial,omitempty"` // Whether the run priced only some rows (see webhook.go and budget.go), so Value is not the whole collection's.
+
+	// Backfilled is true for a made-up run
+	// whose Value was computed from historical prices
+	// by "majic backfill"
+	// (see backfill.go).
+	Backfilled bool `json:"backfilled,omitempty"`
+
+	// Some of the rows that could not be priced.
+	RowErrors []historyRowError `json:"row_errors,omitempty"`
+}
+
+// A historyRowError is a row that could not be priced in some run.
+type historyRowError struct {
+	Sheet string `json:"sheet,omitempty"`
+	Row   int    `json:"row"` // One-based.
+	Card  string `json:"card"`
+	Err   string `json:"error"`
+}
+
+// These limit the size of the history file.
+const (
+	maxHistoryRuns      = 1000
+	maxHistoryRowErrors = 20
+)
+
+// loadHistory loads the history in the given file.
+// It's not an error for the file not to exist.
+func loadHistory(filename string) (*history, error) {
+	h := &history{filename: filename}
+
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(&h.entries); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return h, nil
+}
+
+// runs returns a copy of the recorded runs,
+// oldest first.
+func (h *history) runs() []historyRun {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]historyRun(nil), h.entries...)
+}
+
+// add records a run and saves the history to its file.
+func (h *history) add(run historyRun) error {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.entries = append(h.entries, run)
+	return h.save()
+}
+
+// backfill adds the given runs,
+// which must be in chronological order,
+// to the beginning of the history,
+// skipping any that aren't earlier than the first run already recorded,
+// and saves the history to its file.
+//
+// The history holds at most maxHistoryRuns runs,
+// and save would make room by dropping the oldest ones,
+// which are the ones being added here.
+// So runs that don't fit are dropped here instead
+// (the oldest of them first).
+// It returns the number of runs added
+// and the number dropped for lack of room.
+func (h *history) backfill(runs []historyRun) (added, dropped int, err error) {
+	if h == nil {
+		return 0, 0, nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) > 0 {
+		first := h.entries[0].Start
+		n := 0
+		for n < len(runs) && runs[n].End.Before(first) {
+			n++
+		}
+		runs = runs[:n]
+	}
+	if room := max(maxHistoryRuns-len(h.entries), 0); len(runs) > room {
+		dropped = len(runs) - room
+		runs = runs[dropped:]
+	}
+	if len(runs) == 0 {
+		return 0, dropped, nil
+	}
+	h.entries = append(append([]historyRun(nil), runs...), h.entries...)
+	return len(runs), dropped, h.save()
+}
+
+// save writes the history to its file,
+// first dropping the oldest runs if there are too many.
+// The caller must hold h.mu.
+func (h *history) save() error {
+	if len(h.entries) > maxHistoryRuns {
+		h.entries = h.entries[len(h.entries)-maxHistoryRuns:]
+	}
+
+	if err := os.MkdirAll(filepath.Dir(h.filename), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", h.filename, err)
+	}
+
+	// As in responseCache.save,
+	// write to a temporary file and rename it.
+	tmpname := h.filename + ".tmp"
+	f, err := os.Create(tmpname)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", tmpname, err)
+	}
+	defer os.Remove(tmpname)
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(h.entries); err != nil {
+		return fmt.Errorf("writing %s: %w", tmpname, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("closing %s: %w", tmpname, err)
+	}
+	if err := os.Rename(tmpname, h.filename); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmpname, h.filename, err)
+	}
+	return nil
+}
+
+// dataFile returns the path of a file in majic's data directory
+// (e.g. $HOME/.config/majic on Linux),
+// for things that, unlike the contents of the cache directory
+// (see cacheFile),
+// can't be recreated if lost.
+func dataFile(name string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "majic", name), nil
+}
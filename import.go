@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An importRow is a card read from another site's collection export.
+type importRow struct {
+	name, setCode, setName, collectorNumber string
+	foil                                    bool
+	quantity                                int
+	condition, language                     string
+	purchasePrice                           *float64
+}
+
+// An importProfile describes the CSV format
+// in which a collection-tracking website exports collections.
+// The parse function gets each record of the CSV file
+// as a map from lowercase column heading to value.
+type importProfile func(rec map[string]string) importRow
+
+// importProfiles are the CSV formats that the import subcommand can read,
+// keyed by the value of its -format flag.
+var importProfiles = map[string]importProfile{
+	// See https://deckbox.org/help/import_export.
+	"deckbox": func(rec map[string]string) importRow {
+		return importRow{
+			name:            rec["name"],
+			setName:         rec["edition"],
+			collectorNumber: rec["card number"],
+			foil:            strings.EqualFold(rec["foil"], "foil"),
+			quantity:        atoiOr(rec["count"], 1),
+			condition:       conditionCode(rec["condition"]),
+			language:        rec["language"],
+			purchasePrice:   optPrice(rec["my price"]),
+		}
+	},
+
+	// This is the format of the TCGplayer app's collection export.
+	// Its Condition column includes the finish,
+	// as in "Near Mint Foil."
+	"tcgplayer": func(rec map[string]string) importRow {
+		name := rec["simple name"]
+		if name == "" {
+			name = rec["name"]
+		}
+		cond, foil := strings.CutSuffix(rec["condition"], " Foil")
+		if strings.EqualFold(rec["printing"], "foil") {
+			foil = true
+		}
+		return importRow{
+			name:            name,
+			setCode:         strings.ToLower(rec["set code"]),
+			setName:         rec["set"],
+			collectorNumber: rec["card number"],
+			foil:            foil,
+			quantity:        atoiOr(rec["quantity"], 1),
+			condition:       conditionCode(cond),
+			language:        rec["language"],
+			purchasePrice:   optPrice(rec["price"]),
+		}
+	},
+}
+
+// importCards implements the "import" subcommand.
+// It reads a collection exported from another site
+// and adds its cards to the sheet.
+//
+// A card that's already in the sheet
+// (with the same name, set, collector number, and finish)
+// has its quantity increased instead,
+// if the sheet has a Quantity column,
+// and is otherwise left alone.
+// With -append,
+// every card is added as a new row.
+func (r *runner) importCards(ctx context.Context, appendOnly bool, format, filename string, _ []string) error {
+	profile, ok := importProfiles[format]
+	if !ok {
+		return fmt.Errorf("unknown import format %q", format)
+	}
+
+	cards, err := readImport(filename, profile)
+	if err != nil {
+		return err
+	}
+
+	// Some formats have set names instead of codes.
+	sets, err := r.scryfall.sets(ctx)
+	if err != nil {
+		return err
+	}
+
+	t, err := r.openTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Index the existing rows for merging.
+	existing := make(map[string]int)
+	if !appendOnly {
+		for rownum := t.headerRow + 1; rownum < t.endRow; rownum++ {
+			// As in processRow,
+			// a row with a set name and no set code
+			// is in the set with that name.
+			setCode := t.cell(rownum, setCodeField)
+			if setCode == "" {
+				if code, ok := sets.setCode(t.cell(rownum, setNameField)); ok {
+					setCode = code
+				}
+			}
+			key := importKey(t.cell(rownum, cardNameField), setCode, t.cell(rownum, collectorNumberField), isTrue(t.cell(rownum, foilField)))
+			existing[key] = rownum
+		}
+	}
+
+	var (
+		newRows          [][]any
+		updates          []cellUpdate
+		quantities       = make(map[int]int) // New quantities of existing rows.
+		quantityCol      = t.col(quantityField)
+		merged, unmerged int
+	)
+	for _, c := range cards {
+		if c.setCode == "" && c.setName != "" {
+			if code, ok := sets.setCode(c.setName); ok {
+				c.setCode = code
+			} else {
+				slog.Warn("Unknown set name", "card", c.name, "set", c.setName)
+			}
+		}
+
+		key := importKey(c.name, c.setCode, c.collectorNumber, c.foil)
+		if rownum, ok := existing[key]; ok && !appendOnly {
+			if quantityCol >= 0 {
+				q, ok := quantities[rownum]
+				if !ok {
+					q = atoiOr(t.cell(rownum, quantityField), 1)
+				}
+				quantities[rownum] = q + c.quantity
+				merged++
+			} else {
+				unmerged++
+			}
+			continue
+		}
+
+		row, set := t.newRow()
+		set(cardNameField, c.name)
+		set(setCodeField, c.setCode)
+		set(setNameField, c.setName)
+		set(collectorNumberField, c.collectorNumber)
+		set(foilField, c.foil)
+		set(quantityField, c.quantity)
+		set(conditionField, c.condition)
+		set(languageField, c.language)
+		if c.purchasePrice != nil {
+			set(purchasePriceField, *c.purchasePrice)
+		}
+		newRows = append(newRows, row)
+	}
+
+	for rownum, q := range quantities {
+		updates = append(updates, cellUpdate{row: rownum, col: quantityCol, val: q})
+	}
+	if err := r.writeUpdates(ctx, t.sheetName, t.orig, updates); err != nil {
+		return err
+	}
+	if err := r.appendRows(ctx, t, newRows); err != nil {
+		return err
+	}
+
+	slog.Info("Import done", "added", len(newRows), "merged", merged, "already present", unmerged)
+	return nil
+}
+
+// readImport reads a CSV file in the format of the given profile.
+// A filename of "-" means the standard input.
+func readImport(filename string, profile importProfile) ([]importRow, error) {
+	var f io.Reader = os.Stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", filename, err)
+		}
+		defer file.Close()
+		f = file
+	}
+
+	cr := csv.NewReader(f)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filename, err)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	header := records[0]
+	for i, h := range header {
+		header[i] = strings.ToLower(strings.TrimSpace(h))
+	}
+
+	var result []importRow
+	for _, rec := range records[1:] {
+		m := make(map[string]string)
+		for i, val := range rec {
+			if i < len(header) {
+				m[header[i]] = strings.TrimSpace(val)
+			}
+		}
+		if row := profile(m); row.name != "" {
+			result = append(result, row)
+		}
+	}
+	return result, nil
+}
+
+// importKey identifies a card for merging imported cards into existing rows.
+func importKey(name, setCode, collectorNumber string, foil bool) string {
+	return cacheKey(name, setCode, collectorNumber, strconv.FormatBool(foil))
+}
+
+// atoiOr parses s as an integer,
+// returning dflt if it isn't one.
+func atoiOr(s string, dflt int) int {
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		return n
+	}
+	return dflt
+}
+
+// optPrice parses s as a price,
+// returning nil if it isn't one.
+func optPrice(s string) *float64 {
+	if p, ok := parsePrice(s); ok {
+		return &p
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build lambda
+
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+)
+
+// This file adds AWS Lambda to the serverlessPlatforms.
+// It's included only in builds with "-tags lambda,"
+// so that ordinary builds don't need AWS's library.
+// A Lambda function is built for the "provided.al2023" runtime like this:
+//
+//	GOOS=linux GOARCH=arm64 go build -tags lambda,lambda.norpc -o bootstrap
+//
+// and zipped up and uploaded.
+// Each invocation
+// (e.g. from an EventBridge schedule)
+// does one run;
+// the event's contents don't matter.
+
+func init() {
+	serverlessPlatforms = append(serverlessPlatforms, serverlessPlatform{
+		name:   "AWS Lambda",
+		detect: func() bool { return os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" },
+		start:  startLambda,
+	})
+}
+
+// startLambda handles Lambda invocations.
+// It doesn't return until ctx is canceled
+// (or the Lambda runtime fails).
+func startLambda(ctx context.Context, r *runner) error {
+	lambda.StartWithOptions(func(ctx context.Context) (invocationResult, error) {
+		return r.invoke(ctx)
+	}, lambda.WithContext(ctx))
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// busyMessage is written to the -busy-cell while a run is in progress.
+const busyMessage = "majic updating…"
+
+// markBusy writes busyMessage to r.busyCell,
+// so people looking at the spreadsheet know a run is in progress
+// and can hold off on editing it.
+// It returns a function that clears the cell again.
+func (r *runner) markBusy(ctx context.Context) (func(), error) {
+	vr := &sheets.ValueRange{Values: [][]any{{busyMessage}}}
+	err := r.svc.updateValues(ctx, r.sheetKey, r.busyCell, vr, "RAW")
+	if err != nil {
+		return nil, fmt.Errorf("writing busy message to %s: %w", r.busyCell, err)
+	}
+	sheetWrites.Inc()
+
+	return func() {
+		// Clear the cell even if the run was interrupted.
+		ctx := context.WithoutCancel(ctx)
+		err := r.svc.clearValues(ctx, r.sheetKey, r.busyCell)
+		if err != nil {
+			slog.Error("Could not clear busy message", "cell", r.busyCell, "err", err)
+		}
+	}, nil
+}
+
+// protectSheet adds a protected range covering the whole of the named sheet,
+// so that anyone editing it while majic is at work
+// gets a warning first.
+// (Majic itself can still write to it.)
+// It returns a function that removes the protection again.
+func (r *runner) protectSheet(ctx context.Context, sheetName string) (func(), error) {
+	sheetID, err := r.sheetID(ctx, sheetName)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &sheets.Request{
+		AddProtectedRange: &sheets.AddProtectedRangeRequest{
+			ProtectedRange: &sheets.ProtectedRange{
+				Description: busyMessage,
+				Range: &sheets.GridRange{
+					SheetId:         sheetID,
+					ForceSendFields: []string{"SheetId"}, // See columnRange.
+				},
+				WarningOnly: true,
+			},
+		},
+	}
+	resp, err := r.svc.batchUpdate(ctx, r.sheetKey, &sheets.BatchUpdateSpreadsheetRequest{Requests: []*sheets.Request{req}})
+	if err != nil {
+		return nil, fmt.Errorf("protecting sheet: %w", err)
+	}
+	if len(resp.Replies) == 0 || resp.Replies[0].AddProtectedRange == nil {
+		return nil, errors.New("no protected range in response")
+	}
+	id := resp.Replies[0].AddProtectedRange.ProtectedRange.ProtectedRangeId
+
+	return func() {
+		// Remove the protection even if the run was interrupted.
+		ctx := context.WithoutCancel(ctx)
+		err := r.batchUpdate(ctx, &sheets.Request{
+			DeleteProtectedRange: &sheets.DeleteProtectedRangeRequest{ProtectedRangeId: id},
+		})
+		if err != nil {
+			slog.Error("Could not remove sheet protection", "sheet", sheetName, "err", err)
+		}
+	}, nil
+}
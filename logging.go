@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// setupLogging installs the default slog logger,
+// writing to w at the given level
+// ("debug," "info," "warn," or "error")
+// in the given format
+// ("text" or "json").
+func setupLogging(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q", level)
+	}
+	opts := &slog.HandlerOptions{
+		Level: lvl,
+
+		// The text handler formats values with %+v,
+		// which for errors from some packages
+		// (like github.com/pkg/errors, used by some of majic's dependencies)
+		// includes a stack trace.
+		// Just show the error message.
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindAny {
+				if err, ok := a.Value.Any().(error); ok {
+					return slog.String(a.Key, err.Error())
+				}
+			}
+			return a
+		},
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
@@ -1,25 +1,24 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
-	"strings"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
-	"github.com/bobg/oauther/v3"
-	"github.com/pkg/errors"
+	"github.com/bobg/majic/a1"
+	"github.com/bobg/subcmd/v2"
 	"golang.org/x/time/rate"
-	"google.golang.org/api/option"
-	"google.golang.org/api/sheets/v4"
 )
 
-const namedCardAPIEndpoint = "https://api.scryfall.com/cards/named"
-
 // The function "main" in the package "main"
 // is where a Go program begins execution.
 //
@@ -28,34 +27,197 @@ const namedCardAPIEndpoint = "https://api.scryfall.com/cards/named"
 // like a normal Go function.
 // By contrast,
 // main has to do something else with errors,
-// like print them
+// like log them and exit
 // (which is what it does with anything that run returns).
 func main() {
 	err := run()
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("Fatal error", "err", err)
+		os.Exit(1)
 	}
 }
 
 func run() error {
 	// Parse the command-line flags.
 	var (
-		authcode  string // Auth code if needed to obtain an OAuth token.
-		credsFile string // The file containing Google auth credentials for this application.
-		sheetKey  string // The "key" of the spreadsheet - in a "docs.google.com/spreadsheets/d/KEY/edit" URL, it's the "KEY" part.
-		sheetName string // The name of the sheet to operate on within the spreadsheet.
-		tokenFile string // The file in which to store an OAuth token.
+		alertSpecs     []string      // Where to send price alerts; see parseAlertSink.
+		alertThreshold string        // How big a price move triggers an alert.
+		auth           authOpts      // How to authenticate to Google.
+		backups        int           // If positive, how many backups of each sheet to keep.
+		bulk           bool          // Whether to look cards up in scryfall's bulk data first.
+		busyCell       string        // If set, a cell in which to say a run is in progress.
+		cachePath      string        // Where to keep cached scryfall responses.
+		cacheTTL       time.Duration // How long cached scryfall responses are good for.
+		canonicalNames bool          // Whether to write scryfall's form of each card name back to the sheet.
+		changeRules    bool          // Whether to highlight price changes with conditional formatting.
+		chartSheet     string        // If set, the sheet in which to chart the collection's value over time.
+		cheapest       bool          // Whether to price rows with no set code by their cheapest printing.
+		configFile     string        // An optional JSON file with further settings.
+		createColumns  bool          // Whether to add missing columns to the sheet instead of failing.
+		currencyFormat string        // If set, a number-format pattern for the price column.
+		daemonMode     bool          // Whether to keep running, updating prices periodically.
+		dashboardAddr  string        // In daemon mode, if set, the address on which to serve a web dashboard.
+		decimalComma   bool          // Whether to write prices as text with a decimal comma.
+		demoFile       string        // If set, a CSV file (or "sample") to use instead of the Google spreadsheet.
+		errorNotes     bool          // Whether to add notes explaining failures to card-name cells.
+		filter         string        // If set, only rows matching this expression are processed.
+		force          bool          // Whether to update rows regardless of when they were last updated.
+		gameSpecs      []string      // Which game each sheet holds, each in the form "game" or "sheet=game".
+		headerRow      int           // If positive, the row containing column headings.
+		headings       []string      // Column-heading remappings, each in the form "field=Heading".
+		highlight      string        // Highlight price cells that move at least this much.
+		historyPath    string        // Where to record each run.
+		imageFormula   bool          // Whether to write the Image column as an =IMAGE formula.
+		linkFormula    bool          // Whether to write the Link column as a =HYPERLINK formula.
+		interval       time.Duration // In daemon mode, how long to wait between runs.
+		logFormat      string        // The format of log output: "text" or "json".
+		logLevel       string        // The minimum level of log messages to show.
+		maxAge         time.Duration // Rows updated more recently than this are skipped.
+		maxLookups     int           // If positive, the most card-API calls to make in a run.
+		metricsAddr    string        // If set, the address on which to serve Prometheus metrics.
+		noPrice        string        // What to write when a card has no price.
+		notifyMovers   int           // How many big price movers to list in run summaries.
+		notifyWebhook  string        // If set, a Discord or Slack webhook URL for run summaries.
+		pokemonTCGKey  string        // If set, the API key for pokemontcg.io.
+		priceDecimals  int           // If non-negative, the number of decimal places to round prices to.
+		profileName    string        // If set, the profile in the config file to use.
+		protect        bool          // Whether to protect each sheet (with a warning) while updating it.
+		queuePath      string        // If set, the file in which to keep a work queue of rows.
+		quiet          bool          // Whether to suppress progress output.
+		rangeSpec      string        // If set, the part of each sheet to process.
+		reportFile     string        // If set, where to write a JSON report of the run.
+		requestTimeout time.Duration // The time limit for each card API call.
+		scryfallRate   float64       // The most scryfall API calls per second.
+		sheetKey       string        // The "key" of the spreadsheet - in a "docs.google.com/spreadsheets/d/KEY/edit" URL, it's the "KEY" part.
+		sheetName      string        // The name(s) of the sheet(s) to operate on within the spreadsheet.
+		sheetsRate     float64       // The most Google Sheets API calls per second.
+		sortSpec       string        // If set, the columns by which to sort each sheet after updating it.
+		stalestFirst   bool          // Whether to process the least recently updated rows first.
+		stopAtBlank    bool          // Whether to stop processing a sheet at the first blank row.
+		stripZeros     bool          // Whether to write prices as text without trailing zeros.
+		tcgplayerKey   string        // If set, the TCGplayer API key pair, for sealed product.
+		timeout        time.Duration // If positive, the time limit for each run.
+		useTUI         bool          // Whether to show a full-screen interactive display.
+		validateSets   bool          // Whether to check set codes before updating prices.
+		verbose        bool          // Whether to show per-row details.
+		webhookSecret  string        // If set, the secret that authenticates requests to /webhook.
 	)
-	flag.StringVar(&authcode, "authcode", "", "auth code if needed to obtain an OAuth token")
-	flag.StringVar(&credsFile, "creds", "creds.json", "path of JSON credentials file")
+	flag.Func("alert", `send price alerts to "stdout", "webhook:URL", or "email:ADDRESS" (repeatable)`, func(s string) error {
+		alertSpecs = append(alertSpecs, s)
+		return nil
+	})
+	flag.StringVar(&alertThreshold, "alert-threshold", "", `alert when a price moves by at least this much, e.g. "2.50" or "20%"`)
+	flag.StringVar(&auth.authcode, "authcode", "", "auth code if needed to obtain an OAuth token")
+	flag.IntVar(&backups, "backup", 0, "before each run, copy each sheet to a hidden backup sheet, keeping this many backups of each (0 for none)")
+	flag.BoolVar(&bulk, "bulk", false, `look cards up in scryfall's bulk data (downloaded with "majic bulk sync") before calling the API`)
+	flag.StringVar(&busyCell, "busy-cell", "", `write "majic updating…" to this cell (e.g. "Sheet1!H1") during each run`)
+	flag.StringVar(&cachePath, "cache", "", "path of scryfall response cache file (default is in the user cache directory)")
+	flag.DurationVar(&cacheTTL, "cache-ttl", 12*time.Hour, "reuse cached scryfall responses younger than this (0 to disable the cache)")
+	flag.BoolVar(&canonicalNames, "canonical-names", false, `replace card names with scryfall's full form, e.g. "Fire" with "Fire // Ice"`)
+	flag.BoolVar(&changeRules, "change-rules", false, `add conditional formatting to the price column highlighting changes from the "Previous price" column`)
+	flag.StringVar(&chartSheet, "chart-sheet", "", `keep a chart of the collection's value over time in this sheet, e.g. "Chart data"`)
+	flag.BoolVar(&cheapest, "cheapest", false, "for rows with no set code, use the price of the cheapest printing")
+	flag.StringVar(&configFile, "config", "", "path of optional JSON config file")
+	flag.BoolVar(&createColumns, "create-columns", false, "add missing columns (other than card name) to the sheet")
+	flag.StringVar(&auth.credKeySpec, "cred-key", "", `decrypt age-encrypted -creds, -token, and -service-account files with the passphrase or age key in this file, or in $VAR with "env:VAR"`)
+	flag.StringVar(&currencyFormat, "currency-format", "", `apply this number format to the price column, e.g. "$#,##0.00"`)
+	flag.StringVar(&auth.credsFile, "creds", "creds.json", `path of JSON credentials file, or "env:VAR" for the contents of $VAR`)
+	flag.BoolVar(&daemonMode, "daemon", false, "keep running, updating prices every -interval")
+	flag.StringVar(&dashboardAddr, "dashboard-addr", "", "with -daemon, serve a web dashboard on this address (e.g. localhost:8080)")
+	flag.BoolVar(&decimalComma, "decimal-comma", false, `write prices as text with a decimal comma, as in "1,50"`)
+	flag.StringVar(&demoFile, "demo", "", `use this CSV file instead of the Google spreadsheet, and show what would change; "sample" for a built-in example that needs no network`)
+	flag.BoolVar(&errorNotes, "error-notes", false, "add a note to the card name of each row that can't be priced, saying why")
+	flag.StringVar(&filter, "filter", "", `process only rows matching this expression, e.g. 'set == "NEO" && price > 5'`)
+	flag.BoolVar(&force, "force", false, "update every row, ignoring the last-updated time")
+	flag.Func("game", `the game a sheet holds, as in "yugioh" (all sheets) or "Binder=yugioh" (one sheet); "magic" is the default (repeatable)`, func(s string) error {
+		gameSpecs = append(gameSpecs, s)
+		return nil
+	})
+	flag.IntVar(&headerRow, "header-row", 0, "number of the row containing column headings (default is the first row with a card-name heading)")
+	flag.Func("heading", `use a different column heading for a field, as in "price=Preis" (repeatable)`, func(s string) error {
+		headings = append(headings, s)
+		return nil
+	})
+	flag.StringVar(&highlight, "highlight-movers", "", `color price cells that moved at least this much, e.g. "2.50" or "20%"`)
+	flag.StringVar(&historyPath, "history", "", `file in which to record each run (default is in the user config directory, "none" to disable)`)
+	flag.BoolVar(&imageFormula, "image-formula", false, "write the Image column as an =IMAGE formula showing the card, instead of a URL")
+	flag.DurationVar(&interval, "interval", 24*time.Hour, "in -daemon mode, time between runs")
+	flag.BoolVar(&linkFormula, "link-formula", false, "write the Link column as a =HYPERLINK formula with the card name as its text, instead of a URL")
+	flag.StringVar(&logFormat, "log-format", "text", `log format, "text" or "json"`)
+	flag.StringVar(&logLevel, "log-level", "info", `minimum log level: "debug," "info," "warn," or "error"`)
+	flag.DurationVar(&maxAge, "max-age", 24*time.Hour, "skip rows updated more recently than this")
+	flag.IntVar(&maxLookups, "max-lookups", 0, "stop each run after this many card lookups (e.g. scryfall calls), keeping the updates made so far")
+	flag.StringVar(&metricsAddr, "metrics-addr", "", "address (e.g. :9090) on which to serve Prometheus metrics at /metrics (useful with -daemon)")
+	flag.StringVar(&noPrice, "no-price", "N/A", `what to write when a card has no price, e.g. "0" (or "" to leave the cell blank)`)
+	flag.IntVar(&notifyMovers, "notify-movers", 0, "list this many of the biggest price changes in -notify-webhook summaries")
+	flag.StringVar(&notifyWebhook, "notify-webhook", "", "post a summary of each run to this Discord or Slack webhook URL")
+	flag.BoolVar(&auth.localServer, "oauth-local", false, "complete OAuth authorization in the browser via a localhost callback server")
+	flag.StringVar(&pokemonTCGKey, "pokemontcg-key", os.Getenv("POKEMONTCG_API_KEY"), "API key for pokemontcg.io, for a higher rate limit with -game pokemon (default is $POKEMONTCG_API_KEY)")
+	flag.IntVar(&priceDecimals, "price-decimals", -1, "round prices to this many decimal places, e.g. 2 for cents (default is as reported)")
+	flag.StringVar(&profileName, "profile", "", "use the settings of this profile in the -config file (sheet key, sheet name, creds, token, and headings)")
+	flag.BoolVar(&protect, "protect", false, "while updating a sheet, protect it so others get a warning if they try to edit it")
+	flag.StringVar(&queuePath, "queue", "", "keep a queue of rows to process in this file, writing results as they're done, so an interrupted run can resume where it left off")
+	flag.BoolVar(&quiet, "quiet", false, "suppress progress output")
+	flag.StringVar(&rangeSpec, "range", "", `process only this range of each sheet, e.g. "A3:H200", or the named range with this name`)
+	flag.StringVar(&reportFile, "report", "", "write a JSON report of the run to this file")
+	flag.DurationVar(&requestTimeout, "request-timeout", 30*time.Second, "time limit for each call to a card API such as scryfall (0 for none)")
+	flag.Float64Var(&scryfallRate, "scryfall-rate", 10, "most scryfall API calls per second (at most 10, as scryfall asks)")
+	flag.StringVar(&auth.serviceAccount, "service-account", "", `path of service-account JSON key file, or "env:VAR" for the contents of $VAR (instead of -creds, -token, and -authcode)`)
 	flag.StringVar(&sheetKey, "sheetkey", "10ie9Wze3Byo_YqayMxNWnEWhlsn1ir2C10gO-fjsaUE", "spreadsheet key")
-	flag.StringVar(&sheetName, "sheetname", "", "sheet name")
-	flag.StringVar(&tokenFile, "token", "token.json", "path of OAuth token file")
+	flag.StringVar(&sheetName, "sheetname", "", `sheet name or gid=N, or comma-separated list of names, gids, or glob patterns, or "all"`)
+	flag.Float64Var(&sheetsRate, "sheets-rate", 1, "most Google Sheets API calls per second")
+	flag.StringVar(&sortSpec, "sort", "", `after updating a sheet, sort it by these columns, e.g. "set, price desc"`)
+	flag.BoolVar(&stalestFirst, "stalest-first", false, "process the rows updated longest ago first, instead of from top to bottom (useful with -max-lookups)")
+	flag.BoolVar(&stopAtBlank, "stop-at-blank", false, "stop processing a sheet at the first blank row")
+	flag.BoolVar(&stripZeros, "strip-zeros", false, `write prices as text without trailing zeros, as in "1.5" instead of "1.50"`)
+	flag.StringVar(&tcgplayerKey, "tcgplayer-key", os.Getenv("TCGPLAYER_KEY"), `TCGplayer API key pair, "PUBLIC:PRIVATE," for pricing rows with a Product ID (default is $TCGPLAYER_KEY)`)
+	flag.DurationVar(&timeout, "timeout", 0, "stop each run after this long (e.g. 30m), keeping the updates made so far")
+	flag.StringVar(&auth.tokenSpec, "token", "token.json", "where to store the OAuth token: a file path, keyring:NAME for the OS keychain, or env:VAR for an environment variable")
+	flag.BoolVar(&useTUI, "tui", false, "show an interactive full-screen display of the run, with keys to pause, skip rows, and stop")
+	flag.BoolVar(&verbose, "v", false, "show per-row details (same as -log-level debug)")
+	flag.BoolVar(&validateSets, "validate-sets", false, "before updating prices, check set codes against Scryfall's list of sets, skipping rows with unknown codes")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "with serve or -dashboard-addr, accept requests to price particular rows at /webhook, authenticated with this secret")
 	flag.Parse()
 
-	creds, err := os.ReadFile(credsFile)
-	if err != nil {
-		return errors.Wrapf(err, "reading credentials from %s", credsFile)
+	// Flags can also come from MAJIC_… environment variables.
+	// See envflags.go.
+	if err := setFlagsFromEnv(); err != nil {
+		return err
+	}
+
+	// Read the config file, if there is one.
+	// Any -heading flags override what's in it.
+	cfg := new(config)
+	if configFile != "" {
+		var err error
+		cfg, err = readConfig(configFile)
+		if err != nil {
+			return fmt.Errorf("reading config: %w", err)
+		}
+	}
+	if profileName != "" {
+		if configFile == "" {
+			return fmt.Errorf("-profile needs -config")
+		}
+		if err := cfg.useProfile(profileName); err != nil {
+			return err
+		}
+	}
+	for _, h := range headings {
+		if err := cfg.setHeading(h); err != nil {
+			return err
+		}
+	}
+
+	// Rewrite -busy-cell in canonical form,
+	// quoting the sheet name if needed
+	// (so "My Binder!H1" becomes "'My Binder'!H1").
+	if busyCell != "" {
+		rng, err := a1.Parse(busyCell)
+		if err != nil {
+			return fmt.Errorf("parsing -busy-cell: %w", err)
+		}
+		busyCell = rng.String()
 	}
 
 	// We need two rate-limiters.
@@ -63,124 +225,439 @@ func run() error {
 	// (as requested in the "Good Citizenship" section at
 	// https://scryfall.com/docs/api).
 	// The other limits calls to the Google spreadsheets API to no more than one per second.
+	// Either can be lowered
+	// (or, for the spreadsheets API, raised, within Google's quota)
+	// with -scryfall-rate and -sheets-rate.
+	if scryfallRate <= 0 || scryfallRate > 10 {
+		return fmt.Errorf("-scryfall-rate must be more than 0 and at most 10")
+	}
+	if sheetsRate <= 0 {
+		return fmt.Errorf("-sheets-rate must be more than 0")
+	}
 	var (
-		cardAPILimiter = rate.NewLimiter(10, 1)
-		ssAPILimiter   = rate.NewLimiter(1, 1)
+		cardAPILimiter = rate.NewLimiter(rate.Limit(scryfallRate), 1)
+		ssAPILimiter   = rate.NewLimiter(rate.Limit(sheetsRate), 1)
 	)
 
+	// Each row update involves one call to the scryfall API.
+	// (Calls to the spreadsheet API are few, since all of a sheet's updates are written at once.)
+	// That determines how long an update takes,
+	// which the progress display uses to estimate the time remaining.
+	prog := newProgress(quiet, 1/float64(cardAPILimiter.Limit()))
+
+	// Log output goes through prog,
+	// so it doesn't collide with the progress display.
+	if verbose {
+		logLevel = "debug"
+	}
+	if err := setupLogging(prog, logLevel, logFormat); err != nil {
+		return err
+	}
+
+	// Card lookups spend from this budget
+	// (if -max-lookups is given).
+	// See budget.go.
+	var budget *lookupBudget
+	if maxLookups > 0 {
+		budget = &lookupBudget{max: int64(maxLookups)}
+	}
+
 	// This is the HTTP client to use for scryfall API calls.
 	// It contains the limiter above.
+	// The timeout keeps one hung connection from stalling the run;
+	// the row is skipped instead
+	// (and tried again on the next run).
+	// It's separate from -timeout,
+	// which limits the whole run.
 	cardAPIClient := &http.Client{
 		Transport: rateLimitedRoundTripper{
+			name:    "scryfall",
 			limiter: cardAPILimiter,
+			budget:  budget,
 		},
+		Timeout: requestTimeout,
 	}
 
-	ctx := context.Background()
-
-	// Creating the spreadsheet-API client is trickier.
-	// We first need to get an OAuth-authenticated HTTP client.
-	ssAPIClient, err := oauther.Client(ctx, tokenFile, authcode, creds, sheets.SpreadsheetsScope)
-	if err != nil {
-		return errors.Wrap(err, "authenticating")
+	// YGOPRODeck allows 20 calls per second.
+	// This stays well under that.
+	ygoprodeckAPIClient := &http.Client{
+		Transport: rateLimitedRoundTripper{
+			name:    "ygoprodeck",
+			limiter: rate.NewLimiter(10, 1),
+			budget:  budget,
+		},
+		Timeout: requestTimeout,
 	}
 
-	// Now that we have an OAuth-authenticated HTTP client,
-	// we can wrap its existing Transport field in a rateLimitedRoundTripper.
-	origTransport := ssAPIClient.Transport
-	if origTransport == nil {
-		origTransport = http.DefaultTransport
+	// Pokemontcg.io allows 30 calls per minute without an API key
+	// (and far more with one).
+	pokemonTCGLimiter := rate.NewLimiter(rate.Every(2*time.Second), 1)
+	if pokemonTCGKey != "" {
+		pokemonTCGLimiter = rate.NewLimiter(10, 1)
 	}
-	ssAPIClient.Transport = rateLimitedRoundTripper{
-		limiter: ssAPILimiter,
-		next:    origTransport,
+	pokemonTCGAPIClient := &http.Client{
+		Transport: rateLimitedRoundTripper{
+			name:    "pokemontcg",
+			limiter: pokemonTCGLimiter,
+			budget:  budget,
+		},
+		Timeout: requestTimeout,
 	}
 
-	// Now that we have an OAuth-authenticated HTTP client that is also rate-limited,
-	// we can use it to get a "sheets service" object.
-	s, err := sheets.NewService(ctx, option.WithHTTPClient(ssAPIClient))
+	games, err := parseGames(gameSpecs)
 	if err != nil {
-		return errors.Wrap(err, "creating sheets service")
+		return err
 	}
 
-	// Now we can use that to request the full contents of the desired sheet.
-	resp, err := s.Spreadsheets.Values.Get(sheetKey, sheetName+"!A-Z").Do()
+	ctx := context.Background()
+
+	// The base URL for contacting the scryfall API.
+	baseURL, err := url.Parse(scryfallAPIBase)
 	if err != nil {
-		return errors.Wrap(err, "reading spreadsheet data")
+		return fmt.Errorf("parsing base scryfall URL: %w", err)
 	}
-	if len(resp.Values) == 0 {
-		return fmt.Errorf("zero rows in spreadsheet")
+
+	var (
+		svc  sheetService
+		demo *memSheets // Non-nil with -demo.
+	)
+	if demoFile != "" {
+		// With -demo,
+		// the "spreadsheet" is in memory,
+		// and what majic would have written to it is printed at the end.
+		// With -demo sample,
+		// the cards are looked up in a fake scryfall too.
+		// See memsheets.go and fakescryfall.go.
+		if demoFile == "sample" {
+			demo = &memSheets{}
+			if err := demo.addCSV("Sample", bytes.NewReader(demoSample)); err != nil {
+				return fmt.Errorf("loading sample sheet: %w", err)
+			}
+			srv, err := newFakeScryfall(nil)
+			if err != nil {
+				return err
+			}
+			defer srv.Close()
+			baseURL, err = url.Parse(srv.URL)
+			if err != nil {
+				return fmt.Errorf("parsing fake scryfall URL: %w", err)
+			}
+			cacheTTL = 0
+		} else {
+			demo, err = loadMemSheets(demoFile)
+			if err != nil {
+				return err
+			}
+		}
+		svc = demo
+		historyPath = "none"
+		defer printDemoChanges(os.Stdout, demo)
+	} else {
+		svc, err = newGoogleSheets(ctx, auth, ssAPILimiter)
+		if err != nil {
+			return err
+		}
 	}
 
-	// We require row 0 to contain column headings.
-	// Let's read those column headings and map them to column numbers;
-	// e.g. "card name" -> 0, "set code" -> 1, etc.
-	columnHeadings := make(map[string]int) // maps lowercase heading to column number
-	for i, raw := range resp.Values[0] {
-		if heading, ok := raw.(string); ok {
-			heading = strings.ToLower(heading)
-			columnHeadings[heading] = i
+	// Scryfall responses may be cached on disk.
+	// See cache.go.
+	var cache *responseCache
+	if cacheTTL > 0 {
+		if cachePath == "" {
+			cachePath, err = cacheFile("responses.json")
+			if err != nil {
+				return fmt.Errorf("finding cache directory: %w", err)
+			}
+		}
+		cache, err = loadResponseCache(cachePath, cacheTTL)
+		if err != nil {
+			return fmt.Errorf("loading response cache: %w", err)
 		}
 	}
 
-	// Let's pull out the column numbers, by name,
-	// of the columns we'll care about when constructing scryfall-API queries.
-	cardNameCol, ok := columnHeadings["card name"]
-	if !ok {
-		return fmt.Errorf(`no "Card name" column`)
+	// With -bulk,
+	// cards are looked up in scryfall's bulk data first.
+	// See bulk.go.
+	var bulkIdx bulkIndex
+	if bulk {
+		bulkIdx, err = loadBulkIndex()
+		if err != nil {
+			return err
+		}
 	}
-	setCodeCol, ok := columnHeadings["set code"]
-	if !ok {
-		return fmt.Errorf(`no "Set code" column`)
+
+	// Each run is recorded in the history file.
+	// See history.go.
+	var (
+		hist *history
+		undo *undoLog
+	)
+	if historyPath != "none" {
+		if historyPath == "" {
+			historyPath, err = dataFile("history.json")
+			if err != nil {
+				return fmt.Errorf("finding data directory: %w", err)
+			}
+		}
+		hist, err = loadHistory(historyPath)
+		if err != nil {
+			return fmt.Errorf("loading history: %w", err)
+		}
+
+		// The undo log lives next to the history.
+		// See undo.go.
+		undo, err = loadUndoLog(filepath.Join(filepath.Dir(historyPath), "undo.json"))
+		if err != nil {
+			return fmt.Errorf("loading undo log: %w", err)
+		}
 	}
-	foilCol, ok := columnHeadings["foil"]
-	if !ok {
-		return fmt.Errorf(`no "Foil" column`)
+	if chartSheet != "" && hist == nil {
+		return fmt.Errorf("-chart-sheet needs the history (not -history none)")
 	}
-	lastUpdatedCol, ok := columnHeadings["last updated"]
-	if !ok {
-		return fmt.Errorf(`no "Last updated" column`)
+
+	r := &runner{
+		sheetKey:       sheetKey,
+		sheetSpec:      sheetName,
+		cfg:            cfg,
+		createColumns:  createColumns,
+		maxAge:         maxAge,
+		currencyFormat: currencyFormat,
+		priceFormat: priceFormat{
+			decimals:     priceDecimals,
+			stripZeros:   stripZeros,
+			decimalComma: decimalComma,
+			missing:      noPrice,
+		},
+		changeRules: changeRules,
+		metadataOpts: metadataOpts{
+			imageFormula: imageFormula,
+			linkFormula:  linkFormula,
+		},
+		force:          force,
+		canonicalNames: canonicalNames,
+		validateSets:   validateSets,
+		cheapest:       cheapest,
+		busyCell:       busyCell,
+		headerRow:      headerRow,
+		stopAtBlank:    stopAtBlank,
+		stalestFirst:   stalestFirst,
+		errorNotes:     errorNotes,
+		rangeSpec:      rangeSpec,
+		protect:        protect,
+		backups:        backups,
+		timeout:        timeout,
+		budget:         budget,
+
+		svc: svc,
+		scryfall: &scryfallClient{
+			client:  cardAPIClient,
+			baseURL: baseURL,
+			cache:   cache,
+			bulk:    bulkIdx,
+			offline: demoFile == "sample",
+		},
+		catalogs: map[string]cardCatalog{
+			pokemonGame: &pokemonTCGClient{
+				client:  pokemonTCGAPIClient,
+				baseURL: pokemonTCGAPIBase,
+				apiKey:  pokemonTCGKey,
+				cache:   cache,
+			},
+			yugiohGame: &ygoprodeckClient{
+				client:  ygoprodeckAPIClient,
+				baseURL: ygoprodeckAPIBase,
+				cache:   cache,
+			},
+		},
+		games: games,
+		buylist: &buylistClient{
+			// Only one request per run,
+			// at most,
+			// so this limiter is just for politeness.
+			client: &http.Client{
+				Transport: rateLimitedRoundTripper{
+					name:    "cardkingdom",
+					limiter: rate.NewLimiter(rate.Every(time.Second), 1),
+				},
+			},
+			url: cardKingdomPricelistURL,
+		},
+
+		progress: prog,
+		history:  hist,
+		undo:     undo,
+
+		chartSheet: chartSheet,
+
+		webhookSecret: webhookSecret,
+		rowRequests:   make(chan rowRequest, maxRowRequests),
+		reportFile:    reportFile,
 	}
-	priceCol, ok := columnHeadings["price"]
-	if !ok {
-		return fmt.Errorf(`no "Price" column`)
+
+	// Concurrent lookups of the same card
+	// (from a run and the server, say)
+	// share one API call.
+	// See coalesce.go.
+	r.catalogs[magicGame] = r.scryfall
+	for game, cat := range r.catalogs {
+		r.catalogs[game] = &coalescingCatalog{cardCatalog: cat}
 	}
 
-	// This is a value representing the moment in time one day earlier than right now.
-	// We'll use it in the loop below to skip rows that have been updated more recently.
-	// The scryfall API docs ask that we not query the price of the same card more than once per day.
-	oneDayAgo := time.Now().Add(-24 * time.Hour)
+	// Rows with a Product ID are sealed product,
+	// priced via the TCGplayer API.
+	// See sealed.go.
+	if tcgplayerKey != "" {
+		public, private, err := parseTCGplayerKey(tcgplayerKey)
+		if err != nil {
+			return err
+		}
+		r.sealed = &tcgplayerClient{
+			client: &http.Client{
+				Transport: rateLimitedRoundTripper{
+					name:    "tcgplayer",
+					limiter: rate.NewLimiter(10, 1),
+					budget:  budget,
+				},
+			},
+			baseURL:    tcgplayerAPIBase,
+			publicKey:  public,
+			privateKey: private,
+			cache:      cache,
+		}
+	}
 
-	// The base URL for contacting the scryfall Card API.
-	baseURL, err := url.Parse("https://api.scryfall.com/cards/named")
-	if err != nil {
-		return errors.Wrap(err, "parsing base scryfall URL")
+	// With -tui,
+	// the progress display (and log output) take over the terminal from here on.
+	// See tui.go.
+	if useTUI {
+		screen, restore, err := newTUI()
+		if err != nil {
+			return err
+		}
+		defer restore()
+		r.tui = screen
+		prog.tui = screen
+		prog.quiet = false
 	}
 
-	rh := rowHandler{
-		sheetKey: sheetKey,
-		rows:     resp.Values,
+	// From here on,
+	// SIGINT (Ctrl-C) and SIGTERM cancel ctx instead of killing the process,
+	// so we can stop cleanly between rows.
+	// A second signal gets the default behavior
+	// (killing the process at once).
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if alertThreshold != "" {
+		t, err := parseAlertThreshold(alertThreshold)
+		if err != nil {
+			return err
+		}
+		r.alertThreshold = &t
+
+		if len(alertSpecs) == 0 {
+			alertSpecs = []string{"stdout"}
+		}
+	}
 
-		cardNameCol:    cardNameCol,
-		setCodeCol:     setCodeCol,
-		foilCol:        foilCol,
-		lastUpdatedCol: lastUpdatedCol,
-		priceCol:       priceCol,
+	// Alerts for prices reaching the targets in a Watch column
+	// (see watch.go)
+	// go to the -alert sinks even without -alert-threshold.
+	for _, spec := range alertSpecs {
+		sink, err := parseAlertSink(spec, cfg, http.DefaultClient)
+		if err != nil {
+			return err
+		}
+		r.alertSinks = append(r.alertSinks, sink)
+	}
 
-		valuesSvc:     s.Spreadsheets.Values,
-		cardAPIClient: cardAPIClient,
+	if filter != "" {
+		r.filter, err = parseFilter(filter)
+		if err != nil {
+			return err
+		}
+	}
 
-		oneDayAgo: oneDayAgo,
-		baseURL:   baseURL,
+	if sortSpec != "" {
+		r.sortKeys, err = parseSort(sortSpec)
+		if err != nil {
+			return err
+		}
 	}
 
-	// Process remaining rows.
-	for rownum := 1; rownum < len(resp.Values); rownum++ {
-		err = rh.processRow(ctx, rownum)
+	if highlight != "" {
+		t, err := parseAlertThreshold(highlight)
 		if err != nil {
 			return err
 		}
+		r.highlightThreshold = &t
+	}
+
+	if queuePath != "" {
+		// See queue.go.
+		r.queue, err = loadRowQueue(queuePath)
+		if err != nil {
+			return fmt.Errorf("loading queue: %w", err)
+		}
+	}
+
+	if notifyWebhook != "" {
+		r.notifier = &notifier{
+			url:    notifyWebhook,
+			client: http.DefaultClient,
+			movers: notifyMovers,
+		}
+	}
+
+	if flag.NArg() > 0 {
+		// A subcommand, instead of the usual price update.
+		// See commands.go.
+		// Some of them look up cards
+		// (like "deck price" and "compare"),
+		// so save the responses for next time,
+		// as runOnce does.
+		err := subcmd.Run(ctx, r, flag.Args())
+		if err := cache.save(); err != nil {
+			slog.Warn("Could not save response cache", "err", err)
+		}
+		return err
+	}
+
+	if p, ok := detectServerless(); ok {
+		// Running as a cloud function.
+		// See serverless.go.
+		slog.Info("Running serverless", "platform", p.name)
+		return p.start(ctx, r)
+	}
+
+	if dashboardAddr != "" {
+		if !daemonMode {
+			return fmt.Errorf("-dashboard-addr requires -daemon")
+		}
+		// See dashboard.go.
+		r.runNow = make(chan struct{}, 1)
+		serveDashboard(dashboardAddr, r)
+	}
+
+	if metricsAddr != "" {
+		// See metrics.go.
+		serveMetrics(metricsAddr)
 	}
 
-	return nil
+	if daemonMode {
+		// Keep running until interrupted.
+		// See daemon.go.
+		return daemon(ctx, r, interval)
+	}
+
+	err = r.runOnce(ctx)
+	if ctx.Err() != nil {
+		updated, skipped, errored := r.progress.counts()
+		return fmt.Errorf("interrupted after %d row(s) updated, %d skipped, %d errors", updated, skipped, errored)
+	}
+	return err
 }
@@ -0,0 +1,74 @@
+// Package majicerr defines the kinds of failure that majic reports,
+// so that programs using its output or its API
+// can tell them apart
+// with errors.Is and errors.As
+// (or, over HTTP, with the codes from Code).
+package majicerr
+
+import (
+	"errors"
+	"fmt"
+)
+
+// These errors tell what kind of trouble there was looking up a card.
+// Majic wraps them with details,
+// so test for them with errors.Is.
+var (
+	// ErrCardNotFound is for a card that the card database doesn't know about.
+	ErrCardNotFound = errors.New("card not found")
+
+	// ErrAmbiguous is for a card name that matches more than one card.
+	ErrAmbiguous = errors.New("ambiguous card name")
+
+	// ErrRateLimited is for an API saying it's being called too often
+	// (HTTP status 429).
+	ErrRateLimited = errors.New("rate limited")
+
+	// ErrAuth is for an API rejecting majic's credentials
+	// (HTTP status 401 or 403).
+	ErrAuth = errors.New("not authorized")
+)
+
+// A SheetWriteError is an error writing new values to a sheet.
+// Test for it with errors.As.
+type SheetWriteError struct {
+	Sheet string // The name of the sheet.
+	Err   error  // The underlying error.
+}
+
+func (e SheetWriteError) Error() string {
+	return fmt.Sprintf("writing updates to sheet %q: %s", e.Sheet, e.Err)
+}
+
+func (e SheetWriteError) Unwrap() error { return e.Err }
+
+// These are the codes that Code returns.
+const (
+	CodeNotFound     = "not_found"
+	CodeAmbiguous    = "ambiguous"
+	CodeRateLimited  = "rate_limited"
+	CodeUnauthorized = "unauthorized"
+	CodeSheetWrite   = "sheet_write"
+)
+
+// Code returns a short, stable string for the kind of err,
+// for reporting in places where Go errors can't go,
+// like the JSON responses of majic's serve mode.
+// It's one of the Code constants,
+// or "" if err is nil or isn't any of the kinds in this package.
+func Code(err error) string {
+	var swerr SheetWriteError
+	switch {
+	case errors.Is(err, ErrCardNotFound):
+		return CodeNotFound
+	case errors.Is(err, ErrAmbiguous):
+		return CodeAmbiguous
+	case errors.Is(err, ErrRateLimited):
+		return CodeRateLimited
+	case errors.Is(err, ErrAuth):
+		return CodeUnauthorized
+	case errors.As(err, &swerr):
+		return CodeSheetWrite
+	}
+	return ""
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bobg/majic/a1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/sheets/v4"
+)
+
+// memSheets is a sheetService that keeps a spreadsheet in memory,
+// for trying majic without Google credentials
+// (see the -demo flag)
+// and for tests.
+// Its sheets can be loaded from CSV files,
+// and every cell it changes is recorded
+// for inspection afterward.
+//
+// It understands the value requests majic makes
+// and enough of the batchUpdate requests
+// (adding, copying, and deleting sheets, deleting rows)
+// to keep the data right.
+// Formatting requests are accepted and ignored.
+type memSheets struct {
+	mu      sync.Mutex
+	sheets  []*memSheet
+	changes []memChange
+}
+
+type memSheet struct {
+	id    int64
+	title string
+	rows  [][]any
+}
+
+// A memChange is a change to one cell of a memSheets.
+// Row and col are zero-based.
+type memChange struct {
+	sheet    string
+	row, col int
+	old, new any
+}
+
+func (c memChange) String() string {
+	return fmt.Sprintf("%s: %v → %v", a1.Cell(c.sheet, c.row, c.col), blankIfNil(c.old), blankIfNil(c.new))
+}
+
+func blankIfNil(v any) any {
+	if v == nil {
+		return `""`
+	}
+	return v
+}
+
+// demoSample is the sheet for -demo sample.
+// Its cards are in fakeScryfallFixtures,
+// except for one that's deliberately missing.
+//
+//go:embed fixtures/sample.csv
+var demoSample []byte
+
+// printDemoChanges lists the cells that majic changed in m
+// (for -demo).
+func printDemoChanges(w io.Writer, m *memSheets) {
+	changes := m.changed()
+	if len(changes) == 0 {
+		fmt.Fprintln(w, "Demo: no cells would change.")
+		return
+	}
+	fmt.Fprintf(w, "Demo: %d cell(s) would change:\n", len(changes))
+	for _, c := range changes {
+		fmt.Fprintf(w, "  %s\n", c)
+	}
+}
+
+// loadMemSheets creates a memSheets with a sheet for each of the given CSV files,
+// named for the file without its extension.
+func loadMemSheets(filenames ...string) (*memSheets, error) {
+	m := &memSheets{}
+	for _, filename := range filenames {
+		f, err := os.Open(filename)
+		if err != nil {
+			return nil, fmt.Errorf("opening %s: %w", filename, err)
+		}
+		title := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+		err = m.addCSV(title, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", filename, err)
+		}
+	}
+	return m, nil
+}
+
+// addCSV adds a sheet with the given title and the contents of a CSV file.
+func (m *memSheets) addCSV(title string, r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	records, err := cr.ReadAll()
+	if err != nil {
+		return err
+	}
+	rows := make([][]any, len(records))
+	for i, rec := range records {
+		rows[i] = make([]any, len(rec))
+		for j, val := range rec {
+			rows[i][j] = val
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.addSheet(title, rows)
+	return nil
+}
+
+// addSheet adds a sheet.
+// The caller must hold m.mu.
+func (m *memSheets) addSheet(title string, rows [][]any) *memSheet {
+	var id int64
+	for _, sh := range m.sheets {
+		id = max(id, sh.id+1)
+	}
+	sh := &memSheet{id: id, title: title, rows: rows}
+	m.sheets = append(m.sheets, sh)
+	return sh
+}
+
+// changed returns the cells changed so far, in order.
+func (m *memSheets) changed() []memChange {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]memChange(nil), m.changes...)
+}
+
+// sheetAndRange parses an A1-notation range like "'My sheet'!A2:C5"
+// and finds its sheet
+// (the first one if the range has no sheet name).
+// The caller must hold m.mu.
+func (m *memSheets) sheetAndRange(rangeName string) (*memSheet, a1.Range, error) {
+	rng, err := a1.Parse(rangeName)
+	if err != nil {
+		return nil, a1.Range{}, err
+	}
+	for _, sh := range m.sheets {
+		if rng.Sheet == "" || sh.title == rng.Sheet {
+			return sh, rng, nil
+		}
+	}
+	return nil, a1.Range{}, fmt.Errorf("no sheet named %q", rng.Sheet)
+}
+
+// set sets a cell, growing the sheet as needed,
+// and records the change.
+// The caller must hold m.mu.
+func (m *memSheets) set(sh *memSheet, row, col int, val any) {
+	for len(sh.rows) <= row {
+		sh.rows = append(sh.rows, nil)
+	}
+	for len(sh.rows[row]) <= col {
+		sh.rows[row] = append(sh.rows[row], "")
+	}
+	old := sh.rows[row][col]
+	if fmt.Sprint(old) == fmt.Sprint(val) {
+		return
+	}
+	sh.rows[row][col] = val
+	m.changes = append(m.changes, memChange{sheet: sh.title, row: row, col: col, old: old, new: val})
+}
+
+// write writes values into a range,
+// starting at its top left corner.
+// The caller must hold m.mu.
+func (m *memSheets) write(rangeName string, values [][]any) error {
+	sh, rng, err := m.sheetAndRange(rangeName)
+	if err != nil {
+		return err
+	}
+	for i, row := range values {
+		for j, val := range row {
+			m.set(sh, max(rng.StartRow, 0)+i, max(rng.StartCol, 0)+j, val)
+		}
+	}
+	return nil
+}
+
+func (m *memSheets) get(_ context.Context, _ string, _ ...googleapi.Field) (*sheets.Spreadsheet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ss := &sheets.Spreadsheet{}
+	for _, sh := range m.sheets {
+		ss.Sheets = append(ss.Sheets, &sheets.Sheet{
+			Properties: &sheets.SheetProperties{SheetId: sh.id, Title: sh.title},
+		})
+	}
+	return ss, nil
+}
+
+func (m *memSheets) batchUpdate(_ context.Context, _ string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resp := &sheets.BatchUpdateSpreadsheetResponse{}
+	for i, r := range req.Requests {
+		reply := &sheets.Response{}
+		switch {
+		case r.AddSheet != nil:
+			sh := m.addSheet(r.AddSheet.Properties.Title, nil)
+			reply.AddSheet = &sheets.AddSheetResponse{
+				Properties: &sheets.SheetProperties{SheetId: sh.id, Title: sh.title},
+			}
+
+		case r.DuplicateSheet != nil:
+			for _, src := range m.sheets {
+				if src.id != r.DuplicateSheet.SourceSheetId {
+					continue
+				}
+				var rows [][]any
+				for _, row := range src.rows {
+					rows = append(rows, append([]any(nil), row...))
+				}
+				sh := m.addSheet(r.DuplicateSheet.NewSheetName, rows)
+				reply.DuplicateSheet = &sheets.DuplicateSheetResponse{
+					Properties: &sheets.SheetProperties{SheetId: sh.id, Title: sh.title},
+				}
+				break
+			}
+
+		case r.DeleteSheet != nil:
+			m.sheets = slices.DeleteFunc(m.sheets, func(sh *memSheet) bool { return sh.id == r.DeleteSheet.SheetId })
+
+		case r.AddProtectedRange != nil:
+			reply.AddProtectedRange = &sheets.AddProtectedRangeResponse{
+				ProtectedRange: &sheets.ProtectedRange{ProtectedRangeId: int64(i + 1)},
+			}
+
+		case r.DeleteDimension != nil && r.DeleteDimension.Range.Dimension == "ROWS":
+			dr := r.DeleteDimension.Range
+			for _, sh := range m.sheets {
+				if sh.id != dr.SheetId {
+					continue
+				}
+				start, end := int(dr.StartIndex), min(int(dr.EndIndex), len(sh.rows))
+				if start < end {
+					sh.rows = append(sh.rows[:start], sh.rows[end:]...)
+				}
+			}
+		}
+		resp.Replies = append(resp.Replies, reply)
+	}
+	return resp, nil
+}
+
+// getValues returns the whole of the sheet named in rangeName
+// (regardless of the rest of the range).
+// Since memSheets doesn't evaluate formulas,
+// every renderOption gives the same result.
+func (m *memSheets) getValues(_ context.Context, _, rangeName, _ string) (*sheets.ValueRange, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sh, _, err := m.sheetAndRange(rangeName)
+	if err != nil {
+		return nil, err
+	}
+	vr := &sheets.ValueRange{Range: rangeName}
+	for _, row := range sh.rows {
+		vr.Values = append(vr.Values, append([]any(nil), row...))
+	}
+	return vr, nil
+}
+
+func (m *memSheets) updateValues(_ context.Context, _, rangeName string, vr *sheets.ValueRange, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write(rangeName, vr.Values)
+}
+
+func (m *memSheets) batchUpdateValues(_ context.Context, _ string, req *sheets.BatchUpdateValuesRequest) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, vr := range req.Data {
+		if err := m.write(vr.Range, vr.Values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendValues adds the rows at the end of the sheet.
+func (m *memSheets) appendValues(_ context.Context, _, rangeName string, vr *sheets.ValueRange, _, _ string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sh, _, err := m.sheetAndRange(rangeName)
+	if err != nil {
+		return err
+	}
+	start := len(sh.rows)
+	for i, row := range vr.Values {
+		for j, val := range row {
+			m.set(sh, start+i, j, val)
+		}
+	}
+	return nil
+}
+
+func (m *memSheets) clearValues(_ context.Context, _, rangeName string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sh, rng, err := m.sheetAndRange(rangeName)
+	if err != nil {
+		return err
+	}
+	for i, row := range sh.rows {
+		if !rng.HasRow(i) {
+			continue
+		}
+		for j := range row {
+			if rng.HasCol(j) {
+				m.set(sh, i, j, "")
+			}
+		}
+	}
+	return nil
+}
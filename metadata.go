@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A metadataField is an optional column filled in with information about a card
+// taken from the same scryfall response that supplies its price.
+// If the sheet has a column whose heading matches the field name
+// (or whatever heading the config maps it to),
+// that column is filled in for each updated row.
+type metadataField struct {
+	name  string
+	value func(*respObj) any
+}
+
+// metadataOpts controls how some metadata fields are written.
+type metadataOpts struct {
+	imageFormula bool // Write the Image column as an =IMAGE formula instead of a plain URL.
+	linkFormula  bool // Write the Link column as a =HYPERLINK formula, with the card name as the link text.
+}
+
+// metadataFields lists the known metadata fields.
+func metadataFields(opts metadataOpts) []metadataField {
+	result := []metadataField{
+		{name: "rarity", value: func(c *respObj) any { return c.Rarity }},
+		{name: collectorNumberField, value: func(c *respObj) any { return c.CollectorNumber }}, // Only where it is blank; see processRow.
+		{name: "color", value: func(c *respObj) any { return colorString(c.Colors) }},
+		{name: "color identity", value: func(c *respObj) any { return colorString(c.ColorIdentity) }},
+		{name: "mana value", value: func(c *respObj) any { return c.CMC }},
+		{name: "type line", value: func(c *respObj) any { return c.TypeLine }},
+		{name: "image", value: func(c *respObj) any {
+			u := c.imageURI()
+			if u == "" || !opts.imageFormula {
+				return u
+			}
+			return formula(fmt.Sprintf("=IMAGE(%s)", formulaString(u)))
+		}},
+		{name: "link", value: func(c *respObj) any {
+			if c.ScryfallURI == "" || !opts.linkFormula {
+				return c.ScryfallURI
+			}
+			return formula(fmt.Sprintf("=HYPERLINK(%s, %s)", formulaString(c.ScryfallURI), formulaString(c.Name)))
+		}},
+		{name: "printed name", value: func(c *respObj) any {
+			// This is the card's name in the language of the printing
+			// (see the Language column in row.go),
+			// which for English is just the name.
+			if s := c.faceText(c.PrintedName, func(f faceObj) string { return f.PrintedName }); s != "" {
+				return s
+			}
+			return c.Name
+		}},
+		{name: "oracle text", value: func(c *respObj) any {
+			return c.faceText(c.OracleText, func(f faceObj) string { return f.OracleText })
+		}},
+		{name: "power", value: func(c *respObj) any {
+			return c.faceText(c.Power, func(f faceObj) string { return f.Power })
+		}},
+		{name: "toughness", value: func(c *respObj) any {
+			return c.faceText(c.Toughness, func(f faceObj) string { return f.Toughness })
+		}},
+		{name: "loyalty", value: func(c *respObj) any {
+			return c.faceText(c.Loyalty, func(f faceObj) string { return f.Loyalty })
+		}},
+		{name: "reserved list", value: func(c *respObj) any { return c.Reserved }},
+	}
+
+	// There's one column for each format's legality,
+	// with the format name as the heading.
+	for _, format := range legalityFormats {
+		format := format
+		result = append(result, metadataField{
+			name:  format,
+			value: func(c *respObj) any { return legalityString(c.Legalities[format]) },
+		})
+	}
+
+	return result
+}
+
+// legalityFormats are the formats in the "legalities" object of a scryfall card.
+// See https://scryfall.com/docs/api/cards.
+var legalityFormats = []string{
+	"standard",
+	"future",
+	"historic",
+	"timeless",
+	"gladiator",
+	"pioneer",
+	"explorer",
+	"modern",
+	"legacy",
+	"pauper",
+	"vintage",
+	"penny",
+	"commander",
+	"oathbreaker",
+	"standardbrawl",
+	"brawl",
+	"alchemy",
+	"paupercommander",
+	"duel",
+	"oldschool",
+	"premodern",
+	"predh",
+}
+
+// legalityString turns a scryfall legality
+// ("legal," "not_legal," "restricted," or "banned")
+// into something more readable.
+func legalityString(s string) string {
+	switch s {
+	case "legal":
+		return "Legal"
+	case "not_legal":
+		return "Not legal"
+	case "restricted":
+		return "Restricted"
+	case "banned":
+		return "Banned"
+	}
+	return s
+}
+
+// imageURI returns the URI of the card's image
+// (the "normal" size; see https://scryfall.com/docs/api/images).
+// Cards with two faces have no top-level image,
+// in which case the front face's image is used.
+func (c *respObj) imageURI() string {
+	if u := c.ImageURIs.Normal; u != "" {
+		return u
+	}
+	if len(c.CardFaces) > 0 {
+		return c.CardFaces[0].ImageURIs.Normal
+	}
+	return ""
+}
+
+// faceText returns top if it's non-empty.
+// Otherwise,
+// for a card with more than one face
+// (where scryfall puts things like oracle text in the faces instead of at the top level),
+// it returns the value of get for each face,
+// separated by " // " as in the card's name.
+// Faces with no value are shown as empty
+// (e.g. a creature with a noncreature back face has power only on the front).
+func (c *respObj) faceText(top string, get func(faceObj) string) string {
+	if top != "" || len(c.CardFaces) == 0 {
+		return top
+	}
+	var (
+		parts []string
+		found bool
+	)
+	for _, f := range c.CardFaces {
+		v := get(f)
+		parts = append(parts, v)
+		if v != "" {
+			found = true
+		}
+	}
+	if !found {
+		return ""
+	}
+	return strings.Join(parts, " // ")
+}
+
+// A formula is a cell value to be written as a spreadsheet formula,
+// rather than as literal text.
+type formula string
+
+// formulaString quotes s as a string literal for use in a spreadsheet formula.
+func formulaString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// colorString turns a list of scryfall color letters into a single string,
+// like "WU" for a white-blue card.
+// A colorless card is "C".
+func colorString(colors []string) string {
+	if len(colors) == 0 {
+		return "C"
+	}
+	return strings.Join(colors, "")
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// These are the Prometheus metrics that majic maintains.
+// They are served at /metrics on the address given by -metrics-addr
+// (which is mainly useful together with -daemon).
+var (
+	apiCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "majic",
+		Name:      "api_calls_total",
+		Help:      "Calls to external APIs, by API and HTTP status code (or \"error\" if there was no response).",
+	}, []string{"api", "code"})
+
+	apiLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "majic",
+		Name:      "api_call_duration_seconds",
+		Help:      "Latency of calls to external APIs, not counting time spent waiting on rate limiters.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"api"})
+
+	limiterWaitSeconds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "majic",
+		Name:      "limiter_wait_seconds_total",
+		Help:      "Time spent waiting on the rate limiter for each external API.",
+	}, []string{"api"})
+
+	sheetWrites = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: "majic",
+		Name:      "sheet_writes_total",
+		Help:      "Cell values written to spreadsheets.",
+	})
+
+	rowsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "majic",
+		Name:      "rows_total",
+		Help:      "Spreadsheet rows processed, by outcome (updated, skipped, or error).",
+	}, []string{"outcome"})
+
+	runsCompleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "majic",
+		Name:      "runs_total",
+		Help:      "Pricing runs, by result (ok or error).",
+	}, []string{"result"})
+)
+
+// serveMetrics serves the Prometheus metrics at /metrics on the given address.
+// It runs in the background;
+// if the server fails, the error is logged.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	go func() {
+		slog.Info("Serving metrics", "addr", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Metrics server failed", "err", err)
+		}
+	}()
+}
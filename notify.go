@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A notifier posts a summary of each run to a Discord or Slack webhook
+// (given with the -notify-webhook flag).
+type notifier struct {
+	url    string
+	client *http.Client
+
+	// movers is how many of the biggest price changes to list in the summary.
+	// Zero means none.
+	movers int
+}
+
+// A runSummary is what a notifier reports.
+type runSummary struct {
+	updated, skipped, errored int
+
+	// valueBefore and valueAfter are the total value of the priced cards
+	// before and after the run.
+	valueBefore, valueAfter float64
+
+	changes []priceChange
+}
+
+// text renders the summary as a chat message.
+func (n notifier) text(s runSummary) string {
+	buf := new(strings.Builder)
+	fmt.Fprintf(buf, "Majic run complete: %d updated, %d skipped, %d errors.\n", s.updated, s.skipped, s.errored)
+	fmt.Fprintf(buf, "Collection value: $%.2f (%+.2f)\n", s.valueAfter, s.valueAfter-s.valueBefore)
+
+	var watched []priceChange
+	for _, c := range s.changes {
+		if c.Watch != "" {
+			watched = append(watched, c)
+		}
+	}
+	if len(watched) > 0 {
+		fmt.Fprintln(buf, "Watch targets reached:")
+		for _, c := range watched {
+			fmt.Fprintf(buf, "- %s\n", c)
+		}
+	}
+
+	if n.movers > 0 && len(s.changes) > 0 {
+		changes := make([]priceChange, len(s.changes))
+		copy(changes, s.changes)
+		sort.SliceStable(changes, func(i, j int) bool {
+			return math.Abs(changes[i].diff()) > math.Abs(changes[j].diff())
+		})
+		if len(changes) > n.movers {
+			changes = changes[:n.movers]
+		}
+		fmt.Fprintln(buf, "Biggest movers:")
+		for _, c := range changes {
+			fmt.Fprintf(buf, "- %s\n", c)
+		}
+	}
+
+	return buf.String()
+}
+
+// notify posts the summary to the webhook.
+// Discord and Slack webhooks take slightly different JSON payloads,
+// so the payload is chosen based on the webhook URL.
+func (n notifier) notify(ctx context.Context, s runSummary) error {
+	u, err := url.Parse(n.url)
+	if err != nil {
+		return fmt.Errorf("parsing webhook URL %s: %w", n.url, err)
+	}
+
+	var (
+		text    = n.text(s)
+		payload map[string]string
+	)
+	if strings.HasSuffix(u.Hostname(), "discord.com") || strings.HasSuffix(u.Hostname(), "discordapp.com") {
+		payload = map[string]string{"content": text}
+	} else {
+		// Slack's format, which many other chat services also accept.
+		payload = map[string]string{"text": text}
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("encoding notification: %w", err)
+	}
+	return postJSON(ctx, n.client, n.url, body)
+}
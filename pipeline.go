@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The rows of a sheet go through a pipeline of stages,
+// each in its own goroutine,
+// connected by channels with small, bounded buffers:
+//
+//   - select: decides which rows to process, in what order
+//     (see rowOrder and rowQueue),
+//     honoring the -tui pause and skip keys;
+//   - lookup: looks up each row's card and price
+//     and computes the row's new values
+//     (see rowHandler.processRow),
+//     spending from the -max-lookups budget;
+//   - record: counts and reports the outcome of each row
+//     (this one is processSheet's own loop);
+//   - write: writes the new values to the sheet,
+//     all at once at the end,
+//     or in batches as it goes with -queue
+//     (see rowWriter).
+//
+// Because the buffers are bounded,
+// a slow stage holds up the ones before it
+// instead of letting work pile up in memory.
+// The stages that call APIs are rate-limited by those APIs' limiters
+// (the card databases' for lookup, the spreadsheet's for write),
+// independently of one another.
+// How long each stage spends on each row,
+// and how many rows wait in front of it,
+// are reported as Prometheus metrics
+// (see metrics.go).
+
+// pipelineBuffer is the size of the buffer in front of the record stage.
+// (The select stage runs just one row ahead,
+// so pausing with -tui takes effect right away.)
+const pipelineBuffer = 10
+
+var (
+	stageSeconds = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "majic",
+		Name:      "stage_duration_seconds",
+		Help:      "Time spent on each row (or, for write, each batch) by each stage of the row pipeline.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"stage"})
+
+	stageBacklog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "majic",
+		Name:      "stage_backlog",
+		Help:      "Rows waiting for each stage of the row pipeline.",
+	}, []string{"stage"})
+)
+
+// observeStage records the time a stage has spent on a row since start.
+func observeStage(stage string, start time.Time) {
+	stageSeconds.WithLabelValues(stage).Observe(time.Since(start).Seconds())
+}
+
+// A rowPipeline is the select and lookup stages for a sheet.
+// Its results channel,
+// for the record stage,
+// is closed when the stages are done.
+type rowPipeline struct {
+	results <-chan lookedUpRow
+	stop    context.CancelFunc
+	wg      sync.WaitGroup
+
+	// Whether lookup stopped at the -max-lookups limit.
+	// Read it only after results is closed.
+	budgetStopped bool
+}
+
+// A lookedUpRow is a row's result from the lookup stage.
+type lookedUpRow struct {
+	rownum int
+	res    rowResult
+	err    error
+}
+
+// startPipeline starts the select and lookup stages
+// for the given rows of the sheet handled by rh,
+// in the given order.
+// The stages stop early when ctx is canceled,
+// or when the caller calls stop.
+func (r *runner) startPipeline(ctx context.Context, rh rowHandler, rownums []int) *rowPipeline {
+	ctx, cancel := context.WithCancel(ctx)
+
+	var (
+		selected = make(chan int, 1)
+		results  = make(chan lookedUpRow, pipelineBuffer)
+		p        = &rowPipeline{results: results, stop: cancel}
+	)
+
+	p.wg.Add(2)
+
+	// Select.
+	go func() {
+		defer p.wg.Done()
+		defer close(selected)
+
+		for _, rownum := range rownums {
+			if ctx.Err() != nil {
+				return
+			}
+			if r.only != nil && !r.only[rh.sheetName][rownum] {
+				continue
+			}
+			if r.tui != nil {
+				// This waits while the run is paused.
+				skip, err := r.tui.next(ctx)
+				if err != nil {
+					return
+				}
+				if skip {
+					r.tui.skipped(rh.sheetName, rownum)
+					r.progress.rowSkipped()
+					continue
+				}
+			}
+			select {
+			case selected <- rownum:
+				stageBacklog.WithLabelValues("lookup").Set(float64(len(selected)))
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Lookup.
+	go func() {
+		defer p.wg.Done()
+		defer close(results)
+
+		for rownum := range selected {
+			if r.budget.exhausted() {
+				// See budget.go.
+				p.budgetStopped = true
+				cancel() // Stops the select stage.
+				break
+			}
+			start := time.Now()
+			res, err := rh.processRow(ctx, rownum)
+			observeStage("lookup", start)
+			if err != nil && ctx.Err() != nil {
+				// The row failed because the pipeline was stopped
+				// (or the run was interrupted or timed out),
+				// not because of anything wrong with the row.
+				break
+			}
+			select {
+			case results <- lookedUpRow{rownum: rownum, res: res, err: err}:
+				stageBacklog.WithLabelValues("record").Set(float64(len(results)))
+			case <-ctx.Done():
+				return
+			}
+		}
+
+		// Let the select stage finish.
+		for range selected {
+		}
+	}()
+
+	return p
+}
+
+// finish stops the stages,
+// if they're still going,
+// and waits for them to exit.
+func (p *rowPipeline) finish() {
+	p.stop()
+	for range p.results {
+	}
+	p.wg.Wait()
+}
+
+// A rowWriter is the write stage of the pipeline.
+// It receives each finished row's new values from the record stage
+// and writes them to the sheet:
+// all at once when it's closed,
+// or, with a rowQueue,
+// in batches as it goes
+// (see queueBatchRows and queueFlushInterval),
+// crossing the rows off in the queue as they're written.
+type rowWriter struct {
+	ch   chan writtenRow
+	done chan error
+}
+
+// A writtenRow is a row's new cell values (if any),
+// for the write stage.
+type writtenRow struct {
+	rownum   int
+	updates  []cellUpdate
+	finished bool // Whether the row is done, as far as the queue is concerned.
+}
+
+// startRowWriter starts the write stage for the named sheet,
+// whose contents as read at the start of processing are orig.
+func (r *runner) startRowWriter(ctx context.Context, sheetName string, orig [][]any) *rowWriter {
+	w := &rowWriter{
+		ch:   make(chan writtenRow, queueBatchRows),
+		done: make(chan error, 1),
+	}
+
+	// Writes happen even if ctx is canceled,
+	// so the work done so far isn't lost.
+	ctx = context.WithoutCancel(ctx)
+
+	go func() {
+		// Without a queue,
+		// there are no batches,
+		// and no ticks.
+		var tick <-chan time.Time
+		if r.queue != nil {
+			ticker := time.NewTicker(queueFlushInterval)
+			defer ticker.Stop()
+			tick = ticker.C
+		}
+
+		var (
+			firstErr error
+			finished []int
+			updates  []cellUpdate
+		)
+
+		flush := func() {
+			if firstErr != nil {
+				return
+			}
+			start := time.Now()
+			if err := r.writeUpdates(ctx, sheetName, orig, updates); err != nil {
+				// Stop writing,
+				// but keep receiving,
+				// so the record stage isn't blocked.
+				firstErr = err
+				return
+			}
+			if len(updates) > 0 {
+				observeStage("write", start)
+			}
+			if len(finished) > 0 {
+				if err := r.queue.done(sheetName, finished); err != nil {
+					firstErr = err
+					return
+				}
+			}
+			finished, updates = nil, nil
+		}
+
+		for {
+			select {
+			case row, ok := <-w.ch:
+				if !ok {
+					flush()
+					w.done <- firstErr
+					return
+				}
+				stageBacklog.WithLabelValues("write").Set(float64(len(w.ch)))
+				if row.finished {
+					finished = append(finished, row.rownum)
+				}
+				updates = append(updates, row.updates...)
+				if r.queue != nil && len(finished) >= queueBatchRows {
+					flush()
+				}
+
+			case <-tick:
+				flush()
+			}
+		}
+	}()
+
+	return w
+}
+
+// add hands a row's new values to the write stage.
+func (w *rowWriter) add(rownum int, updates []cellUpdate, finished bool) {
+	w.ch <- writtenRow{rownum: rownum, updates: updates, finished: finished}
+}
+
+// close writes any remaining rows
+// and stops the write stage,
+// returning the first error it encountered.
+func (w *rowWriter) close() error {
+	close(w.ch)
+	return <-w.done
+}
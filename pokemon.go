@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// pokemonGame is the -game name for the Pokémon TCG.
+const pokemonGame = "pokemon"
+
+const pokemonTCGAPIBase = "https://api.pokemontcg.io/v2"
+
+// A pokemonTCGClient is the cardCatalog for the Pokémon TCG,
+// using the pokemontcg.io API
+// (documented at https://docs.pokemontcg.io/).
+//
+// The Set code column may contain either the set's pokemontcg.io ID
+// (like "base1" or "sv3pt5")
+// or its PTCGO code
+// (like "BS" or "MEW").
+// The Collector number column,
+// if there is one,
+// picks out a single card when a set has several with the same name.
+//
+// Prices are TCGplayer market prices.
+// Foil means reverse holo,
+// or holofoil for cards that don't come any other way;
+// nonfoil means the normal version,
+// or again holofoil for cards that only come that way.
+type pokemonTCGClient struct {
+	client  *http.Client // Rate-limited; see main.go.
+	baseURL string       // Normally pokemonTCGAPIBase.
+	apiKey  string       // Optional, but raises the rate limit.
+	cache   *responseCache
+}
+
+// pokemonCard is the part of a pokemontcg.io card object that majic uses.
+type pokemonCard struct {
+	Name   string `json:"name"`
+	Number string `json:"number"`
+	Rarity string `json:"rarity"`
+	Set    struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"set"`
+	Images struct {
+		Large string `json:"large"`
+	} `json:"images"`
+	TCGPlayer struct {
+		URL    string `json:"url"`
+		Prices map[string]struct {
+			Market *float64 `json:"market"`
+		} `json:"prices"` // Keyed by finish, like "normal" or "holofoil."
+	} `json:"tcgplayer"`
+}
+
+// price returns the card's market price in the given finish,
+// or "" if it's unknown.
+func (c pokemonCard) price(foil bool) string {
+	finishes := []string{"normal", "holofoil", "1stEditionNormal", "unlimitedHolofoil", "1stEditionHolofoil"}
+	if foil {
+		finishes = []string{"reverseHolofoil", "holofoil", "unlimitedHolofoil", "1stEditionHolofoil"}
+	}
+	for _, f := range finishes {
+		if p, ok := c.TCGPlayer.Prices[f]; ok && p.Market != nil {
+			return fmt.Sprintf("%.2f", *p.Market)
+		}
+	}
+	return ""
+}
+
+func (pc *pokemonTCGClient) card(ctx context.Context, name, setCode, number, _ string, foil, cheapest bool) (*respObj, error) {
+	var mode string
+	if cheapest {
+		mode = fmt.Sprintf("cheapest,foil=%v", foil)
+	}
+	key := cacheKey(pokemonGame, name, setCode, number, fmt.Sprintf("foil=%v", foil), mode)
+	if obj := pc.cache.get(key); obj != nil {
+		slog.Debug("Using cached pokemontcg.io response", "card", name, "set", setCode)
+		return obj, nil
+	}
+
+	// See https://docs.pokemontcg.io/api-reference/cards/search-cards
+	// for the query syntax.
+	q := fmt.Sprintf("name:%q", name)
+	if setCode != "" {
+		q += fmt.Sprintf(" (set.id:%q OR set.ptcgoCode:%q)", setCode, setCode)
+	}
+	if number != "" {
+		q += fmt.Sprintf(" number:%q", number)
+	}
+	v := url.Values{
+		"q":       {q},
+		"orderBy": {"-set.releaseDate"},
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", pc.baseURL+"/cards?"+v.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating pokemontcg.io request: %w", err)
+	}
+	if pc.apiKey != "" {
+		req.Header.Set("X-Api-Key", pc.apiKey)
+	}
+	resp, err := pc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying pokemontcg.io API: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiStatusError("pokemontcg.io", resp.StatusCode, "")
+	}
+
+	var list struct {
+		Data []pokemonCard `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("JSON-decoding pokemontcg.io response: %w", err)
+	}
+
+	// The search is fuzzier than wanted
+	// (e.g. "name:Pikachu" also finds "Pikachu V"),
+	// so keep only exact matches.
+	var cards []pokemonCard
+	for _, c := range list.Data {
+		if strings.EqualFold(c.Name, name) {
+			cards = append(cards, c)
+		}
+	}
+	if len(cards) == 0 {
+		return nil, fmt.Errorf("no card named %q: %w", name, majicerr.ErrCardNotFound)
+	}
+
+	// The most recent printing,
+	// or with cheapest,
+	// the one with the lowest price.
+	best := cards[0]
+	if cheapest {
+		bestPrice, haveBest := parsePrice(best.price(foil))
+		for _, c := range cards[1:] {
+			if p, ok := parsePrice(c.price(foil)); ok && (!haveBest || p < bestPrice) {
+				best, bestPrice, haveBest = c, p, true
+			}
+		}
+	}
+
+	price := best.price(foil)
+	obj := &respObj{
+		Name:            best.Name,
+		Set:             best.Set.ID,
+		SetName:         best.Set.Name,
+		CollectorNumber: best.Number,
+		Rarity:          best.Rarity,
+		ImageURIs:       imageURIs{Normal: best.Images.Large},
+		ScryfallURI:     best.TCGPlayer.URL, // For the Link column.
+		Prices:          pricesObj{USD: price, USDFoil: price},
+		PriceSource:     sourcePokemonTCG,
+		PricedAt:        time.Now(),
+	}
+
+	pc.cache.put(key, obj)
+	return obj, nil
+}
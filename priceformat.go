@@ -0,0 +1,112 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A priceFormat controls how prices are written to the sheet.
+//
+// Normally a price is written as a number,
+// just as the card database reports it,
+// and how it looks is up to the cell's number format
+// (see -currency-format)
+// and the spreadsheet's locale.
+// That's usually best,
+// since formulas like SUM work on numbers.
+// But some sheets are shared with other tools,
+// or read by people,
+// who want prices as text in a particular form,
+// so stripZeros and decimalComma write them that way.
+type priceFormat struct {
+	// If non-negative,
+	// prices are rounded to this many decimal places
+	// (2 for cents).
+	decimals int
+
+	// Whether to write prices as text
+	// with no trailing zeros after the decimal point,
+	// as in "1.5" and "3" instead of "1.50" and "3.00."
+	stripZeros bool
+
+	// Whether to write prices as text
+	// with a comma before the decimals,
+	// as in "1,50,"
+	// the way many European locales do.
+	// (parsePrice can read these back.)
+	decimalComma bool
+
+	// What to write when there is no price for a card
+	// (because the card database has no market data for it),
+	// e.g. "N/A" (the default) or "0."
+	// If it's a number, it's written as one.
+	// If it's empty, the cell is left blank,
+	// which looks the same as a row that hasn't been priced yet.
+	missing string
+}
+
+// isMissing tells whether s,
+// from a price cell,
+// is the marker for a card with no price.
+// Such cells don't count toward totals.
+// (When the marker is a number, like 0,
+// a real price equal to it looks the same.)
+func (pf priceFormat) isMissing(s string) bool {
+	if pf.missing == "" {
+		return false
+	}
+	s = strings.TrimSpace(s)
+	if s == pf.missing {
+		return true
+	}
+	m, ok1 := parsePrice(pf.missing)
+	f, ok2 := parsePrice(s)
+	return ok1 && ok2 && f == m
+}
+
+// value returns the cell value for the given price,
+// as reported by the card database
+// (or computed from one).
+// An empty price means there isn't one.
+func (pf priceFormat) value(price string) any {
+	f, ok := parsePrice(price)
+	if !ok {
+		if price != "" {
+			// Not something we know how to format.
+			return price
+		}
+		if m, ok := parsePrice(pf.missing); ok {
+			return m
+		}
+		return pf.missing
+	}
+
+	if pf.decimals >= 0 {
+		p := math.Pow(10, float64(pf.decimals))
+		f = math.Round(f*p) / p
+	}
+	if !pf.stripZeros && !pf.decimalComma {
+		return f
+	}
+
+	var s string
+	switch {
+	case pf.stripZeros:
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+	case pf.decimals >= 0:
+		s = strconv.FormatFloat(f, 'f', pf.decimals, 64)
+	default:
+		// Keep two decimals unless there are more.
+		s = strconv.FormatFloat(f, 'f', -1, 64)
+		if i := strings.IndexByte(s, '.'); i < 0 {
+			s += ".00"
+		} else if len(s)-i == 2 {
+			s += "0"
+		}
+	}
+	if pf.decimalComma {
+		s = strings.Replace(s, ".", ",", 1)
+	}
+	return s
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// printings implements the "printings" subcommand.
+// It lists every printing of the named card with its prices,
+// and with -insert,
+// adds a row to the sheet for each one
+// (so you can delete the ones you don't own).
+func (r *runner) printings(ctx context.Context, foil, insert bool, name string, _ []string) error {
+	obj, err := r.scryfall.namedCard(ctx, name, "")
+	if err != nil {
+		return fmt.Errorf("looking up %q: %w", name, err)
+	}
+
+	var prints []respObj
+	for u := obj.PrintsSearchURI; u != ""; {
+		var list listObj
+		if err := r.scryfall.getURL(ctx, u, &list); err != nil {
+			return fmt.Errorf("getting printings of %q: %w", obj.Name, err)
+		}
+		prints = append(prints, list.Data...)
+		if !list.HasMore {
+			break
+		}
+		u = list.NextPage
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "Set\tSet name\tNumber\tPrice\tFoil price")
+	for _, p := range prints {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", p.Set, p.SetName, p.CollectorNumber, p.Prices.USD, p.Prices.price(true))
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if !insert {
+		return nil
+	}
+	return r.insertPrintings(ctx, prints, foil)
+}
+
+// insertPrintings appends a row to the sheet for each of the given printings,
+// filling in whichever of the card name, set code, foil, price, last updated, and collector number columns
+// the sheet has.
+func (r *runner) insertPrintings(ctx context.Context, prints []respObj, foil bool) error {
+	t, err := r.openTable(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Format(time.RFC3339)
+
+	var rows [][]any
+	for _, p := range prints {
+		row, set := t.newRow()
+		set(cardNameField, p.Name)
+		set(setCodeField, p.Set)
+		set(foilField, foil)
+		if price, ok := parsePrice(p.Prices.price(foil)); ok {
+			set(priceField, price)
+			set(lastUpdatedField, now)
+		}
+		set(collectorNumberField, p.CollectorNumber)
+		rows = append(rows, row)
+	}
+
+	return r.appendRows(ctx, t, rows)
+}
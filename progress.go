@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// A progress keeps count of what has happened to the rows in a run
+// and periodically displays a summary,
+// including an estimate of the time remaining.
+//
+// A progress is also an io.Writer,
+// and is where log output goes.
+// That way,
+// when the status line is being rewritten in place on a terminal,
+// log messages don't get tangled up with it.
+type progress struct {
+	quiet bool // Show nothing (but still pass log output through).
+
+	w   io.Writer
+	tty bool // Whether w is a terminal, in which case the status line is rewritten in place.
+
+	// secsPerUpdate is the minimum number of seconds needed to update one row,
+	// as determined by the rate limits on the APIs.
+	// It's the basis for the time-remaining estimate.
+	secsPerUpdate float64
+
+	// If set, the status is shown in this full-screen display
+	// instead of a status line.
+	tui *tui
+
+	mu                        sync.Mutex
+	sheetName                 string
+	total                     int    // Number of rows in the current sheet (not counting the heading row).
+	done                      int    // Number of rows in the current sheet processed so far.
+	updated, skipped, errored int    // Counts for the whole run.
+	line                      string // The status line currently displayed on the terminal, if any.
+	lastShown                 time.Time
+}
+
+// newProgress creates a progress that writes to the standard error.
+func newProgress(quiet bool, secsPerUpdate float64) *progress {
+	var tty bool
+	if info, err := os.Stderr.Stat(); err == nil {
+		tty = info.Mode()&os.ModeCharDevice != 0
+	}
+	return &progress{
+		quiet:         quiet,
+		w:             os.Stderr,
+		tty:           tty,
+		secsPerUpdate: secsPerUpdate,
+	}
+}
+
+// reset zeroes the counts, for the start of a new run.
+func (p *progress) reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.updated, p.skipped, p.errored = 0, 0, 0
+}
+
+// startSheet is called when processing of a sheet begins.
+func (p *progress) startSheet(sheetName string, total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.sheetName = sheetName
+	p.total = total
+	p.done = 0
+	p.lastShown = time.Time{}
+}
+
+// rowUpdated, rowSkipped, and rowErrored are called as each row is finished.
+// They also update the rows_total metric.
+func (p *progress) rowUpdated() { p.rowDone(&p.updated, "updated") }
+func (p *progress) rowSkipped() { p.rowDone(&p.skipped, "skipped") }
+func (p *progress) rowErrored() { p.rowDone(&p.errored, "error") }
+
+func (p *progress) rowDone(counter *int, outcome string) {
+	rowsProcessed.WithLabelValues(outcome).Inc()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	*counter++
+	p.done++
+	if p.tui != nil || p.done == p.total || time.Since(p.lastShown) >= time.Second {
+		p.show()
+	}
+}
+
+// finishSheet is called when processing of a sheet ends.
+func (p *progress) finishSheet() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.show()
+	if p.line != "" {
+		// Leave the final status on the screen.
+		fmt.Fprintln(p.w)
+		p.line = ""
+	}
+}
+
+// counts returns the counts of updated, skipped, and errored rows in the run so far.
+func (p *progress) counts() (updated, skipped, errored int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.updated, p.skipped, p.errored
+}
+
+// show displays the current status.
+// The caller must hold p.mu.
+func (p *progress) show() {
+	p.lastShown = time.Now()
+	if p.quiet {
+		return
+	}
+
+	name := p.sheetName
+	if name == "" {
+		name = "sheet"
+	}
+	line := fmt.Sprintf("%s: %d/%d rows, %d updated, %d skipped, %d errors", name, p.done, p.total, p.updated, p.skipped, p.errored)
+	if eta := p.eta(); eta > 0 {
+		line += fmt.Sprintf(", about %s left", eta)
+	}
+
+	if p.tui != nil {
+		p.tui.show(line)
+		return
+	}
+
+	if p.tty {
+		// Carriage return and "erase to end of line."
+		fmt.Fprintf(p.w, "\r%s\033[K", line)
+		p.line = line
+	} else {
+		fmt.Fprintln(p.w, line)
+	}
+}
+
+// eta estimates the time remaining in the current sheet.
+// Each remaining row is assumed to need an update
+// in the same proportion as the rows processed so far
+// (or all of them, at the start),
+// and each update takes as long as the rate limits require.
+// The caller must hold p.mu.
+func (p *progress) eta() time.Duration {
+	remaining := p.total - p.done
+	if remaining <= 0 {
+		return 0
+	}
+	fraction := 1.0
+	if n := p.updated + p.skipped + p.errored; n > 0 {
+		fraction = float64(p.updated+p.errored) / float64(n)
+	}
+	secs := float64(remaining) * fraction * p.secsPerUpdate
+	return time.Duration(secs * float64(time.Second)).Round(time.Second)
+}
+
+// Write implements io.Writer.
+// If a status line is displayed,
+// it is erased before writing buf and redrawn afterwards.
+func (p *progress) Write(buf []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.tui != nil {
+		p.tui.log(buf)
+		return len(buf), nil
+	}
+	if p.line == "" {
+		return p.w.Write(buf)
+	}
+
+	fmt.Fprint(p.w, "\r\033[K")
+	n, err := p.w.Write(buf)
+	if !bytes.HasSuffix(buf, []byte("\n")) {
+		fmt.Fprintln(p.w)
+	}
+	fmt.Fprint(p.w, p.line)
+	return n, err
+}
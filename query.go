@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"strings"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// queryPrefix marks a Card name cell that holds a scryfall search
+// instead of a card name,
+// as in
+//
+//	q:t:eldrazi t:legendary mv>=10
+//
+// for a watchlist of all the Eldrazi titans.
+// See expandQueries.
+const queryPrefix = "q:"
+
+// maxQueryCards is the most cards a search in a query row may find.
+// It keeps a too-broad query
+// (like "q:t:creature")
+// from flooding the sheet.
+const maxQueryCards = 200
+
+// cardQuery returns the search in a Card name cell,
+// and whether there is one.
+func cardQuery(cardName string) (string, bool) {
+	cardName = strings.TrimSpace(cardName)
+	if len(cardName) < len(queryPrefix) || !strings.EqualFold(cardName[:len(queryPrefix)], queryPrefix) {
+		return "", false
+	}
+	return strings.TrimSpace(cardName[len(queryPrefix):]), true
+}
+
+// expandQueries looks for query rows in the table:
+// rows whose Card name is a scryfall search
+// (see queryPrefix).
+// It runs each search,
+// and for each card found that has no row in the sheet yet,
+// it appends one,
+// with the card's name and set code.
+// Those rows are then priced like any others.
+//
+// A card counts as having a row if any row has its name,
+// whatever the set,
+// so rows from an earlier run
+// (or that the user added, or changed to a different printing)
+// aren't duplicated.
+// That means expanding again on each run is harmless,
+// and picks up cards that newly match the search,
+// like the latest printings.
+// (It also means a result row that's deleted comes back;
+// to stop tracking a search, delete its query row.)
+//
+// The return value is the number of rows added.
+func (r *runner) expandQueries(ctx context.Context, t *table) (int, error) {
+	var (
+		queries []string
+		have    = make(map[string]bool) // Lowercase card names.
+	)
+	for rownum := t.headerRow + 1; rownum < t.endRow && rownum < len(t.rows); rownum++ {
+		name := t.cell(rownum, cardNameField)
+		if q, ok := cardQuery(name); ok {
+			queries = append(queries, q)
+			continue
+		}
+		have[strings.ToLower(normalizeFaces(name))] = true
+	}
+	if len(queries) == 0 {
+		return 0, nil
+	}
+
+	var rows [][]any
+	for _, q := range queries {
+		found, err := r.scryfall.search(ctx, q)
+		if errors.Is(err, majicerr.ErrCardNotFound) {
+			slog.Info("Query found no cards", "sheet", t.sheetName, "query", q)
+			continue
+		}
+		if err != nil {
+			slog.Warn("Error running query", "sheet", t.sheetName, "query", q, "err", err)
+			continue
+		}
+		for _, obj := range found {
+			key := strings.ToLower(normalizeFaces(obj.Name))
+			if have[key] {
+				continue
+			}
+			if len(obj.CardFaces) > 0 && have[strings.ToLower(obj.CardFaces[0].Name)] {
+				// A row with the name of the front face.
+				continue
+			}
+			have[key] = true
+
+			row, set := t.newRow()
+			set(cardNameField, obj.Name)
+			set(setCodeField, obj.Set)
+			rows = append(rows, row)
+		}
+	}
+	if len(rows) == 0 {
+		return 0, nil
+	}
+	if err := r.appendRows(ctx, t, rows); err != nil {
+		return 0, err
+	}
+	slog.Info("Added rows for query results", "sheet", t.sheetName, "rows", len(rows))
+	return len(rows), nil
+}
+
+// search returns the cards matching a scryfall search query
+// (in the syntax of https://scryfall.com/docs/syntax),
+// one printing of each.
+// It's an error for the query to find more than maxQueryCards cards.
+func (sc *scryfallClient) search(ctx context.Context, q string) ([]respObj, error) {
+	v := url.Values{}
+	v.Set("q", q)
+
+	var list listObj
+	if err := sc.get(ctx, "/cards/search", v, &list); err != nil {
+		return nil, err
+	}
+	if list.TotalCards > maxQueryCards {
+		return nil, fmt.Errorf("query finds %d cards, more than the limit of %d", list.TotalCards, maxQueryCards)
+	}
+
+	result := list.Data
+	for list.HasMore && len(result) <= maxQueryCards {
+		next := list.NextPage
+		list = listObj{}
+		if err := sc.getURL(ctx, next, &list); err != nil {
+			return nil, err
+		}
+		result = append(result, list.Data...)
+	}
+	if len(result) > maxQueryCards {
+		return nil, fmt.Errorf("query finds more than the limit of %d cards", maxQueryCards)
+	}
+	return result, nil
+}
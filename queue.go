@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// A rowQueue is the work queue for -queue,
+// for collections too big to get through comfortably in one go.
+//
+// Normally processSheet looks up every row
+// and then writes all of the sheet's updates at once,
+// so a run that's interrupted
+// (or that crashes, or whose machine goes to sleep)
+// an hour into a big sheet
+// still writes what it has,
+// but one that's killed outright loses everything.
+// With a queue,
+// the stages are decoupled:
+// the rows to do are read from the sheet and saved in the queue file,
+// looked up one by one,
+// and handed to the pipeline's write stage
+// (see pipeline.go),
+// which writes them to the sheet in batches as it goes
+// and crosses them off in the queue file.
+// If the run stops for any reason,
+// the next one picks up the rows still in the queue,
+// in the same order,
+// before queuing anything new.
+//
+// Rows are identified by number,
+// so a queue left over from an interrupted run
+// is thrown away if the sheet has gained or lost rows since
+// (and the rows are queued afresh).
+//
+// A nil *rowQueue is valid and does nothing;
+// processSheet then works the usual way.
+type rowQueue struct {
+	filename string
+
+	mu       sync.Mutex
+	SheetKey string                  `json:"sheet_key"`
+	Sheets   map[string]*queuedSheet `json:"sheets"` // Keyed by sheet name.
+}
+
+// A queuedSheet is the part of a rowQueue for one sheet.
+type queuedSheet struct {
+	Rows    int   `json:"rows"`    // How many rows the sheet had when it was queued.
+	Pending []int `json:"pending"` // The rows still to do, zero-based, in order.
+}
+
+// queueBatchRows and queueFlushInterval determine how often the write stage writes
+// when there's a queue:
+// after this many rows,
+// or after this much time with some rows waiting,
+// whichever comes first.
+const (
+	queueBatchRows     = 100
+	queueFlushInterval = 30 * time.Second
+)
+
+// loadRowQueue loads the queue in the given file.
+// It's not an error for the file not to exist.
+func loadRowQueue(filename string) (*rowQueue, error) {
+	q := &rowQueue{filename: filename}
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filename, err)
+	}
+	if err := json.Unmarshal(data, q); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return q, nil
+}
+
+// rows returns the rows of the named sheet to process, in order.
+// If the queue has rows left from an earlier run,
+// it's those;
+// otherwise it queues and returns the rows from order.
+// numRows is how many rows the sheet has now.
+func (q *rowQueue) rows(sheetKey, sheetName string, numRows int, order func() []int) ([]int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.SheetKey != sheetKey {
+		q.SheetKey = sheetKey
+		q.Sheets = nil
+	}
+	if q.Sheets == nil {
+		q.Sheets = make(map[string]*queuedSheet)
+	}
+
+	if qs := q.Sheets[sheetName]; qs != nil && len(qs.Pending) > 0 {
+		if qs.Rows == numRows {
+			slog.Info("Resuming from queue", "sheet", sheetName, "rows", len(qs.Pending))
+			return append([]int(nil), qs.Pending...), nil
+		}
+		slog.Warn("Sheet has changed size since it was queued, starting over", "sheet", sheetName, "was", qs.Rows, "now", numRows)
+	}
+
+	pending := order()
+	q.Sheets[sheetName] = &queuedSheet{Rows: numRows, Pending: pending}
+	return append([]int(nil), pending...), q.save()
+}
+
+// done removes the given rows of the named sheet from the queue
+// and saves it.
+func (q *rowQueue) done(sheetName string, rownums []int) error {
+	if q == nil {
+		return nil
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	qs := q.Sheets[sheetName]
+	if qs == nil {
+		return nil
+	}
+	isDone := make(map[int]bool)
+	for _, rownum := range rownums {
+		isDone[rownum] = true
+	}
+	var pending []int
+	for _, rownum := range qs.Pending {
+		if !isDone[rownum] {
+			pending = append(pending, rownum)
+		}
+	}
+	qs.Pending = pending
+	if len(pending) == 0 {
+		delete(q.Sheets, sheetName)
+	}
+	return q.save()
+}
+
+// save writes the queue to its file.
+// The caller must hold q.mu.
+func (q *rowQueue) save() error {
+	if err := os.MkdirAll(filepath.Dir(q.filename), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", q.filename, err)
+	}
+	data, err := json.Marshal(q)
+	if err != nil {
+		return fmt.Errorf("encoding queue: %w", err)
+	}
+
+	// As in history.save,
+	// write to a temporary file and rename it.
+	tmpname := q.filename + ".tmp"
+	if err := os.WriteFile(tmpname, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", tmpname, err)
+	}
+	if err := os.Rename(tmpname, q.filename); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmpname, q.filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bobg/majic/a1"
+)
+
+// parseA1Range parses a range in A1 notation without a sheet name,
+// like "A2:H200," "A:H," or "2:200,"
+// for restricting processing to part of a sheet
+// (see the -range flag).
+// See package a1.
+func parseA1Range(s string) (a1.Range, error) {
+	rng, err := a1.Parse(s)
+	if err != nil {
+		return a1.Range{}, err
+	}
+	if rng.Sheet != "" {
+		return a1.Range{}, fmt.Errorf("range %q must not include a sheet name", s)
+	}
+	rng.StartRow = max(rng.StartRow, 0)
+	rng.StartCol = max(rng.StartCol, 0)
+	return rng, nil
+}
+
+// restrictRows returns a copy of rows in which the cells outside g are blank,
+// and the rows after g are removed.
+// Row and column numbers are unchanged.
+func restrictRows(g a1.Range, rows [][]any) [][]any {
+	if g.EndRow >= 0 && len(rows) > g.EndRow+1 {
+		rows = rows[:g.EndRow+1]
+	}
+	result := make([][]any, len(rows))
+	for i, row := range rows {
+		if !g.HasRow(i) {
+			continue
+		}
+		result[i] = make([]any, len(row))
+		for j, val := range row {
+			if g.HasCol(j) {
+				result[i][j] = val
+			} else {
+				result[i][j] = ""
+			}
+		}
+	}
+	return result
+}
+
+// isBlankRow tells whether a row has nothing in it.
+func isBlankRow(row []any) bool {
+	for _, val := range row {
+		if strings.TrimSpace(fmt.Sprint(val)) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveRange turns the value of the -range flag into an a1.Range.
+// It may be the name of a named range in the spreadsheet
+// (in which case the sheet containing the range is also returned),
+// or a range in A1 notation
+// (in which case the sheet name is empty,
+// and the range applies to whatever sheets are being processed).
+// Named ranges are looked up at the start of each run,
+// since they can move as the spreadsheet is edited.
+func (r *runner) resolveRange(ctx context.Context, spec string) (string, a1.Range, error) {
+	ss, err := r.svc.get(ctx, r.sheetKey, "namedRanges", "sheets.properties(sheetId,title)")
+	if err != nil {
+		return "", a1.Range{}, fmt.Errorf("listing named ranges: %w", err)
+	}
+	for _, nr := range ss.NamedRanges {
+		if !strings.EqualFold(nr.Name, spec) || nr.Range == nil {
+			continue
+		}
+		var sheetName string
+		for _, sh := range ss.Sheets {
+			if sh.Properties.SheetId == nr.Range.SheetId {
+				sheetName = sh.Properties.Title
+				break
+			}
+		}
+		if sheetName == "" {
+			return "", a1.Range{}, fmt.Errorf("no sheet for named range %q", nr.Name)
+		}
+
+		// The API's range bounds are zero-based and half-open,
+		// with zero for an unbounded end.
+		g := a1.Range{
+			StartRow: int(nr.Range.StartRowIndex),
+			EndRow:   int(nr.Range.EndRowIndex) - 1,
+			StartCol: int(nr.Range.StartColumnIndex),
+			EndCol:   int(nr.Range.EndColumnIndex) - 1,
+		}
+		return sheetName, g, nil
+	}
+
+	g, err := parseA1Range(spec)
+	if err != nil {
+		return "", a1.Range{}, fmt.Errorf("%q is not a named range or an A1-notation range: %w", spec, err)
+	}
+	return "", g, nil
+}
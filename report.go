@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// A runReport is a machine-readable summary of a run,
+// written as JSON to the file named by the -report flag.
+type runReport struct {
+	Start  time.Time    `json:"start"`
+	End    time.Time    `json:"end"`
+	Rows   []rowReport  `json:"rows"`
+	Totals reportTotals `json:"totals"`
+
+	// Seconds spent waiting on each API's rate limiter during the run.
+	LimiterWait map[string]float64 `json:"limiter_wait_seconds"`
+
+	mu sync.Mutex
+}
+
+// A rowReport tells what happened to one row.
+type rowReport struct {
+	Sheet    string `json:"sheet"`
+	Row      int    `json:"row"` // One-based, as in the spreadsheet UI.
+	Card     string `json:"card,omitempty"`
+	Set      string `json:"set,omitempty"`
+	Foil     bool   `json:"foil,omitempty"`
+	Outcome  string `json:"outcome"` // One of the outcome constants below.
+	OldPrice string `json:"old_price,omitempty"`
+	NewPrice string `json:"new_price,omitempty"`
+	NoPrice  bool   `json:"no_price,omitempty"` // The card was found but has no price.
+	Error    string `json:"error,omitempty"`
+}
+
+// These are the possible values for rowReport.Outcome.
+const (
+	outcomeUpdated   = "updated"
+	outcomeSkipped   = "skipped"
+	outcomeNotFound  = "not-found"
+	outcomeAmbiguous = "ambiguous"
+	outcomeError     = "error"
+)
+
+// reportTotals counts the rows in a runReport by outcome.
+type reportTotals struct {
+	Updated   int `json:"updated"`
+	Skipped   int `json:"skipped"`
+	NotFound  int `json:"not_found"`
+	Ambiguous int `json:"ambiguous"`
+	Errors    int `json:"errors"`
+
+	// NoPrice counts the updated rows whose cards have no price.
+	// (They're also counted in Updated.)
+	NoPrice int `json:"no_price"`
+}
+
+func newRunReport() *runReport {
+	return &runReport{Start: time.Now()}
+}
+
+// add records the result of processing a row.
+// The error is the one returned by processRow, if any.
+func (rep *runReport) add(sheetName string, rownum int, res rowResult, err error) {
+	rr := rowReport{
+		Sheet:    sheetName,
+		Row:      rownum + 1,
+		Card:     res.cardName,
+		Set:      res.setCode,
+		Foil:     res.foil,
+		OldPrice: res.oldPrice,
+		NewPrice: res.newPrice,
+		NoPrice:  res.noPrice,
+	}
+
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	switch {
+	case errors.Is(err, majicerr.ErrCardNotFound):
+		rr.Outcome = outcomeNotFound
+		rr.Error = err.Error()
+		rep.Totals.NotFound++
+	case errors.Is(err, majicerr.ErrAmbiguous):
+		rr.Outcome = outcomeAmbiguous
+		rr.Error = err.Error()
+		rep.Totals.Ambiguous++
+	case err != nil:
+		rr.Outcome = outcomeError
+		rr.Error = err.Error()
+		rep.Totals.Errors++
+	case res.updated:
+		rr.Outcome = outcomeUpdated
+		rep.Totals.Updated++
+		if res.noPrice {
+			rep.Totals.NoPrice++
+		}
+	default:
+		rr.Outcome = outcomeSkipped
+		rep.Totals.Skipped++
+	}
+	rep.Rows = append(rep.Rows, rr)
+}
+
+// write stores the report as JSON in the named file.
+func (rep *runReport) write(filename string) error {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	rep.End = time.Now()
+	rep.LimiterWait = limiterWaits.seconds()
+
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding report: %w", err)
+	}
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		return fmt.Errorf("writing report to %s: %w", filename, err)
+	}
+	return nil
+}
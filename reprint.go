@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// upcomingPrintings are the cards in sets that haven't been released yet
+// but whose cards have been previewed
+// (and so are in scryfall already),
+// keyed by lowercase card name.
+// They're for the optional Reprint risk column:
+// a card about to be reprinted
+// will probably drop in price,
+// so it may be time to sell.
+type upcomingPrintings map[string][]upcomingPrinting
+
+// An upcomingPrinting is a printing of a card in an unreleased set.
+type upcomingPrinting struct {
+	set, setName, releasedAt string
+}
+
+// upcomingPrintings gets the printings of cards in sets
+// whose release dates are after today.
+func (sc *scryfallClient) upcomingPrintings(ctx context.Context) (upcomingPrintings, error) {
+	v := url.Values{}
+	v.Set("q", "date>"+time.Now().Format("2006-01-02"))
+	v.Set("unique", "prints")
+
+	result := make(upcomingPrintings)
+
+	var list listObj
+	err := sc.get(ctx, "/cards/search", v, &list)
+	if errors.Is(err, majicerr.ErrCardNotFound) {
+		// Nothing is being previewed right now.
+		return result, nil
+	}
+	for {
+		if err != nil {
+			return nil, fmt.Errorf("getting upcoming printings: %w", err)
+		}
+		for _, obj := range list.Data {
+			key := strings.ToLower(obj.Name)
+			result[key] = append(result[key], upcomingPrinting{set: obj.Set, setName: obj.SetName, releasedAt: obj.ReleasedAt})
+		}
+		if !list.HasMore {
+			break
+		}
+		next := list.NextPage
+		list = listObj{}
+		err = sc.getURL(ctx, next, &list)
+	}
+	return result, nil
+}
+
+// reprintRisk is the value for the Reprint risk column
+// for a row whose card is obj:
+// the sets, with release dates,
+// of the card's upcoming printings,
+// like "Foundations (2024-11-15),"
+// or the empty string if it has none.
+// A printing in the row's own set doesn't count
+// (for a row that's for the new printing).
+func (u upcomingPrintings) reprintRisk(obj *respObj) string {
+	var (
+		sets []string
+		seen = make(map[string]bool)
+	)
+	for _, p := range u[strings.ToLower(obj.Name)] {
+		if strings.EqualFold(p.set, obj.Set) || seen[p.set] {
+			continue
+		}
+		seen[p.set] = true
+		sets = append(sets, fmt.Sprintf("%s (%s)", p.setName, p.releasedAt))
+	}
+	sort.Strings(sets)
+	return strings.Join(sets, "; ")
+}
@@ -2,140 +2,507 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
-	"net/url"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/pkg/errors"
-	"google.golang.org/api/sheets/v4"
+	"github.com/bobg/majic/a1"
+	"github.com/bobg/majic/majicerr"
 )
 
 type rowHandler struct {
-	sheetKey, sheetName                                        string
-	rows                                                       [][]any
+	sheetName string
+	rows      [][]any
+
+	// Required columns.
 	cardNameCol, setCodeCol, foilCol, lastUpdatedCol, priceCol int
-	valuesSvc                                                  *sheets.SpreadsheetsValuesService
-	cardAPIClient                                              *http.Client
-	oneDayAgo                                                  time.Time
-	baseURL                                                    *url.URL
+
+	// Optional columns, -1 if missing.
+	// (The set-code column is also optional when there's a set-name column.)
+	setNameCol                                        int
+	statusCol                                         int
+	forceCol                                          int
+	conditionCol                                      int
+	collectorNumberCol                                int
+	languageCol                                       int
+	previousPriceCol                                  int
+	watchCol                                          int
+	buylistCol                                        int
+	reprintRiskCol                                    int
+	sourceCol                                         int
+	productIDCol                                      int
+	proxyCol                                          int
+	purchasePriceCol, gainLossCol, gainLossPercentCol int
+	metadataCols                                      map[int]metadataField // Optional columns filled from the scryfall response.
+	priceCols                                         map[int]string        // Other price columns, with the name of the price for each; see otherPrices.
+	priceField                                        string                // If set, the price for the Price column; see config.PriceColumns.
+	computed                                          []computedColumn      // Columns computed from the card's data; see transform.go.
+
+	sets     *setCatalog       // Non-nil when there's a set-name column or -validate-sets is given.
+	buylist  *buylistPrices    // Non-nil when there's a buylist column.
+	upcoming upcomingPrintings // Non-nil when there's a Reprint risk column.
+	badSets  map[int]error     // Rows with unknown set codes; see checkSetCodes.
+	filter   *rowFilter        // If set, only rows matching this are processed.
+
+	catalog cardCatalog  // Where to look up cards; see game.go.
+	sealed  sealedSource // Where to price sealed product; nil if there is none.
+
+	cfg            *config
+	cutoff         time.Time   // Rows updated after this are skipped.
+	force          bool        // If true, ignore the last-updated time.
+	cheapest       bool        // If true, rows with no set code get the price of the cheapest printing.
+	priceFormat    priceFormat // How to write prices; see priceformat.go.
+	canonicalNames bool        // If true, write scryfall's form of the card name back to the sheet.
+	progress       *progress
+}
+
+// lookup gets the scryfall information for the card in a row,
+// from the cache if possible.
+// For sealed product
+// (see productID),
+// only the price is filled in.
+func (rh rowHandler) lookup(ctx context.Context, row []any, res rowResult) (*respObj, error) {
+	if id := rh.productID(row); id != "" {
+		if rh.sealed == nil {
+			return nil, fmt.Errorf("no source for sealed-product prices (see -tcgplayer-key)")
+		}
+		return rh.sealed.product(ctx, id)
+	}
+	var number string
+	if rh.collectorNumberCol >= 0 && len(row) > rh.collectorNumberCol {
+		number = fmt.Sprint(row[rh.collectorNumberCol])
+	}
+	cheapest := res.setCode == "" && rh.cheapest
+	return rh.catalog.card(ctx, res.cardName, res.setCode, number, res.lang, res.foil, cheapest)
+}
+
+// A rowError is an error that affects only a single row,
+// such as a failed card lookup.
+// It doesn't stop the run:
+// the row is counted as an error and processing continues with the next one.
+type rowError struct {
+	err error
 }
 
-func (rh rowHandler) processRow(ctx context.Context, rownum int) error {
-	row := rh.rows[rownum]
+func (e rowError) Error() string { return e.err.Error() }
+func (e rowError) Unwrap() error { return e.err }
 
-	if len(row) > rh.lastUpdatedCol {
+// A rowResult tells what happened to a row in processRow.
+type rowResult struct {
+	updated            bool // False if the row was skipped.
+	fresh              bool // The row was skipped because it was updated recently.
+	cardName, setCode  string
+	lang               string // From the optional Language column.
+	watch              string // From the optional Watch column.
+	foil               bool
+	oldPrice, newPrice string
+	noPrice            bool // The card was found but has no price.
+
+	// The row is for a proxy
+	// (a stand-in for the card, as for playtesting),
+	// so it was skipped.
+	// Proxies don't count toward the collection's value;
+	// see runner.addValue.
+	proxy bool
+
+	updates []cellUpdate // New cell values, for the caller to write to the sheet.
+}
+
+// processRow looks up the price of the card in the given row
+// and computes the row's new cell values
+// (which it does not write to the sheet).
+// It reports what it did in a rowResult,
+// which is filled in as far as processing got even when there's an error.
+func (rh rowHandler) processRow(ctx context.Context, rownum int) (rowResult, error) {
+	var (
+		row = rh.rows[rownum]
+		res rowResult
+	)
+
+	// Gather what's in the row.
+	// Any of these cells may be missing,
+	// since the Sheets API leaves off empty cells at the end of a row.
+	if len(row) > rh.cardNameCol {
+		// (If the value in this row's Card Name column is somehow not a string,
+		// cardName remains empty.)
+		res.cardName, _ = row[rh.cardNameCol].(string)
+	}
+	if rh.setCodeCol >= 0 && len(row) > rh.setCodeCol {
+		res.setCode, _ = row[rh.setCodeCol].(string)
+	}
+	if len(row) > rh.foilCol {
+		res.foil = isTrue(row[rh.foilCol])
+	}
+	if rh.languageCol >= 0 && len(row) > rh.languageCol {
+		res.lang = fmt.Sprint(row[rh.languageCol])
+	}
+	if len(row) > rh.priceCol {
+		res.oldPrice = fmt.Sprint(row[rh.priceCol])
+		if rh.priceFormat.isMissing(res.oldPrice) {
+			// The card had no price last time.
+			res.oldPrice = ""
+		}
+	}
+	if rh.watchCol >= 0 && len(row) > rh.watchCol {
+		res.watch = fmt.Sprint(row[rh.watchCol])
+	}
+
+	if res.cardName == "" {
+		// This row does not have a card name in it.
+		return res, nil
+	}
+	if _, ok := cardQuery(res.cardName); ok {
+		// This is a query row,
+		// whose results were added as rows of their own.
+		// See expandQueries.
+		return res, nil
+	}
+
+	if rh.filter != nil {
+		ok, err := rh.filter.match(row)
+		if err != nil {
+			return res, rowError{err: fmt.Errorf("evaluating filter: %w", err)}
+		}
+		if !ok {
+			return res, nil
+		}
+	}
+
+	if err, ok := rh.badSets[rownum]; ok {
+		// A warning has already been written to the Status column.
+		return res, rowError{err: err}
+	}
+
+	if rh.proxyCol >= 0 && len(row) > rh.proxyCol && isTrue(row[rh.proxyCol]) {
+		res.proxy = true
+		return res, nil
+	}
+
+	// A row can be forced to update
+	// by checking the box in its Force column
+	// (if there is one),
+	// or by putting "!" in its Last updated column.
+	var rowForced bool
+	if rh.forceCol >= 0 && len(row) > rh.forceCol {
+		rowForced = isTrue(row[rh.forceCol])
+	}
+	if len(row) > rh.lastUpdatedCol && fmt.Sprint(row[rh.lastUpdatedCol]) == "!" {
+		rowForced = true
+	}
+
+	if !rh.force && !rowForced && len(row) > rh.lastUpdatedCol {
 		if lastUpdated, ok := row[rh.lastUpdatedCol].(string); ok {
 			when, err := time.Parse(time.RFC3339, lastUpdated)
-			if err == nil && when.After(rh.oneDayAgo) {
-				// If this row was updated less than one day ago,
+			if err == nil && when.After(rh.cutoff) {
+				// If this row was updated recently
+				// (by default, less than one day ago),
 				// skip it as requested in the scryfall API docs.
-				return nil
+				slog.Debug("Skipping recently updated row", "sheet", rh.sheetName, "row", rownum+1, "age", time.Since(when).Round(time.Minute))
+				res.fresh = true
+				return res, nil
 			}
 		}
 	}
 
-	if len(row) <= rh.cardNameCol {
-		// This row does not have a card name in it.
-		return nil
+	// If there's no set code but there is a set name,
+	// turn the name into a code.
+	if res.setCode == "" && rh.setNameCol >= 0 && len(row) > rh.setNameCol {
+		if setName := fmt.Sprint(row[rh.setNameCol]); setName != "" {
+			code, ok := rh.sets.setCode(setName)
+			if !ok {
+				return res, rowError{err: fmt.Errorf("unknown set name %q", setName)}
+			}
+			res.setCode = code
+		}
 	}
 
-	cardName, ok := row[rh.cardNameCol].(string)
-	if !ok {
-		// The value in this row's Card Name column is somehow not a string.
-		return nil
+	cardName, setCode, foil := res.cardName, res.setCode, res.foil
+
+	obj, err := rh.lookup(ctx, row, res)
+	if err != nil {
+		return res, rowError{err: err}
 	}
 
-	var setCode string
-	if len(row) > rh.setCodeCol {
-		setCode, _ = row[rh.setCodeCol].(string)
+	price, priceName := obj.Prices.price(foil), obj.Prices.priceName(foil)
+	if rh.priceField != "" {
+		price, _ = obj.Prices.field(rh.priceField)
+		priceName = rh.priceField
 	}
 
-	var foil bool
-	if len(row) > rh.foilCol {
-		val := row[rh.foilCol] // TODO: figure out what type val has.
-		fmt.Printf("xxx The type of the foil-column value is %T and it looks like: %v\n", val, val)
-		foil, _ = val.(bool) // TODO: adjust based on what we find out from the output above.
+	// Scryfall's prices are for near-mint cards.
+	// If there's a Condition column,
+	// adjust the price for the card's condition.
+	condMult := 1.0
+	if rh.conditionCol >= 0 && len(row) > rh.conditionCol {
+		cond := fmt.Sprint(row[rh.conditionCol])
+		m, ok := rh.cfg.conditionMultiplier(cond)
+		if !ok {
+			return res, rowError{err: fmt.Errorf("unknown condition %q", cond)}
+		}
+		condMult = m
+		price = adjustPrice(price, m)
 	}
 
-	// Make a copy of the baseURL.
-	u := *rh.baseURL
+	res.newPrice = price
+	res.noPrice = price == ""
+	slog.Debug("Got price", "sheet", rh.sheetName, "row", rownum+1, "card", cardName, "set", setCode, "foil", foil, "price", price)
 
-	// Set the URL's query string.
-	v := url.Values{}
-	v.Set("exact", cardName)
-	if setCode != "" {
-		v.Set("set", setCode)
+	// Collect all the new values for this row.
+	// They're written to the sheet later,
+	// together with those for all the other rows.
+	// See updates.go.
+	set := func(col int, val any) {
+		var old any
+		if len(row) > col {
+			old = row[col]
+		}
+		if _, ok := val.(formula); !ok && old != nil && fmt.Sprint(old) == fmt.Sprint(val) {
+			// No change.
+			return
+		}
+		slog.Debug("Setting cell", "cell", a1.Cell(rh.sheetName, rownum, col), "value", val)
+		res.updates = append(res.updates, cellUpdate{row: rownum, col: col, val: val})
 	}
-	u.RawQuery = v.Encode()
 
-	resp, err := rh.cardAPIClient.Get(u.String())
-	if err != nil {
-		return errors.Wrap(err, "querying scryfall API")
+	// If there's a Previous price column,
+	// copy the old price there before overwriting it.
+	if rh.previousPriceCol >= 0 {
+		var oldVal any = res.oldPrice
+		if _, ok := parsePrice(res.oldPrice); ok {
+			oldVal = rh.priceFormat.value(res.oldPrice)
+		}
+		set(rh.previousPriceCol, oldVal)
 	}
-	defer resp.Body.Close()
 
-	var (
-		dec = json.NewDecoder(resp.Body)
-		obj respObj
-	)
-	err = dec.Decode(&obj)
-	if err != nil {
-		return errors.Wrap(err, "JSON-decoding scryfall response")
+	// Set the price in the spreadsheet.
+	// Normally it's written as a number,
+	// so formulas like SUM work on the Price column.
+	// (Scryfall reports prices as strings.)
+	// If there's no price, the cell is cleared
+	// (or gets the -no-price value).
+	// See priceformat.go.
+	set(rh.priceCol, rh.priceFormat.value(price))
+
+	// Fill in any other price columns,
+	// such as the card's price in other finishes.
+	// See otherPrices.
+	rh.otherPrices(obj, condMult, set)
+
+	// If there's a Buylist column,
+	// fill in what the dealer would pay
+	// (or clear it, if the card isn't on the buylist).
+	// See buylist.go.
+	if rh.buylistCol >= 0 {
+		var buylistVal any = ""
+		if bp, ok := rh.buylist.price(obj.ID, foil); ok {
+			buylistVal = bp
+		}
+		set(rh.buylistCol, buylistVal)
 	}
 
-	var price string
-	if foil {
-		price = obj.Prices.USDFoil
-	} else {
-		price = obj.Prices.USD
+	// If there's a Reprint risk column,
+	// fill it in with the card's upcoming printings, if any.
+	// See reprint.go.
+	if rh.reprintRiskCol >= 0 {
+		set(rh.reprintRiskCol, rh.upcoming.reprintRisk(obj))
 	}
 
-	// Set the price in the spreadsheet.
-	cell := cellName(rh.sheetName, rownum, rh.priceCol)
-	vr := &sheets.ValueRange{Range: cell, Values: [][]any{{price}}}
-	_, err = rh.valuesSvc.Update(rh.sheetKey, cell, vr).Context(ctx).ValueInputOption("RAW").Do()
-	if err != nil {
-		return errors.Wrapf(err, "setting price in cell %s", cell)
+	// If there's a Purchase price,
+	// fill in the Gain/Loss columns
+	// (whichever of them exist).
+	if rh.purchasePriceCol >= 0 && len(row) > rh.purchasePriceCol {
+		purchase, ok1 := parsePrice(fmt.Sprint(row[rh.purchasePriceCol]))
+		current, ok2 := parsePrice(price)
+		if ok1 && ok2 {
+			gain := current - purchase
+			if rh.gainLossCol >= 0 {
+				set(rh.gainLossCol, math.Round(gain*100)/100)
+			}
+			if rh.gainLossPercentCol >= 0 && purchase != 0 {
+				// This is a fraction,
+				// e.g. 0.25 for a 25% gain.
+				// Give the column a percent format to see it as a percentage.
+				set(rh.gainLossPercentCol, gain/purchase)
+			}
+		}
 	}
 
 	// Set the last-updated time.
-	cell = cellName(rh.sheetName, rownum, rh.lastUpdatedCol)
-	vr = &sheets.ValueRange{Range: cell, Values: [][]any{{time.Now().Format(time.RFC3339)}}}
-	_, err = rh.valuesSvc.Update(rh.sheetKey, cell, vr).Context(ctx).ValueInputOption("RAW").Do()
-	if err != nil {
-		return errors.Wrapf(err, "setting last-updated time in cell %s", cell)
+	set(rh.lastUpdatedCol, time.Now().Format(time.RFC3339))
+
+	// Record where the price came from.
+	// See source.go.
+	if rh.sourceCol >= 0 {
+		set(rh.sourceCol, priceSource(obj, priceName, condMult))
+	}
+
+	// If the row was forced with the Force column,
+	// clear it so the next run doesn't force it again.
+	// (A "!" in the Last updated column has already been overwritten.)
+	if rh.forceCol >= 0 && len(row) > rh.forceCol && isTrue(row[rh.forceCol]) {
+		set(rh.forceCol, false)
 	}
 
-	return nil
+	// Replace the card name with scryfall's canonical form of it if requested
+	// (e.g. the full "Fire // Ice" when the sheet has only "Fire").
+	if rh.canonicalNames && obj.Name != "" && obj.Name != cardName {
+		set(rh.cardNameCol, obj.Name)
+	}
+
+	// Fill in any metadata columns.
+	// See metadata.go.
+	// (Sealed product has none of that information,
+	// so leave whatever's there.)
+	if rh.productID(row) == "" {
+		for col, f := range rh.metadataCols {
+			if col == rh.collectorNumberCol && len(row) > col && fmt.Sprint(row[col]) != "" {
+				// The collector number was part of the lookup
+				// (see lookup),
+				// so it's filled in only when it's blank,
+				// never overwritten.
+				continue
+			}
+			set(col, f.value(obj))
+		}
+	}
+
+	// Fill in any computed columns.
+	// See transform.go.
+	if len(rh.computed) > 0 {
+		vars := cardVars(obj, res)
+		for _, c := range rh.computed {
+			val, err := c.value(vars, row)
+			if err != nil && res.noPrice {
+				// Probably arithmetic on the missing price.
+				val, err = "", nil
+			}
+			if err != nil {
+				return res, rowError{err: fmt.Errorf("computing %q: %w", c.heading, err)}
+			}
+			set(c.col, val)
+		}
+	}
+
+	res.updated = true
+	return res, nil
 }
 
-// This defines a type to contain the information we parse from the /cards/named endpoint.
-// The actual response has many more data fields than the ones we're pulling out here.
-// The complete description is at https://scryfall.com/docs/api/cards.
-type respObj struct {
-	Name    string    `json:"name"`
-	Prices  pricesObj `json:"prices"`
-	SetName string    `json:"set_name"`
+// otherPrices fills in the price columns in rh.priceCols
+// from the same response as the Price column,
+// so a sheet can record several of a card's prices
+// with a single lookup.
+// Those are the optional Foil price and Etched price columns,
+// plus any in the config's price_columns.
+//
+// The Foil price and Etched price columns are handy when the Foil column is blank,
+// as when you haven't sorted your foils out yet:
+// the Price column gets the nonfoil price,
+// and these columns show what the card would be worth as a foil.
+// (Unlike pricesObj.price,
+// the Foil price column doesn't fall back to the etched price.)
+//
+// Like the Price column,
+// these are adjusted by condMult for the card's condition.
+// A price the card doesn't have
+// (like a finish it doesn't come in)
+// gets a blank cell.
+func (rh rowHandler) otherPrices(obj *respObj, condMult float64, set func(int, any)) {
+	for col, field := range rh.priceCols {
+		p, _ := obj.Prices.field(field)
+		var val any = ""
+		if _, ok := parsePrice(p); ok {
+			val = rh.priceFormat.value(adjustPrice(p, condMult))
+		}
+		set(col, val)
+	}
+}
+
+// rowStatus is what to write in a row's Status column
+// (if the sheet has one)
+// after processing it,
+// given processRow's results:
+// "updated," "skipped (fresh)," "skipped (proxy)," "not found," or "error: …,"
+// followed by the time.
+// It's empty for rows whose status should be left alone,
+// like blank rows and rows excluded by -filter.
+func rowStatus(res rowResult, err error, now time.Time) string {
+	var status string
+	switch {
+	case errors.Is(err, majicerr.ErrCardNotFound):
+		status = "not found"
+	case err != nil:
+		status = "error: " + err.Error()
+	case res.updated:
+		status = "updated"
+	case res.fresh:
+		status = "skipped (fresh)"
+	case res.proxy:
+		status = "skipped (proxy)"
+	default:
+		return ""
+	}
+	return status + " " + now.Format("2006-01-02 15:04")
 }
 
-// This defines the type of the "prices" field in a respObj.
-type pricesObj struct {
-	USD       string `json:"usd"`
-	USDFoil   string `json:"usd_foil"`
-	USDEtched string `json:"usd_etched"`
+// adjustPrice multiplies a price by m
+// (e.g. a condition multiplier).
+// A price that can't be parsed is returned unchanged.
+func adjustPrice(price string, m float64) string {
+	if f, ok := parsePrice(price); ok && m != 1 {
+		return strconv.FormatFloat(f*m, 'f', 2, 64)
+	}
+	return price
 }
 
-// Row and col are both zero-based.
-func cellName(sheetName string, row, col int) string {
-	return fmt.Sprintf("%s!%s%d", sheetName, colName(col), row+1)
+// productID returns the contents of the row's Product ID column.
+// If it's not empty,
+// the row is for sealed product
+// (like a booster box)
+// rather than a single card,
+// and its price comes from rh.sealed.
+func (rh rowHandler) productID(row []any) string {
+	if rh.productIDCol < 0 || len(row) <= rh.productIDCol {
+		return ""
+	}
+	return strings.TrimSpace(fmt.Sprint(row[rh.productIDCol]))
 }
 
-// This returns A through Z for the first 26 columns, AA-AZ for the next 26, then BA-BZ, etc.
-func colName(col int) string {
-	if col < 26 {
-		return string(byte(col) + 'A')
+// isTrue tells whether a cell value means "true."
+// The Sheets API normally reports a checkbox as the string "TRUE" or "FALSE,"
+// but people also type things like "yes" or "x" into a column like Foil.
+func isTrue(val any) bool {
+	switch v := val.(type) {
+	case bool:
+		return v
+	case string:
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "true", "yes", "y", "x", "1", "foil":
+			return true
+		}
+	}
+	return false
+}
+
+// parsePrice parses a price as found in the sheet or in a scryfall response.
+// It tolerates a leading dollar sign and thousands separators,
+// and a decimal comma like the ones written with -decimal-comma:
+// a single comma followed by one or two digits
+// (as in "1,50" but not "1,500").
+// The boolean result is false if s is not a price.
+func parsePrice(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimPrefix(s, "$")
+	if i := strings.LastIndexByte(s, ','); i >= 0 && strings.Count(s, ",") == 1 && !strings.Contains(s, ".") && len(s)-i <= 3 {
+		s = s[:i] + "." + s[i+1:]
+	}
+	s = strings.ReplaceAll(s, ",", "")
+	if s == "" {
+		return 0, false
 	}
-	return colName(col/26-1) + colName(col%26)
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestProcessRow runs majic on each CSV file in testdata/processrow
+// (loaded into a memSheets as a sheet named for the file)
+// against the fake scryfall,
+// and compares the cells it changes with the matching .golden file.
+// Run with -update to rewrite the golden files.
+func TestProcessRow(t *testing.T) {
+	files, err := filepath.Glob("testdata/processrow/*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no test cases")
+	}
+
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".csv")
+		t.Run(name, func(t *testing.T) {
+			got := runGolden(t, file)
+
+			golden := strings.TrimSuffix(file, ".csv") + ".golden"
+			if *update {
+				if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != string(want) {
+				t.Errorf("got:\n%s\nwant:\n%s", got, want)
+			}
+		})
+	}
+}
+
+// runGolden does a run on the sheet in the given CSV file
+// and describes what happened:
+// the run's error, if any,
+// and the changed cells,
+// with the current time replaced by "NOW."
+func runGolden(t *testing.T, file string) string {
+	t.Helper()
+
+	// Keep the sets list that scryfallClient.sets caches
+	// out of the real cache directory.
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	m, err := loadMemSheets(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fake, err := newFakeScryfall(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fake.Close()
+	srv := httptest.NewServer(errorScryfall(fake.Config.Handler))
+	defer srv.Close()
+	baseURL, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sc := &scryfallClient{
+		client:  srv.Client(),
+		baseURL: baseURL,
+	}
+	r := &runner{
+		sheetKey:    "test",
+		sheetSpec:   strings.TrimSuffix(filepath.Base(file), ".csv"),
+		cfg:         new(config),
+		maxAge:      24 * time.Hour,
+		priceFormat: priceFormat{decimals: -1, missing: "N/A"},
+		svc:         m,
+		scryfall:    sc,
+		catalogs:    map[string]cardCatalog{magicGame: sc},
+		progress:    newProgress(true, 0),
+	}
+
+	now := time.Now()
+	var b strings.Builder
+	if err := r.runOnce(context.Background()); err != nil {
+		fmt.Fprintf(&b, "error: %s\n", err)
+	}
+	for _, c := range m.changed() {
+		fmt.Fprintln(&b, c)
+	}
+	return normalizeTimes(b.String(), now)
+}
+
+// errorScryfall wraps the fake scryfall's handler
+// with some cards whose lookups fail in the ways the real one can:
+//
+//   - "Ambiguous" matches several cards;
+//   - "Server Error" gets a 500;
+//   - "Rate Limited" gets a 429.
+func errorScryfall(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.URL.Query().Get("exact") {
+		case "Ambiguous":
+			writeJSON(w, http.StatusNotFound, map[string]any{"object": "error", "status": 404, "code": "not_found", "type": "ambiguous", "details": "Too many cards match ambiguous name “Ambiguous”."})
+		case "Server Error":
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"object": "error", "status": 500, "details": "Something went wrong."})
+		case "Rate Limited":
+			writeJSON(w, http.StatusTooManyRequests, map[string]any{"object": "error", "status": 429, "details": "Slow down."})
+		default:
+			h.ServeHTTP(w, req)
+		}
+	})
+}
+
+// goldenTimeRegex matches the times that majic writes:
+// in the Last updated column (RFC 3339)
+// and the Status column.
+var goldenTimeRegex = regexp.MustCompile(`\d{4}-\d{2}-\d{2}(?:T\d{2}:\d{2}:\d{2}(?:Z|[+-]\d{2}:\d{2})| \d{2}:\d{2})`)
+
+// normalizeTimes replaces the times in s that are close to now
+// with "NOW,"
+// leaving the ones that were already in the sheet.
+func normalizeTimes(s string, now time.Time) string {
+	return goldenTimeRegex.ReplaceAllStringFunc(s, func(m string) string {
+		when, err := time.Parse(time.RFC3339, m)
+		if err != nil {
+			when, err = time.ParseInLocation("2006-01-02 15:04", m, time.Local)
+		}
+		if err == nil && when.Sub(now).Abs() < time.Hour {
+			return "NOW"
+		}
+		return m
+	})
+}
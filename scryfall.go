@@ -0,0 +1,534 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+const scryfallAPIBase = "https://api.scryfall.com"
+
+// A scryfallClient makes calls to the scryfall API
+// (documented at https://scryfall.com/docs/api).
+type scryfallClient struct {
+	client  *http.Client // Rate-limited; see main.go.
+	baseURL *url.URL     // Normally scryfallAPIBase.
+	cache   *responseCache
+
+	// If set, cards are looked up here before calling the API.
+	// See bulk.go.
+	bulk bulkIndex
+
+	// Set when baseURL is a fake scryfall (see fakescryfall.go),
+	// whose list of sets mustn't replace the real one cached on disk.
+	offline bool
+}
+
+// get calls the scryfall API endpoint at the given path with the given query parameters,
+// and JSON-decodes the response into obj.
+// If the response is a 404,
+// the error wraps majicerr.ErrCardNotFound
+// (or majicerr.ErrAmbiguous, if the name matches several cards).
+// See also apiStatusError.
+func (sc *scryfallClient) get(ctx context.Context, path string, v url.Values, obj any) error {
+	// Make a copy of the baseURL.
+	u := *sc.baseURL
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	u.RawQuery = v.Encode()
+
+	return sc.getURL(ctx, u.String(), obj)
+}
+
+// getURL is like get,
+// but takes a complete URL.
+// Scryfall responses sometimes include these,
+// like the "next_page" of a list.
+func (sc *scryfallClient) getURL(ctx context.Context, u string, obj any) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("creating scryfall request: %w", err)
+	}
+	resp, err := sc.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("querying scryfall API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		// The response is an error object instead of what we asked for.
+		var errObj errorObj
+		if err := dec.Decode(&errObj); err != nil {
+			return apiStatusError("scryfall", resp.StatusCode, "")
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			if errObj.Type == "ambiguous" {
+				return fmt.Errorf("%s: %w", errObj.Details, majicerr.ErrAmbiguous)
+			}
+			return fmt.Errorf("%s: %w", errObj.Details, majicerr.ErrCardNotFound)
+		}
+		return apiStatusError("scryfall", resp.StatusCode, errObj.Details)
+	}
+
+	if err := dec.Decode(obj); err != nil {
+		return fmt.Errorf("JSON-decoding scryfall response: %w", err)
+	}
+	return nil
+}
+
+// card gets the scryfall information for a card,
+// from the cache if possible.
+// It's a localized card (see localizedCard) if lang is set,
+// or else the cheapest printing (see cheapestCard) if cheapest is true,
+// or else the printing with the given set code and collector number if both are set (see numberedCard),
+// or else the named card in the given set (see namedCard).
+func (sc *scryfallClient) card(ctx context.Context, name, setCode, number, lang string, foil, cheapest bool) (*respObj, error) {
+	// With cheapest,
+	// the foil and nonfoil prices may come from different printings,
+	// so they get different cache entries.
+	var mode string
+	if cheapest {
+		mode = fmt.Sprintf("cheapest,foil=%v", foil)
+	}
+
+	key := cacheKey(name, setCode, number, lang, mode)
+	if obj := sc.cache.get(key); obj != nil {
+		slog.Debug("Using cached scryfall response", "card", name, "set", setCode)
+		return obj, nil
+	}
+
+	if lang == "" && !cheapest && number == "" {
+		if obj, ok := sc.bulk.lookup(name, setCode); ok {
+			return obj, nil
+		}
+	}
+
+	var (
+		obj *respObj
+		err error
+	)
+	switch {
+	case lang != "":
+		obj, err = sc.localizedCard(ctx, name, setCode, lang)
+	case cheapest:
+		obj, err = sc.cheapestCard(ctx, name, foil)
+	case setCode != "" && number != "":
+		obj, err = sc.numberedCard(ctx, name, setCode, number)
+	default:
+		obj, err = sc.namedCard(ctx, name, setCode)
+	}
+	if err != nil {
+		return nil, err
+	}
+	obj.PriceSource, obj.PricedAt = sourceScryfall, time.Now()
+
+	sc.cache.put(key, obj)
+	return obj, nil
+}
+
+// namedCard looks up a card by its exact name,
+// and by its set code if that's not empty.
+//
+// Cards with more than one face
+// (double-faced cards, split cards, adventures, etc.)
+// have names like "Fire // Ice."
+// A sheet might contain just the front face's name,
+// or the full name
+// (perhaps spaced differently, as in "Fire//Ice").
+// So for a name containing "//",
+// namedCard first tries the front face alone
+// and then falls back to the full name.
+// (Scryfall finds a multi-face card by the name of its front face,
+// and the result's Name field is always the full name.)
+//
+// If none of those succeeds,
+// namedCard tries again among scryfall's "extras"
+// (tokens, emblems, art cards, etc.),
+// which the /cards/named endpoint doesn't search.
+// See searchExtras.
+func (sc *scryfallClient) namedCard(ctx context.Context, name, setCode string) (*respObj, error) {
+	setCode = unalias(setCode)
+
+	candidates := []string{name}
+	if front, _, ok := strings.Cut(name, "//"); ok {
+		candidates = []string{strings.TrimSpace(front), normalizeFaces(name)}
+	}
+
+	var err error
+	for _, candidate := range candidates {
+		v := url.Values{}
+		v.Set("exact", candidate)
+		if setCode != "" {
+			v.Set("set", setCode)
+		}
+
+		var obj respObj
+		err = sc.get(ctx, "/cards/named", v, &obj)
+		if err == nil {
+			return &obj, nil
+		}
+		if !errors.Is(err, majicerr.ErrCardNotFound) {
+			return nil, err
+		}
+		slog.Debug("Card not found, trying next form of name", "name", candidate, "set", setCode)
+	}
+
+	return sc.searchExtras(ctx, normalizeFaces(name), setCode)
+}
+
+// numberedCard looks up the printing of a card
+// with the given set code and collector number,
+// for sets with more than one printing of the same card
+// (showcase frames, borderless versions, etc.),
+// where namedCard would find only scryfall's default.
+//
+// It's an error if the card with that number doesn't have the given name
+// (or have it as the name of its front face),
+// since that's probably a mistake in the sheet.
+func (sc *scryfallClient) numberedCard(ctx context.Context, name, setCode, number string) (*respObj, error) {
+	setCode, number = strings.ToLower(unalias(setCode)), strings.TrimSpace(number)
+
+	var obj respObj
+	if err := sc.get(ctx, "/cards/"+url.PathEscape(setCode)+"/"+url.PathEscape(number), nil, &obj); err != nil {
+		return nil, err
+	}
+
+	want := strings.ToLower(normalizeFaces(name))
+	if strings.ToLower(obj.Name) != want && (len(obj.CardFaces) == 0 || strings.ToLower(obj.CardFaces[0].Name) != want) {
+		return nil, fmt.Errorf("card %s in set %s is %s, not %s: %w", number, setCode, obj.Name, name, majicerr.ErrCardNotFound)
+	}
+	return &obj, nil
+}
+
+// setAliases maps set codes that people commonly use
+// to the ones scryfall uses.
+var setAliases = map[string]string{
+	"list":     "plst", // The List reprints.
+	"the list": "plst",
+}
+
+// cheapestCard finds the least expensive printing of the card with the given name,
+// in foil or not.
+// It's for rows with no set code,
+// where /cards/named would return whatever printing scryfall considers the default.
+//
+// Scryfall can sort search results by nonfoil price only,
+// so the cheapest foil is found by looking through them all
+// (or at least the first page of them).
+// Printings with no price in the given finish are ignored,
+// unless none has a price.
+func (sc *scryfallClient) cheapestCard(ctx context.Context, name string, foil bool) (*respObj, error) {
+	prints, err := sc.prints(ctx, normalizeFaces(name))
+	if errors.Is(err, majicerr.ErrCardNotFound) {
+		// The name might be part of the card's full name
+		// (e.g. the front face of a double-faced card).
+		// Get the full name from /cards/named and try again.
+		var obj *respObj
+		obj, err = sc.namedCard(ctx, name, "")
+		if err != nil {
+			return nil, err
+		}
+		prints, err = sc.prints(ctx, obj.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		best      = &prints[0]
+		bestPrice = math.Inf(1)
+	)
+	for i := range prints {
+		if p, ok := parsePrice(prints[i].Prices.price(foil)); ok && p < bestPrice {
+			best, bestPrice = &prints[i], p
+		}
+	}
+	return best, nil
+}
+
+// prints returns the printings of the card with the given full name,
+// cheapest first.
+func (sc *scryfallClient) prints(ctx context.Context, name string) ([]respObj, error) {
+	v := url.Values{}
+	v.Set("q", fmt.Sprintf("!%q", name))
+	v.Set("unique", "prints")
+	v.Set("order", "usd")
+	v.Set("dir", "asc")
+
+	var list listObj
+	if err := sc.get(ctx, "/cards/search", v, &list); err != nil {
+		return nil, err
+	}
+	if len(list.Data) == 0 {
+		return nil, fmt.Errorf("%s: %w", name, majicerr.ErrCardNotFound)
+	}
+	return list.Data, nil
+}
+
+// unalias returns the scryfall set code for one that may be in setAliases.
+func unalias(setCode string) string {
+	if alias, ok := setAliases[strings.ToLower(setCode)]; ok {
+		return alias
+	}
+	return setCode
+}
+
+// searchExtras uses the /cards/search endpoint to look for a card
+// that may be one of scryfall's "extras."
+//
+// Scryfall puts a set's tokens and emblems in a separate set whose code is the original one prefixed with "t"
+// (e.g. "tmh2" for the tokens of "mh2"),
+// and its art cards in one prefixed with "a."
+// A sheet will usually have the code of the main set,
+// so searchExtras looks in all three.
+func (sc *scryfallClient) searchExtras(ctx context.Context, name, setCode string) (*respObj, error) {
+	q := fmt.Sprintf("!%q include:extras", name)
+	if setCode != "" {
+		q += fmt.Sprintf(" (set:%[1]s or set:t%[1]s or set:a%[1]s)", setCode)
+	}
+
+	v := url.Values{}
+	v.Set("q", q)
+
+	var list listObj
+	if err := sc.get(ctx, "/cards/search", v, &list); err != nil {
+		return nil, err
+	}
+	if len(list.Data) == 0 {
+		return nil, fmt.Errorf("%s: %w", q, majicerr.ErrCardNotFound)
+	}
+	return &list.Data[0], nil
+}
+
+// localizedCard looks up the printing of a card in the given language
+// (a code like "ja" or a name like "Japanese"; see languageCode),
+// and in the given set if setCode is not empty.
+// The name is the card's English name.
+// The result's PrintedName field has the name printed on the card.
+//
+// Scryfall's prices are mostly for English printings,
+// so the prices in the result may be empty.
+func (sc *scryfallClient) localizedCard(ctx context.Context, name, setCode, lang string) (*respObj, error) {
+	code, ok := languageCode(lang)
+	if !ok {
+		return nil, fmt.Errorf("unknown language %q", lang)
+	}
+	if code == "en" {
+		return sc.namedCard(ctx, name, setCode)
+	}
+	setCode = unalias(setCode)
+
+	q := fmt.Sprintf("!%q lang:%s", normalizeFaces(name), code)
+	if setCode != "" {
+		q += " set:" + setCode
+	}
+
+	v := url.Values{}
+	v.Set("q", q)
+	v.Set("unique", "prints")
+	v.Set("include_multilingual", "true")
+
+	var list listObj
+	if err := sc.get(ctx, "/cards/search", v, &list); err != nil {
+		return nil, err
+	}
+	if len(list.Data) == 0 {
+		return nil, fmt.Errorf("%s: %w", q, majicerr.ErrCardNotFound)
+	}
+	return &list.Data[0], nil
+}
+
+// languageCode turns the contents of a Language cell into a scryfall language code.
+// It accepts the codes themselves
+// (listed at https://scryfall.com/docs/api/languages)
+// and the English names of the languages.
+// An empty string means English.
+func languageCode(s string) (string, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return "en", true
+	}
+	for code, names := range languages {
+		if s == code {
+			return code, true
+		}
+		for _, name := range names {
+			if s == name {
+				return code, true
+			}
+		}
+	}
+	return "", false
+}
+
+// languages maps scryfall's language codes to the names people might use for them.
+var languages = map[string][]string{
+	"en":  {"english"},
+	"es":  {"spanish", "español"},
+	"fr":  {"french", "français"},
+	"de":  {"german", "deutsch"},
+	"it":  {"italian", "italiano"},
+	"pt":  {"portuguese", "português"},
+	"ja":  {"japanese", "jp"},
+	"ko":  {"korean", "kr"},
+	"ru":  {"russian"},
+	"zhs": {"chinese", "simplified chinese", "cs"},
+	"zht": {"traditional chinese", "ct"},
+	"he":  {"hebrew"},
+	"la":  {"latin"},
+	"grc": {"ancient greek"},
+	"ar":  {"arabic"},
+	"sa":  {"sanskrit"},
+	"ph":  {"phyrexian"},
+}
+
+// normalizeFaces puts the name of a multi-face card into scryfall's canonical form,
+// with the faces separated by " // ".
+func normalizeFaces(name string) string {
+	faces := strings.Split(name, "//")
+	for i, face := range faces {
+		faces[i] = strings.TrimSpace(face)
+	}
+	return strings.Join(faces, " // ")
+}
+
+// This defines a type to contain the information we parse from the /cards/named endpoint.
+// The actual response has many more data fields than the ones we're pulling out here.
+// The complete description is at https://scryfall.com/docs/api/cards.
+type respObj struct {
+	ID              string            `json:"id"` // Scryfall's ID for this printing.
+	Name            string            `json:"name"`
+	PrintedName     string            `json:"printed_name"` // Present only for non-English printings.
+	Prices          pricesObj         `json:"prices"`
+	SetName         string            `json:"set_name"`
+	Rarity          string            `json:"rarity"`
+	CollectorNumber string            `json:"collector_number"`
+	Colors          []string          `json:"colors"`
+	ColorIdentity   []string          `json:"color_identity"`
+	CMC             float64           `json:"cmc"`
+	TypeLine        string            `json:"type_line"`
+	ImageURIs       imageURIs         `json:"image_uris"`
+	CardFaces       []faceObj         `json:"card_faces"`
+	ScryfallURI     string            `json:"scryfall_uri"`
+	PrintsSearchURI string            `json:"prints_search_uri"` // A search for all printings of the card.
+	Set             string            `json:"set"`               // The set code.
+	Legalities      map[string]string `json:"legalities"`        // Maps format name to "legal," "not_legal," etc.
+	OracleText      string            `json:"oracle_text"`
+	Power           string            `json:"power"`
+	Toughness       string            `json:"toughness"`
+	Loyalty         string            `json:"loyalty"`
+	Reserved        bool              `json:"reserved"`    // Whether the card is on the Reserved List.
+	ReleasedAt      string            `json:"released_at"` // The printing's release date, as YYYY-MM-DD.
+
+	// These aren't from scryfall.
+	// The card catalog that produces a respObj
+	// records where its prices came from, and when,
+	// for the Source column
+	// (see priceSource).
+	// They're kept in the response cache along with the rest.
+	PriceSource string    `json:"majic_price_source,omitempty"`
+	PricedAt    time.Time `json:"majic_priced_at,omitempty"`
+}
+
+// This defines the type of the "image_uris" field in a respObj.
+// There are several other sizes;
+// see https://scryfall.com/docs/api/images.
+type imageURIs struct {
+	Normal string `json:"normal"`
+}
+
+// This defines the type of the elements of the "card_faces" field in a respObj,
+// present for cards with more than one face.
+type faceObj struct {
+	Name        string    `json:"name"`
+	PrintedName string    `json:"printed_name"`
+	ImageURIs   imageURIs `json:"image_uris"`
+	OracleText  string    `json:"oracle_text"`
+	Power       string    `json:"power"`
+	Toughness   string    `json:"toughness"`
+	Loyalty     string    `json:"loyalty"`
+}
+
+// This defines a type to contain the information we parse from the /cards/search endpoint.
+// See https://scryfall.com/docs/api/lists.
+type listObj struct {
+	Data       []respObj `json:"data"`
+	TotalCards int       `json:"total_cards"`
+	HasMore    bool      `json:"has_more"`
+	NextPage   string    `json:"next_page"`
+}
+
+// When the scryfall API can't satisfy a request,
+// it responds with one of these instead.
+// See https://scryfall.com/docs/api/errors.
+type errorObj struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Type    string `json:"type"` // E.g. "ambiguous," for a fuzzy name matching several cards.
+	Details string `json:"details"`
+}
+
+// This defines the type of the "prices" field in a respObj.
+type pricesObj struct {
+	USD       string `json:"usd"`
+	USDFoil   string `json:"usd_foil"`
+	USDEtched string `json:"usd_etched"`
+	EUR       string `json:"eur"`
+	EURFoil   string `json:"eur_foil"`
+	TIX       string `json:"tix"` // Magic Online event tickets.
+}
+
+// field returns the price with the given name,
+// which is its name in scryfall's JSON
+// (like "usd_foil").
+// The boolean result is false for an unknown name.
+// See config.PriceColumns.
+func (p pricesObj) field(name string) (string, bool) {
+	switch name {
+	case "usd":
+		return p.USD, true
+	case "usd_foil":
+		return p.USDFoil, true
+	case "usd_etched":
+		return p.USDEtched, true
+	case "eur":
+		return p.EUR, true
+	case "eur_foil":
+		return p.EURFoil, true
+	case "tix":
+		return p.TIX, true
+	}
+	return "", false
+}
+
+// price tells the price of the card in the given finish.
+// Some cards,
+// including many from The List,
+// come only in an "etched" foil finish,
+// so that's the fallback when there's no regular foil price.
+func (p pricesObj) price(foil bool) string {
+	s, _ := p.field(p.priceName(foil))
+	return s
+}
+
+// priceName tells which price price(foil) returns,
+// by its name in scryfall's JSON.
+func (p pricesObj) priceName(foil bool) string {
+	if !foil {
+		return "usd"
+	}
+	if p.USDFoil != "" {
+		return "usd_foil"
+	}
+	return "usd_etched"
+}
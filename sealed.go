@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// A sealedSource prices sealed product
+// (booster boxes, bundles, and so on),
+// for rows with something in the Product ID column.
+// The result has only Prices filled in
+// (with the same price for foil and nonfoil).
+// If there's no such product,
+// the error wraps majicerr.ErrCardNotFound.
+type sealedSource interface {
+	product(ctx context.Context, id string) (*respObj, error)
+}
+
+const tcgplayerAPIBase = "https://api.tcgplayer.com"
+
+// A tcgplayerClient is a sealedSource using the TCGplayer API
+// (documented at https://docs.tcgplayer.com/docs),
+// with the product IDs that appear in TCGplayer URLs
+// (e.g. 12345 in "tcgplayer.com/product/12345/…").
+// The API needs a public and private key pair,
+// which TCGplayer issues to approved developers.
+type tcgplayerClient struct {
+	client                *http.Client // Rate-limited; see main.go.
+	baseURL               string       // Normally tcgplayerAPIBase.
+	publicKey, privateKey string
+	cache                 *responseCache
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// parseTCGplayerKey parses the value of the -tcgplayer-key flag,
+// which is PUBLIC:PRIVATE.
+func parseTCGplayerKey(s string) (public, private string, err error) {
+	public, private, ok := strings.Cut(s, ":")
+	if !ok || public == "" || private == "" {
+		return "", "", fmt.Errorf("TCGplayer key must be PUBLIC:PRIVATE")
+	}
+	return public, private, nil
+}
+
+// bearerToken returns an access token for the TCGplayer API,
+// getting a new one when needed.
+func (tc *tcgplayerClient) bearerToken(ctx context.Context) (string, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.token != "" && time.Now().Before(tc.expires) {
+		return tc.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {tc.publicKey},
+		"client_secret": {tc.privateKey},
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", tc.baseURL+"/token", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating TCGplayer token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("getting TCGplayer token: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("getting TCGplayer token: %w", apiStatusError("TCGplayer", resp.StatusCode, ""))
+	}
+
+	var tok struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"` // Seconds.
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", fmt.Errorf("decoding TCGplayer token: %w", err)
+	}
+
+	// Renew a little early.
+	tc.token = tok.AccessToken
+	tc.expires = time.Now().Add(time.Duration(tok.ExpiresIn)*time.Second - time.Minute)
+	return tc.token, nil
+}
+
+func (tc *tcgplayerClient) product(ctx context.Context, id string) (*respObj, error) {
+	key := cacheKey("tcgplayer", id)
+	if obj := tc.cache.get(key); obj != nil {
+		slog.Debug("Using cached TCGplayer response", "product", id)
+		return obj, nil
+	}
+
+	token, err := tc.bearerToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", tc.baseURL+"/pricing/product/"+url.PathEscape(id), nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating TCGplayer request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := tc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying TCGplayer API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var pricing struct {
+		Success bool     `json:"success"`
+		Errors  []string `json:"errors"`
+		Results []struct {
+			SubTypeName string   `json:"subTypeName"` // "Normal" or "Foil."
+			MarketPrice *float64 `json:"marketPrice"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&pricing); err != nil {
+		return nil, fmt.Errorf("JSON-decoding TCGplayer response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode == http.StatusNotFound || (resp.StatusCode == http.StatusOK && len(pricing.Results) == 0) {
+		return nil, fmt.Errorf("no TCGplayer product %s: %w", id, majicerr.ErrCardNotFound)
+	}
+	if resp.StatusCode != http.StatusOK || !pricing.Success {
+		return nil, apiStatusError("TCGplayer", resp.StatusCode, strings.Join(pricing.Errors, "; "))
+	}
+
+	// Sealed product normally has only a "Normal" price,
+	// but take whatever there is.
+	var price string
+	for _, res := range pricing.Results {
+		if res.MarketPrice == nil {
+			continue
+		}
+		if price == "" || res.SubTypeName == "Normal" {
+			price = fmt.Sprintf("%.2f", *res.MarketPrice)
+		}
+	}
+	obj := &respObj{
+		Prices:      pricesObj{USD: price, USDFoil: price},
+		PriceSource: sourceTCGplayer,
+		PricedAt:    time.Now(),
+	}
+
+	tc.cache.put(key, obj)
+	return obj, nil
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// serve implements the "serve" subcommand.
+// It runs an HTTP server on the given address
+// so that other programs can use majic:
+//
+//	POST /runs    starts a pricing run (or queues one, if a run is in progress)
+//	GET  /history lists past runs as JSON (see history.go)
+//	GET  /cards   looks up a card's price; see cardHandler
+//	POST /webhook prices particular rows right away; see addWebhookHandler
+//
+// A run can be started only from this machine,
+// or with the webhook secret;
+// see mayStartRun.
+// The dashboard (see dashboard.go) is also served, at /.
+// Runs happen one at a time,
+// and card lookups share the response cache and rate limiter with them.
+// The server stops when ctx is canceled.
+func (r *runner) serve(ctx context.Context, addr string, _ []string) error {
+	if r.runNow == nil {
+		r.runNow = make(chan struct{}, 1)
+	}
+
+	mux := http.NewServeMux()
+	addDashboardHandlers(mux, r)
+	mux.HandleFunc("/runs", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		if !r.mayStartRun(req) {
+			writeJSONError(w, http.StatusForbidden, errors.New("runs can be started only from this machine, or with the webhook secret"))
+			return
+		}
+		status := "queued"
+		select {
+		case r.runNow <- struct{}{}:
+		default:
+			status = "already queued"
+		}
+		writeJSON(w, http.StatusAccepted, map[string]string{"status": status})
+	})
+	mux.HandleFunc("/history", func(w http.ResponseWriter, req *http.Request) {
+		runs := r.history.runs()
+		if runs == nil {
+			runs = []historyRun{}
+		}
+		writeJSON(w, http.StatusOK, runs)
+	})
+	mux.HandleFunc("/cards", r.cardHandler)
+	addWebhookHandler(mux, r)
+
+	// Run requested runs one at a time until ctx is canceled.
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-r.runNow:
+				r.runAndLog(ctx)
+			case rr := <-r.rowRequests:
+				r.runRequestedRows(ctx, rr)
+			}
+		}
+	}()
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.WithoutCancel(ctx))
+	}()
+
+	slog.Info("Serving", "addr", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving: %w", err)
+	}
+	return nil
+}
+
+// cardHandler handles GET /cards,
+// which looks up a card with the query parameters
+// name (required),
+// set, number, lang, foil, and cheapest
+// (the last two being "true" or "false"),
+// and responds with a cardResponse.
+func (r *runner) cardHandler(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	name := q.Get("name")
+	if name == "" {
+		writeJSONError(w, http.StatusBadRequest, errors.New("missing name"))
+		return
+	}
+	foil, _ := strconv.ParseBool(q.Get("foil"))
+	cheapest, _ := strconv.ParseBool(q.Get("cheapest"))
+	setCode := q.Get("set")
+
+	obj, err := r.catalogs[magicGame].card(req.Context(), name, setCode, q.Get("number"), q.Get("lang"), foil, cheapest && setCode == "")
+	switch {
+	case errors.Is(err, majicerr.ErrCardNotFound):
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	case errors.Is(err, majicerr.ErrAmbiguous):
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	case errors.Is(err, majicerr.ErrRateLimited):
+		writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	case err != nil:
+		writeJSONError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cardResponse{
+		Name:            obj.Name,
+		Set:             obj.Set,
+		SetName:         obj.SetName,
+		CollectorNumber: obj.CollectorNumber,
+		Foil:            foil,
+		Price:           obj.Prices.price(foil),
+		Prices:          obj.Prices,
+	})
+}
+
+// A cardResponse is the result of GET /cards.
+type cardResponse struct {
+	Name            string    `json:"name"`
+	Set             string    `json:"set"`
+	SetName         string    `json:"set_name"`
+	CollectorNumber string    `json:"collector_number"`
+	Foil            bool      `json:"foil"`
+	Price           string    `json:"price"` // In the requested finish, empty if unknown.
+	Prices          pricesObj `json:"prices"`
+}
+
+// writeJSON writes v as the JSON body of an HTTP response.
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Could not write response", "err", err)
+	}
+}
+
+// writeJSONError writes an error as the JSON body of an HTTP response.
+// If the error is one of the kinds in the majicerr package,
+// the body includes its code too
+// (see majicerr.Code).
+func writeJSONError(w http.ResponseWriter, code int, err error) {
+	body := map[string]string{"error": err.Error()}
+	if c := majicerr.Code(err); c != "" {
+		body["code"] = c
+	}
+	writeJSON(w, code, body)
+}
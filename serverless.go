@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// A serverlessPlatform is a place majic can run as a cloud function,
+// invoked once per run
+// (typically each day by a scheduler,
+// like Google Cloud Scheduler or Amazon EventBridge)
+// instead of on a machine of its own.
+//
+// When majic starts with no subcommand
+// and finds itself on one of these platforms,
+// it hands control to the platform's start function
+// instead of doing a run right away.
+// Everything else is configured as usual,
+// most easily with MAJIC_… environment variables
+// (see envflags.go).
+type serverlessPlatform struct {
+	name string
+
+	// detect tells whether majic is running on this platform.
+	detect func() bool
+
+	// start handles invocations,
+	// calling r.invoke for each,
+	// until ctx is canceled.
+	start func(ctx context.Context, r *runner) error
+}
+
+// serverlessPlatforms are the supported platforms.
+// AWS Lambda is added by lambda.go,
+// but only when majic is built with "-tags lambda,"
+// since it needs AWS's library.
+//
+// Google Cloud Functions can't be among them:
+// it wants Go code as a library package,
+// and majic is a command.
+// But Cloud Functions are built on Cloud Run,
+// which runs majic's container directly;
+// see serveCloudRun.
+var serverlessPlatforms = []serverlessPlatform{{
+	name:   "Cloud Run",
+	detect: func() bool { return os.Getenv("K_SERVICE") != "" },
+	start:  serveCloudRun,
+}}
+
+// detectServerless returns the platform majic is running on,
+// if any.
+func detectServerless() (serverlessPlatform, bool) {
+	for _, p := range serverlessPlatforms {
+		if p.detect() {
+			return p, true
+		}
+	}
+	return serverlessPlatform{}, false
+}
+
+// An invocationResult is the outcome of a run
+// started by a serverless invocation.
+type invocationResult struct {
+	Updated int `json:"updated"`
+	Skipped int `json:"skipped"`
+	Errors  int `json:"errors"`
+}
+
+// invokeMu keeps invocations from overlapping,
+// since a runner does one run at a time.
+var invokeMu sync.Mutex
+
+// invoke does one run for a serverless invocation
+// and reports its outcome.
+// Unlike with the server's POST /runs
+// (see serve.go),
+// it doesn't return until the run is done:
+// serverless platforms may freeze or stop majic
+// once an invocation returns.
+func (r *runner) invoke(ctx context.Context) (invocationResult, error) {
+	invokeMu.Lock()
+	defer invokeMu.Unlock()
+
+	slog.Info("Starting run")
+	err := r.runOnce(ctx)
+	updated, skipped, errored := r.progress.counts()
+	res := invocationResult{Updated: updated, Skipped: skipped, Errors: errored}
+	if err != nil {
+		runsCompleted.WithLabelValues("error").Inc()
+		return res, err
+	}
+	runsCompleted.WithLabelValues("ok").Inc()
+	slog.Info("Run complete", "updated", updated, "skipped", skipped, "errors", errored)
+	return res, nil
+}
+
+// serveCloudRun handles invocations on Google Cloud Run
+// (and so also Cloud Functions, 2nd gen, deployed from a container).
+// Cloud Run sends HTTP requests to the port in $PORT;
+// each POST there does a run,
+// responding when it's done with the result as JSON,
+// or with status 500 if the run fails
+// (so Cloud Scheduler can retry it).
+//
+// There's no authentication here.
+// Deploy the service with --no-allow-unauthenticated
+// and give Cloud Scheduler's service account permission to invoke it.
+func serveCloudRun(ctx context.Context, r *runner) error {
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		res, err := r.invoke(req.Context())
+		if err != nil {
+			slog.Error("Run failed", "err", err)
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+
+	srv := &http.Server{Addr: ":" + port, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.WithoutCancel(ctx))
+	}()
+
+	slog.Info("Waiting for Cloud Run invocations", "port", port)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("serving: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// installService implements the "install-service" subcommand.
+// It sets majic up to run once a day, at the given time,
+// with the same flags it was given
+// (those before the subcommand name),
+// so that unattended operation is a one-time setup:
+//
+//	majic -sheetkey KEY -sheetname Cards install-service -at 04:30
+//
+// On Linux that means a systemd user service and timer;
+// on macOS, a launchd agent.
+// The job runs in the current directory,
+// so relative paths in the flags
+// (like the default creds.json and token.json)
+// keep working.
+// Like other subcommands,
+// this authenticates to Google first,
+// so any authorization happens now
+// instead of stalling the first unattended run.
+//
+// With dryRun,
+// the files are written to the standard output instead of installed.
+func (r *runner) installService(ctx context.Context, at string, dryRun bool, _ []string) error {
+	t, err := time.Parse("15:04", at)
+	if err != nil {
+		return fmt.Errorf("parsing -at time %q (want HH:MM): %w", at, err)
+	}
+	for _, name := range []string{"daemon", "tui"} {
+		if f := flag.Lookup(name); f != nil && f.Value.String() == "true" {
+			return fmt.Errorf("-%s makes no sense in a scheduled run", name)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("finding the majic executable: %w", err)
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("getting current directory: %w", err)
+	}
+
+	// The flags are everything in the command line before the subcommand.
+	args := append([]string{exe}, os.Args[1:len(os.Args)-len(flag.Args())]...)
+
+	svc := serviceInfo{
+		Args:   args,
+		Dir:    dir,
+		Hour:   t.Hour(),
+		Minute: t.Minute(),
+	}
+
+	type serviceFile struct {
+		path string
+		tmpl *template.Template
+	}
+	var (
+		files    []serviceFile
+		commands [][]string // Commands to run after writing the files.
+	)
+	switch runtime.GOOS {
+	case "linux":
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			return fmt.Errorf("finding config directory: %w", err)
+		}
+		unitDir := filepath.Join(configDir, "systemd", "user")
+		files = []serviceFile{
+			{path: filepath.Join(unitDir, "majic.service"), tmpl: systemdServiceTmpl},
+			{path: filepath.Join(unitDir, "majic.timer"), tmpl: systemdTimerTmpl},
+		}
+		commands = [][]string{
+			{"systemctl", "--user", "daemon-reload"},
+			{"systemctl", "--user", "enable", "--now", "majic.timer"},
+		}
+
+	case "darwin":
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return fmt.Errorf("finding home directory: %w", err)
+		}
+		svc.Log, err = dataFile("service.log")
+		if err != nil {
+			return fmt.Errorf("finding data directory: %w", err)
+		}
+		plist := filepath.Join(home, "Library", "LaunchAgents", launchdLabel+".plist")
+		files = []serviceFile{{path: plist, tmpl: launchdPlistTmpl}}
+		domain := fmt.Sprintf("gui/%d", os.Getuid())
+		commands = [][]string{
+			{"launchctl", "bootout", domain + "/" + launchdLabel}, // In case an earlier version is loaded; failure is OK.
+			{"launchctl", "bootstrap", domain, plist},
+		}
+
+	default:
+		return fmt.Errorf("install-service supports only Linux (systemd) and macOS (launchd), not %s", runtime.GOOS)
+	}
+
+	for _, f := range files {
+		path := f.path
+		buf := new(bytes.Buffer)
+		if err := f.tmpl.Execute(buf, svc); err != nil {
+			return fmt.Errorf("generating %s: %w", path, err)
+		}
+		if dryRun {
+			fmt.Printf("# %s\n%s\n", path, buf)
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("creating directory for %s: %w", path, err)
+		}
+		if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+			return fmt.Errorf("writing %s: %w", path, err)
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	if svc.Log != "" && !dryRun {
+		if err := os.MkdirAll(filepath.Dir(svc.Log), 0755); err != nil {
+			return fmt.Errorf("creating directory for %s: %w", svc.Log, err)
+		}
+	}
+
+	for _, c := range commands {
+		if dryRun {
+			fmt.Printf("# then: %s\n", strings.Join(c, " "))
+			continue
+		}
+		cmd := exec.CommandContext(ctx, c[0], c[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+		if err := cmd.Run(); err != nil && c[1] != "bootout" {
+			return fmt.Errorf("running %s: %w", strings.Join(c, " "), err)
+		}
+	}
+	return nil
+}
+
+// serviceInfo is the data for the templates below.
+type serviceInfo struct {
+	Args         []string // The command to run: the majic executable and its flags.
+	Dir          string   // The directory to run it in.
+	Hour, Minute int      // The time of day to run it.
+	Log          string   // Where launchd should send the output.
+}
+
+// launchdLabel names majic's launchd agent.
+const launchdLabel = "com.github.bobg.majic"
+
+var serviceFuncs = template.FuncMap{
+	// systemd parses ExecStart= like a shell command line,
+	// but with its own rules for quoting and for % and $.
+	"systemd": func(args []string) string {
+		var quoted []string
+		for _, arg := range args {
+			arg = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(arg)
+			quoted = append(quoted, `"`+arg+`"`)
+		}
+		return strings.Join(quoted, " ")
+	},
+	"xml": func(s string) (string, error) {
+		buf := new(strings.Builder)
+		err := xml.EscapeText(buf, []byte(s))
+		return buf.String(), err
+	},
+	"label": func() string { return launchdLabel },
+}
+
+var systemdServiceTmpl = template.Must(template.New("").Funcs(serviceFuncs).Parse(`# Generated by "majic install-service."
+[Unit]
+Description=Update card prices with majic
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+Type=oneshot
+WorkingDirectory={{ .Dir }}
+ExecStart={{ systemd .Args }}
+`))
+
+var systemdTimerTmpl = template.Must(template.New("").Funcs(serviceFuncs).Parse(`# Generated by "majic install-service."
+[Unit]
+Description=Update card prices with majic daily
+
+[Timer]
+OnCalendar=*-*-* {{ printf "%02d:%02d" .Hour .Minute }}:00
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`))
+
+var launchdPlistTmpl = template.Must(template.New("").Funcs(serviceFuncs).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!-- Generated by "majic install-service." -->
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{ label }}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args }}
+		<string>{{ xml . }}</string>
+{{- end }}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{ xml .Dir }}</string>
+	<key>StartCalendarInterval</key>
+	<dict>
+		<key>Hour</key>
+		<integer>{{ .Hour }}</integer>
+		<key>Minute</key>
+		<integer>{{ .Minute }}</integer>
+	</dict>
+	<key>StandardOutPath</key>
+	<string>{{ xml .Log }}</string>
+	<key>StandardErrorPath</key>
+	<string>{{ xml .Log }}</string>
+</dict>
+</plist>
+`))
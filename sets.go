@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bobg/majic/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// setCacheMaxAge is how long the list of sets is cached on disk
+// before being fetched again.
+// New sets appear only every few weeks.
+const setCacheMaxAge = 7 * 24 * time.Hour
+
+// A setCatalog holds the list of Magic sets known to scryfall.
+type setCatalog struct {
+	Fetched time.Time `json:"fetched"`
+	Sets    []setObj  `json:"sets"`
+
+	byName map[string]string // Lowercase set name to set code.
+	byCode map[string]bool   // Lowercase set codes.
+}
+
+// This defines a type to contain the information we parse from the /sets endpoint.
+// See https://scryfall.com/docs/api/sets.
+type setObj struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	SetType    string `json:"set_type"`
+	ReleasedAt string `json:"released_at"`
+}
+
+// setCode returns the code of the set with the given name,
+// compared case-insensitively.
+func (c *setCatalog) setCode(name string) (string, bool) {
+	if c.byName == nil {
+		c.byName = make(map[string]string)
+		for _, s := range c.Sets {
+			c.byName[strings.ToLower(s.Name)] = s.Code
+		}
+	}
+	code, ok := c.byName[strings.ToLower(strings.TrimSpace(name))]
+	return code, ok
+}
+
+// setName returns the name of the set with the given code,
+// or the code itself if there's no such set.
+func (c *setCatalog) setName(code string) string {
+	code = unalias(strings.TrimSpace(code))
+	for _, s := range c.Sets {
+		if strings.EqualFold(s.Code, code) {
+			return s.Name
+		}
+	}
+	return code
+}
+
+// hasCode tells whether there's a set with the given code
+// (or an alias for one; see setAliases),
+// compared case-insensitively.
+func (c *setCatalog) hasCode(code string) bool {
+	if c.byCode == nil {
+		c.byCode = make(map[string]bool)
+		for _, s := range c.Sets {
+			c.byCode[strings.ToLower(s.Code)] = true
+		}
+	}
+	return c.byCode[strings.ToLower(unalias(strings.TrimSpace(code)))]
+}
+
+// sets returns the catalog of sets,
+// from the on-disk cache if it's fresh enough
+// and from the scryfall API otherwise.
+// Failure to read or write the cache is not an error.
+func (sc *scryfallClient) sets(ctx context.Context) (*setCatalog, error) {
+	filename, err := cacheFile("sets.json")
+	switch {
+	case sc.offline:
+		filename = ""
+	case err != nil:
+		slog.Debug("No cache for set list", "err", err)
+	default:
+		if cat, err := readSetCatalog(filename); err == nil && time.Since(cat.Fetched) < setCacheMaxAge {
+			return cat, nil
+		}
+	}
+
+	var list struct {
+		Data []setObj `json:"data"`
+	}
+	if err := sc.get(ctx, "/sets", nil, &list); err != nil {
+		return nil, fmt.Errorf("getting set list: %w", err)
+	}
+	cat := &setCatalog{Fetched: time.Now(), Sets: list.Data}
+
+	if filename != "" {
+		if err := cat.write(filename); err != nil {
+			slog.Warn("Could not cache set list", "err", err)
+		}
+	}
+
+	return cat, nil
+}
+
+func readSetCatalog(filename string) (*setCatalog, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cat setCatalog
+	if err := json.NewDecoder(f).Decode(&cat); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return &cat, nil
+}
+
+func (c *setCatalog) write(filename string) error {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", filename, err)
+	}
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", filename, err)
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(c); err != nil {
+		return fmt.Errorf("writing %s: %w", filename, err)
+	}
+	return f.Close()
+}
+
+// checkSetCodes is a pre-pass over the rows of a sheet
+// that finds the ones with set codes scryfall doesn't know
+// (usually typos).
+// Looking those up would fail,
+// or worse,
+// find the wrong printing.
+// If there's a Status column (statusCol >= 0),
+// a warning is written there for each such row.
+// Rows before firstRow
+// (the heading row and any above it)
+// are not checked.
+// The result maps the (zero-based) numbers of those rows to an error describing the problem.
+func (r *runner) checkSetCodes(ctx context.Context, sheetName string, rows [][]any, firstRow, setCodeCol, statusCol int, sets *setCatalog) (map[int]error, error) {
+	var (
+		result  = make(map[int]error)
+		updates []*sheets.ValueRange
+	)
+	for rownum := firstRow; rownum < len(rows); rownum++ {
+		row := rows[rownum]
+		if len(row) <= setCodeCol {
+			continue
+		}
+		setCode, _ := row[setCodeCol].(string)
+		if setCode == "" || sets.hasCode(setCode) {
+			continue
+		}
+		err := fmt.Errorf("unknown set code %q", setCode)
+		result[rownum] = err
+		slog.Warn("Unknown set code", "sheet", sheetName, "row", rownum+1, "set", setCode)
+		if statusCol >= 0 {
+			cell := a1.Cell(sheetName, rownum, statusCol)
+			updates = append(updates, &sheets.ValueRange{Range: cell, Values: [][]any{{err.Error()}}})
+		}
+	}
+
+	if len(updates) > 0 {
+		req := &sheets.BatchUpdateValuesRequest{
+			ValueInputOption: "RAW",
+			Data:             updates,
+		}
+		if err := r.svc.batchUpdateValues(ctx, r.sheetKey, req); err != nil {
+			return nil, fmt.Errorf("writing set-code warnings: %w", err)
+		}
+		sheetWrites.Add(float64(len(updates)))
+	}
+
+	return result, nil
+}
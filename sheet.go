@@ -0,0 +1,1102 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bobg/majic/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// A runner holds the settings and API clients for a run of majic.
+// A single runner is used for all the sheets processed in a run,
+// so they share rate limiters.
+type runner struct {
+	sheetKey      string
+	sheetSpec     string // The value of the -sheetname flag. See resolveSheetNames.
+	cfg           *config
+	createColumns bool
+
+	svc      sheetService // Normally googleSheets; see sheetservice.go.
+	scryfall *scryfallClient
+
+	// The card catalog for each game other than Magic
+	// (whose catalog is scryfall),
+	// and which game each sheet holds.
+	// See game.go.
+	catalogs map[string]cardCatalog
+	games    map[string]string
+
+	// If set, where to get prices for sealed product.
+	// See sealed.go.
+	sealed  sealedSource
+	buylist *buylistClient
+
+	// Whether to write scryfall's form of each card name back to the sheet.
+	canonicalNames bool
+
+	// Whether to price rows with no set code by their cheapest printing.
+	cheapest bool
+
+	// While a run is in progress,
+	// "majic updating…" is written to busyCell if it's set,
+	// and each sheet is protected (with a warning only) if protect is true.
+	// See lock.go.
+	busyCell string
+	protect  bool
+
+	// If positive, each sheet is copied before a run writes to it,
+	// and this many copies of each are kept.
+	// See backup.go.
+	backups int
+
+	// If positive, how long a run may take.
+	// A run that takes longer stops where it is,
+	// as if interrupted,
+	// so a stuck network call can't hang it forever.
+	timeout time.Duration
+
+	// If set, the limit on card-API calls in each run.
+	// When a run reaches it,
+	// processSheet stops and sets budgetStopped.
+	// See budget.go.
+	budget        *lookupBudget
+	budgetStopped bool
+
+	// If positive, the (one-based) number of the row containing column headings.
+	// Otherwise it's found automatically; see findHeaderRow.
+	headerRow int
+
+	// If set, only this part of each sheet is processed.
+	// It's the value of the -range flag,
+	// from which dataRange is computed at the start of each run.
+	// See resolveRange.
+	rangeSpec string
+	dataRange *a1.Range
+
+	// Whether to stop processing a sheet at the first blank row.
+	stopAtBlank bool
+
+	// Whether to add notes to the card names of rows that couldn't be priced,
+	// saying why.
+	// See setErrorNotes.
+	errorNotes bool
+
+	// Whether to process the rows that were updated longest ago first,
+	// instead of from top to bottom.
+	// See rowOrder.
+	stalestFirst bool
+
+	// If set, only rows matching this are processed.
+	filter *rowFilter
+
+	// If set, the full-screen display for -tui.
+	// See tui.go.
+	tui *tui
+
+	// If set, each sheet is sorted by these columns after its prices are updated.
+	// See sort.go.
+	sortKeys []sortKey
+
+	// Whether to check set codes against scryfall's list of sets before processing a sheet.
+	validateSets bool
+
+	// Rows updated less than maxAge ago are skipped
+	// (unless force is true).
+	// The cutoff time is computed from maxAge at the start of each run.
+	maxAge time.Duration
+	force  bool
+	cutoff time.Time
+
+	// If set, a number-format pattern to apply to the price column.
+	currencyFormat string
+
+	// How to write prices.
+	priceFormat priceFormat
+
+	metadataOpts metadataOpts
+
+	// Whether to add conditional-formatting rules to the price column
+	// highlighting increases and decreases.
+	changeRules bool
+
+	// If set, price cells that moved at least this much in a run
+	// get a green or red background.
+	highlightThreshold *alertThreshold
+
+	progress *progress
+
+	reportFile string     // If set, where to write a JSON report of each run.
+	report     *runReport // The report for the current run, if reportFile is set.
+
+	alertThreshold *alertThreshold // If set, price moves at least this big trigger alerts.
+	alertSinks     []alertSink     // Where to send alerts.
+	notifier       *notifier       // If set, where to post a summary of each run.
+
+	// If set, where each run is recorded.
+	// See history.go.
+	history *history
+
+	// If set, the work queue for processing rows;
+	// see queue.go.
+	queue *rowQueue
+
+	// If set, where the cells changed by each run are recorded,
+	// with their old values.
+	// See undo.go.
+	undo *undoLog
+
+	// If set, the name of a sheet in which to keep a chart of the collection's value over time.
+	// See chart.go.
+	chartSheet string
+
+	// In daemon mode,
+	// a send on this starts the next run right away.
+	// See dashboard.go.
+	runNow chan struct{}
+
+	// If webhookSecret is set,
+	// requests to price particular rows can arrive on rowRequests
+	// (in daemon or server mode).
+	// While those rows are being priced,
+	// only holds their numbers by sheet name.
+	// See webhook.go.
+	webhookSecret string
+	rowRequests   chan rowRequest
+	only          map[string]map[int]bool
+
+	// These are collected during each run for alerts, notifications, and the history.
+	changes                 []priceChange
+	valueBefore, valueAfter float64
+	rowErrors               []historyRowError
+}
+
+// runOnce updates the prices in all the sheets selected by r.sheetSpec.
+func (r *runner) runOnce(ctx context.Context) (err error) {
+	// This is a value representing the moment in time maxAge earlier than right now
+	// (by default, one day).
+	// We'll use it to skip rows that have been updated more recently.
+	// The scryfall API docs ask that we not query the price of the same card more than once per day.
+	r.cutoff = time.Now().Add(-r.maxAge)
+
+	r.progress.reset()
+	r.changes = nil
+	r.valueBefore, r.valueAfter = 0, 0
+	r.rowErrors = nil
+	r.budget.reset()
+	r.budgetStopped = false
+	limiterWaits.reset()
+	defer limiterWaits.log()
+	r.undo.start(r.sheetKey)
+	defer func() {
+		if err := r.undo.finish(); err != nil {
+			slog.Warn("Could not save undo log", "err", err)
+		}
+	}()
+	defer r.refreshChart(ctx) // Deferred first so it runs after recordRun.
+	defer r.recordRun(time.Now(), &err)
+	defer r.sendAlerts(ctx)
+	defer r.notify(ctx)
+	defer func() {
+		if err := r.scryfall.cache.save(); err != nil {
+			slog.Warn("Could not save response cache", "err", err)
+		}
+	}()
+
+	// The deferred calls above use the original ctx,
+	// so a timeout doesn't keep them from finishing up.
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+		defer func() {
+			if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				updated, skipped, errored := r.progress.counts()
+				err = fmt.Errorf("timed out after %s with %d row(s) updated, %d skipped, %d errors", r.timeout, updated, skipped, errored)
+			}
+		}()
+	}
+
+	if r.reportFile != "" {
+		// Write the report at the end of the run,
+		// even if the run fails or is interrupted partway through.
+		r.report = newRunReport()
+		defer func() {
+			if err := r.report.write(r.reportFile); err != nil {
+				slog.Error("Could not write report", "err", err)
+			}
+		}()
+	}
+
+	if r.busyCell != "" {
+		unmark, err := r.markBusy(ctx)
+		if err != nil {
+			return err
+		}
+		defer unmark()
+	}
+
+	// Process each of the selected sheets in turn.
+	// They all share the same runner,
+	// and so the same rate-limited API clients.
+	sheetNames, err := r.targetSheets(ctx)
+	if err != nil {
+		return err
+	}
+	if r.backups > 0 {
+		if err := r.backupSheets(ctx, sheetNames); err != nil {
+			return fmt.Errorf("backing up: %w", err)
+		}
+	}
+	for _, name := range sheetNames {
+		if r.budgetStopped {
+			break
+		}
+		err = r.processSheet(ctx, name)
+		if err != nil {
+			return fmt.Errorf("processing sheet %q: %w", name, err)
+		}
+	}
+	if r.budgetStopped {
+		slog.Info("Stopped at the -max-lookups limit; later runs will price the remaining rows", "lookups", r.budget.max)
+	}
+
+	return nil
+}
+
+// recordRun adds the run that began at start to the history.
+// If *errp is non-nil,
+// the run failed.
+func (r *runner) recordRun(start time.Time, errp *error) {
+	updated, skipped, errored := r.progress.counts()
+	run := historyRun{
+		Start:     start,
+		End:       time.Now(),
+		Value:     r.valueAfter,
+		Updated:   updated,
+		Skipped:   skipped,
+		Errors:    errored,
+		RowErrors: r.rowErrors,
+		Partial:   r.only != nil || r.budgetStopped,
+	}
+	if *errp != nil {
+		run.Err = (*errp).Error()
+	}
+	if err := r.history.add(run); err != nil {
+		slog.Warn("Could not record run in history", "err", err)
+	}
+}
+
+// targetSheets returns the names of the sheets to operate on.
+// The -sheetname flag may name several sheets,
+// or use wildcards;
+// see resolveSheetNames.
+// A named range given with -range determines the sheet by itself.
+// This also sets r.dataRange from -range.
+func (r *runner) targetSheets(ctx context.Context) ([]string, error) {
+	sheetSpec := r.sheetSpec
+	r.dataRange = nil
+	if r.only != nil {
+		return r.onlySheets(), nil
+	}
+	if r.rangeSpec != "" {
+		sheetName, g, err := r.resolveRange(ctx, r.rangeSpec)
+		if err != nil {
+			return nil, err
+		}
+		r.dataRange = &g
+		if sheetName != "" {
+			if sheetSpec != "" && sheetSpec != sheetName {
+				return nil, fmt.Errorf("named range %q is in sheet %q, not %q", r.rangeSpec, sheetName, sheetSpec)
+			}
+			sheetSpec = sheetName
+		}
+	}
+	return r.resolveSheetNames(ctx, sheetSpec)
+}
+
+// sendAlerts sends alerts for the price changes collected during a run
+// that exceed the alert threshold.
+// Failures are logged but are otherwise not fatal.
+func (r *runner) sendAlerts(ctx context.Context) {
+	var alerts []priceChange
+	for _, c := range r.changes {
+		if c.Watch != "" {
+			slog.Info("Price reached watch target", "card", c.Card, "set", c.Set, "price", c.NewPrice, "watch", c.Watch)
+			alerts = append(alerts, c)
+		} else if r.alertThreshold != nil && r.alertThreshold.exceeded(c) {
+			alerts = append(alerts, c)
+		}
+	}
+	if len(alerts) == 0 {
+		return
+	}
+
+	// Send alerts even if the run was interrupted.
+	ctx = context.WithoutCancel(ctx)
+
+	for _, sink := range r.alertSinks {
+		if err := sink.send(ctx, alerts); err != nil {
+			slog.Error("Could not send price alerts", "err", err)
+		}
+	}
+}
+
+// notify posts a summary of the run to the notification webhook, if there is one.
+// Failure is logged but is otherwise not fatal.
+func (r *runner) notify(ctx context.Context) {
+	if r.notifier == nil {
+		return
+	}
+
+	updated, skipped, errored := r.progress.counts()
+	s := runSummary{
+		updated:     updated,
+		skipped:     skipped,
+		errored:     errored,
+		valueBefore: r.valueBefore,
+		valueAfter:  r.valueAfter,
+		changes:     r.changes,
+	}
+	if err := r.notifier.notify(context.WithoutCancel(ctx), s); err != nil {
+		slog.Error("Could not post run summary", "err", err)
+	}
+}
+
+// addValue adds a row's prices before and after processing
+// to the run's collection-value totals.
+// Proxies don't count.
+func (r *runner) addValue(res rowResult, err error) {
+	if res.cardName == "" || res.proxy {
+		return
+	}
+	oldPrice, _ := parsePrice(res.oldPrice)
+	r.valueBefore += oldPrice
+	if res.updated && err == nil {
+		newPrice, _ := parsePrice(res.newPrice)
+		r.valueAfter += newPrice
+	} else {
+		r.valueAfter += oldPrice
+	}
+}
+
+// rowOrder returns the numbers of the rows to process,
+// from headerRow+1 up to (but not including) endRow.
+// Normally they're in order from top to bottom,
+// but with r.stalestFirst,
+// they're in order by their Last updated times,
+// oldest first.
+// Rows that have never been updated
+// (or whose times can't be parsed,
+// including rows forced with "!")
+// come before all the others,
+// in their original order.
+// That way,
+// when a run can't get through the whole sheet
+// (see -max-lookups and -timeout),
+// the most out-of-date prices are the ones that get refreshed.
+func (r *runner) rowOrder(rows [][]any, headerRow, endRow, lastUpdatedCol int) []int {
+	var result []int
+	for rownum := headerRow + 1; rownum < endRow; rownum++ {
+		result = append(result, rownum)
+	}
+	if !r.stalestFirst {
+		return result
+	}
+
+	lastUpdated := func(rownum int) time.Time {
+		row := rows[rownum]
+		if len(row) <= lastUpdatedCol {
+			return time.Time{}
+		}
+		s, _ := row[lastUpdatedCol].(string)
+		t, _ := time.Parse(time.RFC3339, s) // The zero time if s can't be parsed.
+		return t
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return lastUpdated(result[i]).Before(lastUpdated(result[j]))
+	})
+	return result
+}
+
+// resolveSheetNames turns the value of the -sheetname flag into a list of sheet names.
+// The flag may be a comma-separated list,
+// each of whose elements is a sheet name or a glob pattern
+// (see https://pkg.go.dev/path#Match).
+// An element may also be "gid=N,"
+// naming a sheet by the numeric ID that appears in its URL.
+// The special value "all" means all the sheets in the spreadsheet.
+// An empty string means just the first sheet.
+func (r *runner) resolveSheetNames(ctx context.Context, spec string) ([]string, error) {
+	if spec == "" {
+		return []string{""}, nil
+	}
+
+	var (
+		patterns = strings.Split(spec, ",")
+		needList bool
+	)
+	for i, p := range patterns {
+		p = strings.TrimSpace(p)
+		patterns[i] = p
+		if p == "all" || strings.HasPrefix(p, "gid=") || strings.ContainsAny(p, "*?[") {
+			needList = true
+		}
+	}
+	if !needList {
+		// Plain sheet names only.
+		// No need to ask the API what sheets exist.
+		return patterns, nil
+	}
+
+	ss, err := r.svc.get(ctx, r.sheetKey, "sheets.properties(sheetId,title)")
+	if err != nil {
+		return nil, fmt.Errorf("listing sheets: %w", err)
+	}
+
+	var (
+		result []string
+		seen   = make(map[string]bool)
+	)
+	for _, p := range patterns {
+		var matched bool
+		for _, sh := range ss.Sheets {
+			title := sh.Properties.Title
+			if (title == r.chartSheet || strings.HasPrefix(title, backupPrefix)) && p != title {
+				// The chart sheet and backups (see backup.go) aren't for pricing.
+				continue
+			}
+			ok := p == "all" || p == title || p == fmt.Sprintf("gid=%d", sh.Properties.SheetId)
+			if !ok && !strings.HasPrefix(p, "gid=") {
+				ok, err = path.Match(p, title)
+				if err != nil {
+					return nil, fmt.Errorf("in sheet-name pattern %q: %w", p, err)
+				}
+			}
+			if !ok {
+				continue
+			}
+			matched = true
+			if !seen[title] {
+				seen[title] = true
+				result = append(result, title)
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no sheet matches %q", p)
+		}
+	}
+	return result, nil
+}
+
+// readSheet reads the full contents of the sheet with the given name,
+// with values formatted as they appear in the sheet.
+func (r *runner) readSheet(ctx context.Context, sheetName string) (*sheets.ValueRange, error) {
+	return r.readSheetAs(ctx, sheetName, "FORMATTED_VALUE")
+}
+
+// readSheetAs reads the full contents of the sheet with the given name,
+// rendered according to renderOption
+// ("FORMATTED_VALUE" or "FORMULA").
+func (r *runner) readSheetAs(ctx context.Context, sheetName, renderOption string) (*sheets.ValueRange, error) {
+	rangeName := a1.SheetRange(sheetName).String()
+	if sheetName == "" {
+		// The first sheet.
+		// The API needs some range,
+		// so this is all the columns a sheet can have.
+		rangeName = "A:ZZZ"
+	}
+	resp, err := r.svc.getValues(ctx, r.sheetKey, rangeName, renderOption)
+	if err != nil {
+		return nil, fmt.Errorf("reading spreadsheet data: %w", err)
+	}
+	return resp, nil
+}
+
+// layout finds the parts of a sheet's contents to operate on.
+// It returns the rows of the sheet,
+// the number of the row containing column headings
+// (normally row 0; see findHeaderRow),
+// and the number of the row after the last one to process.
+//
+// With -range,
+// only part of the sheet is used.
+// Everything outside it is blank in the returned rows.
+//
+// Rows are processed to the end of the sheet,
+// or with -stop-at-blank,
+// up to the first blank row
+// (so that notes or totals below the list of cards are left alone).
+func (r *runner) layout(values [][]any) (rows [][]any, headerRow, endRow int, err error) {
+	rows = values
+	if r.dataRange != nil {
+		rows = restrictRows(*r.dataRange, rows)
+	}
+	headerRow, err = r.findHeaderRow(rows)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	endRow = len(rows)
+	if r.stopAtBlank {
+		for rownum := headerRow + 1; rownum < len(rows); rownum++ {
+			if isBlankRow(rows[rownum]) {
+				endRow = rownum
+				break
+			}
+		}
+	}
+
+	return rows, headerRow, endRow, nil
+}
+
+// maxHeaderScan is how many rows findHeaderRow looks through
+// for column headings.
+const maxHeaderScan = 10
+
+// findHeaderRow returns the (zero-based) number of the row containing column headings.
+// That's the one given with -header-row, if any.
+// Otherwise it's the first row with a card-name heading,
+// which is normally row 0,
+// but may be lower if the sheet has a title or other decoration at the top.
+// The search starts at the first non-blank row
+// (which with -range may be well down the sheet).
+func (r *runner) findHeaderRow(rows [][]any) (int, error) {
+	if r.headerRow > 0 {
+		if r.headerRow > len(rows) {
+			return 0, fmt.Errorf("header row %d is past the end of the sheet", r.headerRow)
+		}
+		return r.headerRow - 1, nil
+	}
+
+	start := 0
+	for start < len(rows) && isBlankRow(rows[start]) {
+		start++
+	}
+
+	heading := strings.ToLower(r.cfg.heading(cardNameField))
+	for i := start; i < len(rows) && i < start+maxHeaderScan; i++ {
+		if _, ok := headingCols(rows[i])[heading]; ok {
+			return i, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no %q column", r.cfg.heading(cardNameField))
+}
+
+// headingCols maps the lowercased headings in a sheet's heading row to column numbers.
+func headingCols(row []any) map[string]int {
+	result := make(map[string]int)
+	for i, raw := range row {
+		if heading, ok := raw.(string); ok {
+			result[strings.ToLower(heading)] = i
+		}
+	}
+	return result
+}
+
+// processSheet updates the prices in the sheet with the given name.
+func (r *runner) processSheet(ctx context.Context, sheetName string) error {
+	if r.protect {
+		unprotect, err := r.protectSheet(ctx, sheetName)
+		if err != nil {
+			return err
+		}
+		defer unprotect()
+	}
+
+	// Request the full contents of the sheet.
+	resp, err := r.readSheet(ctx, sheetName)
+	if err != nil {
+		return err
+	}
+	if len(resp.Values) == 0 {
+		return fmt.Errorf("zero rows in spreadsheet")
+	}
+
+	// Find the row containing the column headings
+	// and the rows after it to process.
+	// See layout.
+	rows, headerRow, endRow, err := r.layout(resp.Values)
+	if err != nil {
+		return err
+	}
+
+	// Let's read those column headings and map them to column numbers;
+	// e.g. "card name" -> 0, "set code" -> 1, etc.
+	columnHeadings := headingCols(rows[headerRow])
+
+	// Let's pull out the column numbers, by name,
+	// of the columns we'll care about when constructing scryfall-API queries.
+	// The heading for each field can be changed in the config,
+	// so we look each one up through cfg.
+	//
+	// If a column is missing and -create-columns was given,
+	// we add its heading to the end of the heading row and use that new column.
+	// (This doesn't work for the card-name column,
+	// since without card names there's nothing to do.)
+	nextCol := len(rows[headerRow])
+	findCol := func(field string) (int, error) {
+		heading := r.cfg.heading(field)
+		if col, ok := columnHeadings[strings.ToLower(heading)]; ok {
+			return col, nil
+		}
+		if !r.createColumns || field == cardNameField {
+			return 0, fmt.Errorf("no %q column", heading)
+		}
+
+		col := nextCol
+		nextCol++
+
+		cell := a1.Cell(sheetName, headerRow, col)
+		vr := &sheets.ValueRange{Range: cell, Values: [][]any{{heading}}}
+		err := r.svc.updateValues(ctx, r.sheetKey, cell, vr, "RAW")
+		if err != nil {
+			return 0, fmt.Errorf("adding %q heading in cell %s: %w", heading, cell, err)
+		}
+		sheetWrites.Inc()
+		columnHeadings[strings.ToLower(heading)] = col
+		return col, nil
+	}
+
+	// optionalCol is like findCol,
+	// but for columns that needn't exist.
+	// It returns -1 for a missing column.
+	optionalCol := func(field string) int {
+		if col, ok := columnHeadings[strings.ToLower(r.cfg.heading(field))]; ok {
+			return col
+		}
+		return -1
+	}
+
+	cardNameCol, err := findCol(cardNameField)
+	if err != nil {
+		return err
+	}
+
+	// A Set name column can take the place of the Set code column.
+	// Set names are turned into codes with the list of sets from scryfall.
+	var (
+		setCodeCol = optionalCol(setCodeField)
+		setNameCol = optionalCol(setNameField)
+		sets       *setCatalog
+	)
+	if setCodeCol < 0 && setNameCol < 0 {
+		setCodeCol, err = findCol(setCodeField)
+		if err != nil {
+			return err
+		}
+	}
+	game := r.gameFor(sheetName)
+	if game != magicGame {
+		// Scryfall's list of sets is only for Magic.
+		if setCodeCol < 0 {
+			return fmt.Errorf("sheet %q needs a %q column (set names are supported only for Magic)", sheetName, r.cfg.heading(setCodeField))
+		}
+		setNameCol = -1
+	}
+
+	// Add rows for the results of any scryfall searches in the Card name column,
+	// then read the sheet again to get them.
+	// See query.go.
+	if game == magicGame {
+		t := &table{
+			sheetName:      sheetName,
+			orig:           resp.Values,
+			rows:           rows,
+			headerRow:      headerRow,
+			endRow:         endRow,
+			headings:       make([]any, nextCol), // Including any columns added by findCol. Only the length matters here.
+			columnHeadings: columnHeadings,
+			cfg:            r.cfg,
+		}
+		added, err := r.expandQueries(ctx, t)
+		if err != nil {
+			return err
+		}
+		if added > 0 {
+			resp, err = r.readSheet(ctx, sheetName)
+			if err != nil {
+				return err
+			}
+			rows, headerRow, endRow, err = r.layout(resp.Values)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if setNameCol >= 0 || (r.validateSets && setCodeCol >= 0 && game == magicGame) {
+		sets, err = r.scryfall.sets(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// With -validate-sets,
+	// find rows with bad set codes before doing anything else.
+	// See sets.go.
+	statusCol := optionalCol(statusField)
+	var badSets map[int]error
+	if r.validateSets && setCodeCol >= 0 && game == magicGame {
+		badSets, err = r.checkSetCodes(ctx, sheetName, rows, headerRow+1, setCodeCol, statusCol, sets)
+		if err != nil {
+			return err
+		}
+	}
+
+	foilCol, err := findCol(foilField)
+	if err != nil {
+		return err
+	}
+	lastUpdatedCol, err := findCol(lastUpdatedField)
+	if err != nil {
+		return err
+	}
+	priceCol, err := findCol(priceField)
+	if err != nil {
+		return err
+	}
+
+	// The Previous price column is optional,
+	// unless it's needed for highlighting price changes.
+	// In that case it can be created like the others.
+	previousPriceCol := optionalCol(previousPriceField)
+	if r.changeRules && previousPriceCol < 0 {
+		previousPriceCol, err = findCol(previousPriceField)
+		if err != nil {
+			return fmt.Errorf("highlighting price changes: %w", err)
+		}
+	}
+
+	// The -filter expression refers to columns by name.
+	// Find their numbers.
+	var filter *rowFilter
+	if r.filter != nil {
+		filter, err = r.filter.bind(func(field string) (int, bool) {
+			col, ok := columnHeadings[strings.ToLower(r.cfg.heading(field))]
+			return col, ok
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// If there's a Buylist column,
+	// get the buylist for filling it in.
+	// See buylist.go.
+	var (
+		buylistCol = optionalCol(buylistField)
+		buylist    *buylistPrices
+	)
+	if buylistCol >= 0 {
+		buylist, err = r.buylist.prices(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	// If there's a Reprint risk column,
+	// get the cards in upcoming sets.
+	// See reprint.go.
+	var (
+		reprintRiskCol = optionalCol(reprintRiskField)
+		upcoming       upcomingPrintings
+	)
+	if reprintRiskCol >= 0 && game == magicGame {
+		upcoming, err = r.scryfall.upcomingPrintings(ctx)
+		if err != nil {
+			return err
+		}
+	} else {
+		reprintRiskCol = -1
+	}
+
+	// Find whatever metadata columns the sheet has.
+	metadataCols := make(map[int]metadataField)
+	for _, f := range metadataFields(r.metadataOpts) {
+		if col := optionalCol(f.name); col >= 0 {
+			metadataCols[col] = f
+		}
+	}
+
+	// Find the other price columns:
+	// Foil price and Etched price,
+	// and the ones named in the config's price_columns.
+	var (
+		priceCols  = make(map[int]string)
+		priceField string
+	)
+	if col := optionalCol(foilPriceField); col >= 0 {
+		priceCols[col] = "usd_foil"
+	}
+	if col := optionalCol(etchedPriceField); col >= 0 {
+		priceCols[col] = "usd_etched"
+	}
+	for heading, field := range r.cfg.PriceColumns {
+		col, ok := columnHeadings[strings.ToLower(heading)]
+		switch {
+		case !ok:
+			continue
+		case col == priceCol:
+			priceField = field
+		default:
+			priceCols[col] = field
+		}
+	}
+
+	// Find the computed columns the sheet has.
+	// See transform.go.
+	var computed []computedColumn
+	for _, c := range r.cfg.computed {
+		c, ok, err := c.bind(func(field string) (int, bool) {
+			col, ok := columnHeadings[strings.ToLower(r.cfg.heading(field))]
+			return col, ok
+		})
+		if err != nil {
+			return err
+		}
+		if ok {
+			computed = append(computed, c)
+		}
+	}
+
+	rh := rowHandler{
+		sheetName: sheetName,
+		rows:      rows,
+
+		cardNameCol:    cardNameCol,
+		setCodeCol:     setCodeCol,
+		foilCol:        foilCol,
+		lastUpdatedCol: lastUpdatedCol,
+		priceCol:       priceCol,
+
+		setNameCol:         setNameCol,
+		statusCol:          statusCol,
+		forceCol:           optionalCol(forceField),
+		conditionCol:       optionalCol(conditionField),
+		collectorNumberCol: optionalCol(collectorNumberField),
+		languageCol:        optionalCol(languageField),
+		previousPriceCol:   previousPriceCol,
+		watchCol:           optionalCol(watchField),
+		buylistCol:         buylistCol,
+		reprintRiskCol:     reprintRiskCol,
+		sourceCol:          optionalCol(sourceField),
+		productIDCol:       optionalCol(productIDField),
+		proxyCol:           optionalCol(proxyField),
+		purchasePriceCol:   optionalCol(purchasePriceField),
+		gainLossCol:        optionalCol(gainLossField),
+		gainLossPercentCol: optionalCol(gainLossPercentField),
+		metadataCols:       metadataCols,
+		priceCols:          priceCols,
+		priceField:         priceField,
+		computed:           computed,
+
+		sets:     sets,
+		buylist:  buylist,
+		upcoming: upcoming,
+		badSets:  badSets,
+		filter:   filter,
+
+		catalog: r.catalogFor(sheetName),
+		sealed:  r.sealed,
+
+		cfg:            r.cfg,
+		cutoff:         r.cutoff,
+		force:          r.force || r.only != nil,
+		cheapest:       r.cheapest,
+		priceFormat:    r.priceFormat,
+		canonicalNames: r.canonicalNames,
+		progress:       r.progress,
+	}
+
+	total := endRow - headerRow - 1
+	if r.only != nil {
+		total = len(r.only[sheetName])
+	}
+	r.progress.startSheet(sheetName, total)
+	defer r.progress.finishSheet()
+
+	// Background colors for the price cells of updated rows,
+	// by row number,
+	// when r.highlightThreshold is set.
+	// A nil color clears the background.
+	highlights := make(map[int]*sheets.Color)
+
+	// Notes for the card-name cells of rows that couldn't be priced,
+	// by row number,
+	// when r.errorNotes is set.
+	// An empty note removes one left by an earlier run.
+	// Notes that people have added
+	// (found in oldNotes without errorNotePrefix)
+	// are left alone.
+	// See format.go.
+	var (
+		notes    = make(map[int]string)
+		oldNotes map[int]string
+	)
+	if r.errorNotes {
+		oldNotes, err = r.cellNotes(ctx, sheetName, cardNameCol)
+		if err != nil {
+			return err
+		}
+	}
+	ownNote := func(rownum int) bool {
+		old, ok := oldNotes[rownum]
+		return !ok || strings.HasPrefix(old, errorNotePrefix)
+	}
+
+	// The rows to process, in order.
+	// With -queue,
+	// they come from the queue.
+	// See queue.go.
+	order := func() []int { return r.rowOrder(rows, headerRow, endRow, lastUpdatedCol) }
+	var rownums []int
+	if r.queue != nil && r.only == nil {
+		rownums, err = r.queue.rows(r.sheetKey, sheetName, len(rows), order)
+		if err != nil {
+			return err
+		}
+	} else {
+		rownums = order()
+	}
+
+	// Process the rows through the pipeline.
+	// This loop is its record stage.
+	// See pipeline.go.
+	//
+	// Stop early if ctx is canceled
+	// (e.g. by Ctrl-C)
+	// or there's an error that isn't a rowError
+	// (or is one that abortsRun),
+	// but write the updates collected so far in any case.
+	var (
+		pipeline = r.startPipeline(ctx, rh, rownums)
+		writer   = r.startRowWriter(ctx, sheetName, resp.Values)
+		loopErr  error
+	)
+	for lr := range pipeline.results {
+		start := time.Now()
+		rownum, res, err := lr.rownum, lr.res, lr.err
+
+		if r.report != nil {
+			r.report.add(sheetName, rownum, res, err)
+		}
+		var rowUpdates []cellUpdate
+		if rh.statusCol >= 0 && !abortsRun(err) {
+			if status := rowStatus(res, err, time.Now()); status != "" {
+				rowUpdates = append(rowUpdates, cellUpdate{row: rownum, col: rh.statusCol, val: status})
+			}
+		}
+		if r.tui != nil {
+			r.tui.row(sheetName, rownum, res, err)
+		}
+		r.addValue(res, err)
+
+		var rerr rowError
+		switch {
+		case abortsRun(err):
+			// Every row after this one would fail the same way.
+			// Stop here
+			// (but still write the updates collected so far).
+			r.progress.rowErrored()
+			loopErr = fmt.Errorf("in row %d: %w", rownum+1, err)
+		case errors.As(err, &rerr):
+			// This error affects only this row.
+			// Note it and keep going.
+			slog.Warn("Could not process row", "sheet", sheetName, "row", rownum+1, "err", err)
+			r.progress.rowErrored()
+			if len(r.rowErrors) < maxHistoryRowErrors {
+				r.rowErrors = append(r.rowErrors, historyRowError{Sheet: sheetName, Row: rownum + 1, Card: res.cardName, Err: err.Error()})
+			}
+			if r.errorNotes && ownNote(rownum) {
+				notes[rownum] = errorNotePrefix + err.Error()
+			}
+		case err != nil:
+			loopErr = fmt.Errorf("in row %d: %w", rownum+1, err)
+		case res.updated:
+			rowUpdates = append(rowUpdates, res.updates...)
+			r.progress.rowUpdated()
+			if old := oldNotes[rownum]; old != "" && ownNote(rownum) {
+				notes[rownum] = ""
+			}
+			c, ok := priceChangeFor(sheetName, rownum, res)
+			if ok {
+				r.changes = append(r.changes, c)
+			}
+			if r.highlightThreshold != nil {
+				highlights[rownum] = nil
+				if ok && r.highlightThreshold.exceeded(c) {
+					if c.diff() > 0 {
+						highlights[rownum] = priceUpColor
+					} else {
+						highlights[rownum] = priceDownColor
+					}
+				}
+			}
+		default:
+			r.progress.rowSkipped()
+		}
+		writer.add(rownum, rowUpdates, loopErr == nil)
+		observeStage("record", start)
+		if loopErr != nil {
+			break
+		}
+	}
+	pipeline.finish()
+	r.budgetStopped = r.budgetStopped || pipeline.budgetStopped
+	if loopErr == nil {
+		loopErr = ctx.Err()
+	}
+
+	// Write the new values,
+	// even if ctx has been canceled,
+	// so the work done so far isn't lost.
+	// See updates.go.
+	if err := writer.close(); err != nil {
+		return err
+	}
+	if loopErr != nil {
+		return loopErr
+	}
+
+	// Now that the prices are updated,
+	// apply any requested formatting to the price column.
+	// See format.go.
+	if r.currencyFormat != "" {
+		if err := r.formatPriceColumn(ctx, sheetName, priceCol); err != nil {
+			return err
+		}
+	}
+	if r.changeRules {
+		if err := r.addChangeRules(ctx, sheetName, priceCol, previousPriceCol); err != nil {
+			return err
+		}
+	}
+	if len(highlights) > 0 {
+		if err := r.highlightPrices(ctx, sheetName, priceCol, highlights); err != nil {
+			return err
+		}
+	}
+	if len(notes) > 0 {
+		if err := r.setErrorNotes(ctx, sheetName, cardNameCol, notes); err != nil {
+			return err
+		}
+	}
+
+	// Last of all,
+	// since it moves rows around,
+	// sort the sheet if requested.
+	if len(r.sortKeys) > 0 && endRow > headerRow+1 {
+		if err := r.sortSheet(ctx, sheetName, headerRow, endRow, columnHeadings); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/api/sheets/v4"
+)
+
+// A sheetService is the part of the Google Sheets API that majic uses.
+// Normally it's a googleSheets,
+// but it can be replaced with something that doesn't need Google
+// (see fakescryfall.go for the same idea applied to scryfall).
+type sheetService interface {
+	// get gets the spreadsheet's properties
+	// (only the given fields, if any).
+	get(ctx context.Context, key string, fields ...googleapi.Field) (*sheets.Spreadsheet, error)
+
+	// batchUpdate sends formatting and other non-value requests.
+	batchUpdate(ctx context.Context, key string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error)
+
+	// getValues gets the values in a range, in A1 notation.
+	// The renderOption is "FORMATTED_VALUE" or "FORMULA."
+	getValues(ctx context.Context, key, rangeName, renderOption string) (*sheets.ValueRange, error)
+
+	// updateValues writes the values in a range.
+	// The inputOption is "RAW" or "USER_ENTERED."
+	updateValues(ctx context.Context, key, rangeName string, vr *sheets.ValueRange, inputOption string) error
+
+	// batchUpdateValues writes the values in several ranges.
+	batchUpdateValues(ctx context.Context, key string, req *sheets.BatchUpdateValuesRequest) error
+
+	// appendValues adds rows after the table in a range.
+	// The insertOption is "INSERT_ROWS" or "OVERWRITE."
+	appendValues(ctx context.Context, key, rangeName string, vr *sheets.ValueRange, inputOption, insertOption string) error
+
+	// clearValues clears the values in a range.
+	clearValues(ctx context.Context, key, rangeName string) error
+}
+
+// googleSheets is the sheetService for the real Google Sheets API.
+// Its methods pass errors through googleAPIError,
+// so callers can recognize rate limiting and rejected credentials.
+type googleSheets struct {
+	svc *sheets.Service
+}
+
+// newGoogleSheets creates a googleSheets,
+// authenticated according to auth,
+// whose calls are rate-limited by limiter.
+func newGoogleSheets(ctx context.Context, auth authOpts, limiter *rate.Limiter) (googleSheets, error) {
+	// Creating the spreadsheet-API client is trickier than the scryfall one.
+	// We first need to get an authenticated HTTP client.
+	// See auth.go.
+	// The oauth2 package makes its requests with the HTTP client in ctx, if there is one,
+	// so this makes them use apiTransport's pool of connections too.
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, &http.Client{Transport: apiTransport})
+	ssAPIClient, err := sheetsHTTPClient(ctx, auth)
+	if err != nil {
+		return googleSheets{}, fmt.Errorf("authenticating: %w", err)
+	}
+
+	// Now that we have an authenticated HTTP client,
+	// we can wrap its existing Transport field in a rateLimitedRoundTripper.
+	origTransport := ssAPIClient.Transport
+	if origTransport == nil {
+		origTransport = apiTransport
+	}
+	ssAPIClient.Transport = rateLimitedRoundTripper{
+		name:    "sheets",
+		limiter: limiter,
+		next:    origTransport,
+	}
+
+	// Now that we have an authenticated HTTP client that is also rate-limited,
+	// we can use it to get a "sheets service" object.
+	s, err := sheets.NewService(ctx, option.WithHTTPClient(ssAPIClient))
+	if err != nil {
+		return googleSheets{}, fmt.Errorf("creating sheets service: %w", err)
+	}
+	return googleSheets{svc: s}, nil
+}
+
+func (g googleSheets) get(ctx context.Context, key string, fields ...googleapi.Field) (*sheets.Spreadsheet, error) {
+	call := g.svc.Spreadsheets.Get(key)
+	if len(fields) > 0 {
+		call = call.Fields(fields...)
+	}
+	ss, err := call.Context(ctx).Do()
+	return ss, googleAPIError(err)
+}
+
+func (g googleSheets) batchUpdate(ctx context.Context, key string, req *sheets.BatchUpdateSpreadsheetRequest) (*sheets.BatchUpdateSpreadsheetResponse, error) {
+	resp, err := g.svc.Spreadsheets.BatchUpdate(key, req).Context(ctx).Do()
+	return resp, googleAPIError(err)
+}
+
+func (g googleSheets) getValues(ctx context.Context, key, rangeName, renderOption string) (*sheets.ValueRange, error) {
+	vr, err := g.svc.Spreadsheets.Values.Get(key, rangeName).ValueRenderOption(renderOption).Context(ctx).Do()
+	return vr, googleAPIError(err)
+}
+
+func (g googleSheets) updateValues(ctx context.Context, key, rangeName string, vr *sheets.ValueRange, inputOption string) error {
+	_, err := g.svc.Spreadsheets.Values.Update(key, rangeName, vr).ValueInputOption(inputOption).Context(ctx).Do()
+	return googleAPIError(err)
+}
+
+func (g googleSheets) batchUpdateValues(ctx context.Context, key string, req *sheets.BatchUpdateValuesRequest) error {
+	_, err := g.svc.Spreadsheets.Values.BatchUpdate(key, req).Context(ctx).Do()
+	return googleAPIError(err)
+}
+
+func (g googleSheets) appendValues(ctx context.Context, key, rangeName string, vr *sheets.ValueRange, inputOption, insertOption string) error {
+	_, err := g.svc.Spreadsheets.Values.Append(key, rangeName, vr).ValueInputOption(inputOption).InsertDataOption(insertOption).Context(ctx).Do()
+	return googleAPIError(err)
+}
+
+func (g googleSheets) clearValues(ctx context.Context, key, rangeName string) error {
+	_, err := g.svc.Spreadsheets.Values.Clear(key, rangeName, &sheets.ClearValuesRequest{}).Context(ctx).Do()
+	return googleAPIError(err)
+}
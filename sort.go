@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/sheets/v4"
+)
+
+// A sortKey is one of the columns by which to sort a sheet
+// (see the -sort flag).
+type sortKey struct {
+	field string // A field name or column heading.
+	desc  bool
+}
+
+// parseSort parses the value of the -sort flag.
+// It's a comma-separated list of columns,
+// each optionally followed by "desc" (or "asc"),
+// as in "set, price desc."
+// Columns are named as in -filter expressions
+// (see rowFilter),
+// except that spaces may be used in place of underscores.
+func parseSort(s string) ([]sortKey, error) {
+	var result []sortKey
+	for _, part := range strings.Split(s, ",") {
+		words := strings.Fields(part)
+		if len(words) == 0 {
+			return nil, fmt.Errorf("empty column in sort spec %q", s)
+		}
+		var key sortKey
+		switch strings.ToLower(words[len(words)-1]) {
+		case "desc":
+			key.desc = true
+			words = words[:len(words)-1]
+		case "asc":
+			words = words[:len(words)-1]
+		}
+		if len(words) == 0 {
+			return nil, fmt.Errorf("missing column name in sort spec %q", s)
+		}
+		field := strings.ToLower(strings.Join(words, " "))
+		if alias, ok := filterAliases[field]; ok {
+			field = alias
+		}
+		key.field = strings.ReplaceAll(field, "_", " ")
+		result = append(result, key)
+	}
+	return result, nil
+}
+
+// sortSheet sorts the data rows of a sheet
+// (those after the heading row and before endRow)
+// by r.sortKeys.
+// The sorting is done by Google Sheets,
+// so it takes just one API call
+// and leaves formulas and formatting intact.
+func (r *runner) sortSheet(ctx context.Context, sheetName string, headerRow, endRow int, columnHeadings map[string]int) error {
+	id, err := r.sheetID(ctx, sheetName)
+	if err != nil {
+		return err
+	}
+
+	var specs []*sheets.SortSpec
+	for _, key := range r.sortKeys {
+		col, ok := columnHeadings[strings.ToLower(r.cfg.heading(key.field))]
+		if !ok {
+			return fmt.Errorf("no column for %q in -sort", key.field)
+		}
+		order := "ASCENDING"
+		if key.desc {
+			order = "DESCENDING"
+		}
+		specs = append(specs, &sheets.SortSpec{
+			DimensionIndex:  int64(col),
+			SortOrder:       order,
+			ForceSendFields: []string{"DimensionIndex"}, // See columnRange.
+		})
+	}
+
+	rng := &sheets.GridRange{
+		SheetId:         id,
+		StartRowIndex:   int64(headerRow + 1),
+		EndRowIndex:     int64(endRow),
+		ForceSendFields: []string{"SheetId"}, // See columnRange.
+	}
+	if g := r.dataRange; g != nil {
+		// Sort only within the columns of -range.
+		rng.StartColumnIndex = int64(g.StartCol)
+		if g.EndCol >= 0 {
+			rng.EndColumnIndex = int64(g.EndCol + 1)
+		}
+	}
+
+	err = r.batchUpdate(ctx, &sheets.Request{
+		SortRange: &sheets.SortRangeRequest{
+			Range:     rng,
+			SortSpecs: specs,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("sorting sheet: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// These are the names of the price sources,
+// recorded in respObj.PriceSource by each card catalog
+// for the optional Source column.
+const (
+	sourceScryfall     = "scryfall"
+	sourceScryfallBulk = "scryfall-bulk" // See bulk.go.
+	sourcePokemonTCG   = "pokemontcg"    // See pokemon.go.
+	sourceYGOPRODeck   = "ygoprodeck"    // See yugioh.go.
+	sourceTCGplayer    = "tcgplayer"     // See sealed.go.
+)
+
+// priceSource is the value for a row's Source column:
+// where its price came from,
+// which of the source's prices it is
+// (by scryfall's names, like "usd_foil"),
+// any multiplier for the card's condition,
+// and the date the price was fetched
+// (or for bulk data, published),
+// as in
+//
+//	scryfall/usd_foil ×0.85 @ 2024-05-01
+//
+// That keeps a sheet whose prices come from several places auditable.
+func priceSource(obj *respObj, priceName string, condMult float64) string {
+	source := obj.PriceSource
+	if source == "" {
+		source = "unknown"
+	}
+	s := source + "/" + priceName
+	if condMult != 1 {
+		s += " ×" + strconv.FormatFloat(condMult, 'f', -1, 64)
+	}
+	if !obj.PricedAt.IsZero() {
+		s += fmt.Sprintf(" @ %s", obj.PricedAt.Local().Format("2006-01-02"))
+	}
+	return s
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+)
+
+// stats implements the "stats" subcommand.
+// It summarizes the contents of the selected sheets
+// (see readCollection):
+// the number and value of cards by set, by rarity, and by color,
+// and the top most valuable cards.
+// Proxies are left out of everything
+// but a separate count and value
+// (see rowResult.proxy).
+// Everything comes from what's already in the sheet;
+// rarity and color need the Rarity and Color columns
+// (see metadata.go).
+func (r *runner) stats(ctx context.Context, top int, _ []string) error {
+	all, err := r.readCollection(ctx)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+
+	var (
+		rows           []collectionRow // Not including proxies.
+		total, proxies statsGroup
+	)
+	for _, row := range all {
+		if row.Proxy {
+			proxies.add(row)
+			continue
+		}
+		rows = append(rows, row)
+		total.add(row)
+	}
+	fmt.Fprintf(tw, "Cards:\t%d\n", total.count)
+	fmt.Fprintf(tw, "Value:\t%.2f\n", total.value)
+	if total.unpriced > 0 {
+		fmt.Fprintf(tw, "Without prices:\t%d\n", total.unpriced)
+	}
+	if proxies.count > 0 {
+		fmt.Fprintf(tw, "Proxies:\t%d\n", proxies.count)
+		fmt.Fprintf(tw, "Value with proxies:\t%.2f\n", total.value+proxies.value)
+	}
+
+	for _, by := range []struct {
+		title string
+		key   func(collectionRow) string
+	}{
+		{title: "Set", key: func(row collectionRow) string { return row.SetCode }},
+		{title: "Rarity", key: func(row collectionRow) string { return row.column(r.cfg.heading("rarity")) }},
+		{title: "Color", key: func(row collectionRow) string { return row.column(r.cfg.heading("color")) }},
+	} {
+		groups := make(map[string]*statsGroup)
+		for _, row := range rows {
+			k := by.key(row)
+			if k == "" {
+				continue
+			}
+			g, ok := groups[k]
+			if !ok {
+				g = &statsGroup{name: k}
+				groups[k] = g
+			}
+			g.add(row)
+		}
+		if len(groups) == 0 {
+			// E.g. no Rarity column.
+			continue
+		}
+		writeStatsGroups(tw, by.title, groups)
+	}
+
+	if top > 0 {
+		writeTopCards(tw, rows, top)
+	}
+
+	return tw.Flush()
+}
+
+// A statsGroup is the number and total value of some cards.
+type statsGroup struct {
+	name     string
+	count    int
+	value    float64
+	unpriced int // How many of the cards have no price.
+}
+
+// add adds a row's cards to the group.
+// Rows without prices count toward the number of cards but not the value.
+func (g *statsGroup) add(row collectionRow) {
+	g.count += row.Quantity
+	if row.Price != nil {
+		g.value += *row.Price * float64(row.Quantity)
+	} else {
+		g.unpriced += row.Quantity
+	}
+}
+
+// writeStatsGroups writes a table of groups,
+// in decreasing order of value.
+func writeStatsGroups(w io.Writer, title string, groups map[string]*statsGroup) {
+	var sorted []*statsGroup
+	for _, g := range groups {
+		sorted = append(sorted, g)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].value != sorted[j].value {
+			return sorted[i].value > sorted[j].value
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	fmt.Fprintf(w, "\n%s\tCards\tValue\n", title)
+	for _, g := range sorted {
+		fmt.Fprintf(w, "%s\t%d\t%.2f\n", g.name, g.count, g.value)
+	}
+}
+
+// writeTopCards writes a table of the n most valuable rows,
+// by the price of a single card.
+func writeTopCards(w io.Writer, rows []collectionRow, n int) {
+	var priced []collectionRow
+	for _, row := range rows {
+		if row.Price != nil {
+			priced = append(priced, row)
+		}
+	}
+	sort.SliceStable(priced, func(i, j int) bool {
+		return *priced[i].Price > *priced[j].Price
+	})
+	if len(priced) > n {
+		priced = priced[:n]
+	}
+
+	fmt.Fprintf(w, "\nMost valuable\tSet\tFoil\tPrice\n")
+	for _, row := range priced {
+		var foil string
+		if row.Foil {
+			foil = "foil"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.2f\n", row.CardName, row.SetCode, foil, *row.Price)
+	}
+}
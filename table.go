@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bobg/majic/a1"
+	"google.golang.org/api/sheets/v4"
+)
+
+// A table is the contents of a single sheet,
+// with its column headings mapped to column numbers.
+// It's for subcommands that add or change rows
+// outside of a normal run.
+type table struct {
+	sheetName      string
+	orig           [][]any // The sheet's contents as read.
+	rows           [][]any // The sheet's contents restricted by -range; see layout.
+	headerRow      int
+	endRow         int // The row after the last data row.
+	headings       []any
+	columnHeadings map[string]int // Maps lowercase headings to column numbers.
+	cfg            *config
+}
+
+// openTable reads the single sheet selected by -sheetname (and -range),
+// which must have a card-name column.
+func (r *runner) openTable(ctx context.Context) (*table, error) {
+	sheetNames, err := r.targetSheets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(sheetNames) != 1 {
+		return nil, fmt.Errorf("-sheetname must name a single sheet")
+	}
+	sheetName := sheetNames[0]
+
+	resp, err := r.readSheet(ctx, sheetName)
+	if err != nil {
+		return nil, err
+	}
+	rows, headerRow, endRow, err := r.layout(resp.Values)
+	if err != nil {
+		return nil, err
+	}
+	t := &table{
+		sheetName:      sheetName,
+		orig:           resp.Values,
+		rows:           rows,
+		headerRow:      headerRow,
+		endRow:         endRow,
+		headings:       rows[headerRow],
+		columnHeadings: headingCols(rows[headerRow]),
+		cfg:            r.cfg,
+	}
+	if t.col(cardNameField) < 0 {
+		return nil, fmt.Errorf("no %q column", r.cfg.heading(cardNameField))
+	}
+	return t, nil
+}
+
+// col returns the number of the column for the given field,
+// or -1 if there isn't one.
+func (t *table) col(field string) int {
+	if c, ok := t.columnHeadings[strings.ToLower(t.cfg.heading(field))]; ok {
+		return c
+	}
+	return -1
+}
+
+// cell returns the contents of the given field in the given row as a string.
+// It's empty if the sheet has no such column.
+func (t *table) cell(rownum int, field string) string {
+	c := t.col(field)
+	if c < 0 || rownum >= len(t.rows) || c >= len(t.rows[rownum]) {
+		return ""
+	}
+	return fmt.Sprint(t.rows[rownum][c])
+}
+
+// newRow returns an empty row for appending to the table,
+// and a function for filling in its fields.
+// Fields with no column in the sheet are ignored.
+func (t *table) newRow() ([]any, func(field string, val any)) {
+	row := make([]any, len(t.headings))
+	for i := range row {
+		row[i] = ""
+	}
+	set := func(field string, val any) {
+		if c := t.col(field); c >= 0 {
+			row[c] = val
+		}
+	}
+	return row, set
+}
+
+// appendRows adds rows after the last one in the table.
+func (r *runner) appendRows(ctx context.Context, t *table, rows [][]any) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	// Appending to the heading row's range
+	// adds rows after the table that it starts.
+	rangeName := a1.Range{
+		Sheet:    t.sheetName,
+		StartRow: t.headerRow,
+		StartCol: -1,
+		EndRow:   t.headerRow,
+		EndCol:   -1,
+	}.String()
+
+	vr := &sheets.ValueRange{Values: rows}
+	err := r.svc.appendValues(ctx, r.sheetKey, rangeName, vr, "RAW", "INSERT_ROWS")
+	if err != nil {
+		return fmt.Errorf("appending rows: %w", err)
+	}
+	sheetWrites.Add(float64(len(rows)))
+	return nil
+}
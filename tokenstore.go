@@ -0,0 +1,205 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/oauth2"
+)
+
+// A tokenStore is where majic keeps its OAuth token between runs.
+// See parseTokenStore for the kinds there are.
+type tokenStore interface {
+	// load returns the stored token,
+	// or nil if there isn't one.
+	load() (*oauth2.Token, error)
+
+	// save stores a new or refreshed token.
+	save(*oauth2.Token) error
+
+	// remove discards the stored token
+	// (e.g. because it has been revoked).
+	// It's not an error if there isn't one.
+	remove() error
+
+	// String describes the store, for messages.
+	String() string
+}
+
+// keyringService is the "service" under which tokens are kept in the OS keychain.
+const keyringService = "majic"
+
+// parseTokenStore turns the value of the -token flag into a tokenStore:
+//
+//   - "keyring:NAME" keeps the token in the OS keychain
+//     (the macOS Keychain, the Windows Credential Manager, or the Secret Service on Linux)
+//     under the name NAME,
+//     or "default" if NAME is empty;
+//   - "env:VAR" reads the token as JSON from the environment variable VAR
+//     (and can't save a refreshed one,
+//     so it's for the short-lived runs of a scheduler that sets VAR);
+//   - "file:PATH" or just PATH keeps the token in the named file,
+//     encrypted with key if that's not nil.
+//
+// An empty string means no token is stored at all,
+// so every run needs authorizing.
+func parseTokenStore(spec string, key *credKey) (tokenStore, error) {
+	kind, arg, ok := strings.Cut(spec, ":")
+	if !ok {
+		return fileTokenStore{filename: spec, key: key}, nil
+	}
+	switch kind {
+	case "keyring":
+		if arg == "" {
+			arg = "default"
+		}
+		return keyringTokenStore{user: arg}, nil
+	case "env":
+		if arg == "" {
+			return nil, fmt.Errorf("missing variable name in -token %q", spec)
+		}
+		return envTokenStore{name: arg}, nil
+	case "file":
+		return fileTokenStore{filename: arg, key: key}, nil
+	}
+	// Perhaps a Windows path like C:\token.json.
+	return fileTokenStore{filename: spec, key: key}, nil
+}
+
+// fileTokenStore keeps the token as JSON in a file,
+// readable only by its owner,
+// and optionally encrypted.
+type fileTokenStore struct {
+	filename string   // If empty, nothing is stored.
+	key      *credKey // If not nil, the file is encrypted with this.
+}
+
+func (s fileTokenStore) load() (*oauth2.Token, error) {
+	if s.filename == "" {
+		return nil, nil
+	}
+	data, err := s.key.readFile(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", s.filename, err)
+	}
+	return decodeToken(data)
+}
+
+func (s fileTokenStore) save(tok *oauth2.Token) error {
+	if s.filename == "" {
+		return nil
+	}
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("encoding token: %w", err)
+	}
+	data, err = s.key.encrypt(data)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(s.filename, data, 0600); err != nil {
+		return fmt.Errorf("writing %s: %w", s.filename, err)
+	}
+	return nil
+}
+
+func (s fileTokenStore) remove() error {
+	if s.filename == "" {
+		return nil
+	}
+	if err := os.Remove(s.filename); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing %s: %w", s.filename, err)
+	}
+	return nil
+}
+
+func (s fileTokenStore) String() string { return s.filename }
+
+// keyringTokenStore keeps the token in the OS keychain.
+type keyringTokenStore struct {
+	user string
+}
+
+func (s keyringTokenStore) load() (*oauth2.Token, error) {
+	data, err := keyring.Get(keyringService, s.user)
+	if errors.Is(err, keyring.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading token from keychain: %w", err)
+	}
+	return decodeToken([]byte(data))
+}
+
+func (s keyringTokenStore) save(tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("encoding token: %w", err)
+	}
+	if err := keyring.Set(keyringService, s.user, string(data)); err != nil {
+		return fmt.Errorf("storing token in keychain: %w", err)
+	}
+	return nil
+}
+
+func (s keyringTokenStore) remove() error {
+	if err := keyring.Delete(keyringService, s.user); err != nil && !errors.Is(err, keyring.ErrNotFound) {
+		return fmt.Errorf("removing token from keychain: %w", err)
+	}
+	return nil
+}
+
+func (s keyringTokenStore) String() string { return "keyring:" + s.user }
+
+// envTokenStore reads the token from an environment variable.
+// It can't save a new token where the next run will find it,
+// so it prints a new one instead,
+// for the user to put in the variable.
+// (A refreshed token isn't needed next time;
+// the one in the variable can be refreshed again.)
+type envTokenStore struct {
+	name string
+}
+
+func (s envTokenStore) load() (*oauth2.Token, error) {
+	val := os.Getenv(s.name)
+	if val == "" {
+		return nil, nil
+	}
+	return decodeToken([]byte(val))
+}
+
+func (s envTokenStore) save(tok *oauth2.Token) error {
+	data, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("encoding token: %w", err)
+	}
+	if os.Getenv(s.name) == "" {
+		// This is a new authorization,
+		// so the user is at the terminal.
+		fmt.Fprintf(os.Stderr, "To use this authorization in future runs, set %s to:\n\n  %s\n\n", s.name, data)
+	} else {
+		slog.Debug("Refreshed OAuth token not saved", "var", s.name)
+	}
+	return nil
+}
+
+func (s envTokenStore) remove() error { return nil }
+
+func (s envTokenStore) String() string { return "env:" + s.name }
+
+func decodeToken(data []byte) (*oauth2.Token, error) {
+	var tok oauth2.Token
+	if err := json.Unmarshal(data, &tok); err != nil {
+		return nil, fmt.Errorf("decoding token: %w", err)
+	}
+	return &tok, nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"io"
+	"math"
+	"strings"
+	"text/template"
+)
+
+// A computedColumn is a column whose value majic computes
+// for each row it prices,
+// from an expression in the config's computed_columns,
+// such as a custom valuation formula:
+//
+//	"computed_columns": {
+//	  "Trade value": "max(round(price * 0.8, 2), 0.25)",
+//	  "Playset value": "price * 4"
+//	}
+//
+// The expressions are written like -filter expressions
+// (see rowFilter),
+// with arithmetic and the functions in exprFuncs.
+// An identifier names an item of the card's data from the lookup
+// (see cardVars),
+// or else a column of the row.
+//
+// The result is written as a number, a checkbox (true or false), or text.
+//
+// Alternatively,
+// a computed column can come from a Go template
+// in the config's template_columns,
+// for text put together from the card's data:
+//
+//	"template_columns": {
+//	  "Display": "{{.Name}} ({{.Set}}) {{if .Foil}}★{{end}}"
+//	}
+//
+// See cardTemplateData.
+//
+// A sheet with no column for a computed column's heading
+// simply doesn't get it.
+type computedColumn struct {
+	heading string
+	expr    ast.Expr           // Nil for a template column.
+	tmpl    *template.Template // Nil for an expression column.
+
+	col    int                            // The column to write. Set by bind.
+	cols   map[string]int                 // Maps identifiers that aren't card data to column numbers. Set by bind.
+	colFor func(field string) (int, bool) // For template columns. Set by bind.
+}
+
+// parseComputedColumns parses the config's computed_columns.
+func parseComputedColumns(m map[string]string) ([]computedColumn, error) {
+	var result []computedColumn
+	for heading, s := range m {
+		expr, err := parser.ParseExpr(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing expression for computed column %q: %w", heading, err)
+		}
+		result = append(result, computedColumn{heading: heading, expr: expr})
+	}
+	return result, nil
+}
+
+// parseTemplateColumns parses the config's template_columns.
+// Each template is tried once on empty card data,
+// so that a misspelled field name
+// (like {{.Nmae}})
+// is reported now,
+// not on every row.
+func parseTemplateColumns(m map[string]string) ([]computedColumn, error) {
+	var result []computedColumn
+	for heading, s := range m {
+		tmpl, err := template.New(heading).Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("parsing template for column %q: %w", heading, err)
+		}
+		if err := tmpl.Execute(io.Discard, cardTemplateData{}); err != nil {
+			return nil, fmt.Errorf("in template for column %q: %w", heading, err)
+		}
+		result = append(result, computedColumn{heading: heading, tmpl: tmpl})
+	}
+	return result, nil
+}
+
+// bind returns a copy of c that is ready to use on the rows of a particular sheet,
+// or false if the sheet has no column for c.
+// The colFor function maps a field name or heading to its column number in the sheet.
+// It's an error for an identifier in c's expression
+// to be neither card data nor a column.
+func (c computedColumn) bind(colFor func(field string) (int, bool)) (computedColumn, bool, error) {
+	col, ok := colFor(strings.ToLower(c.heading))
+	if !ok {
+		return c, false, nil
+	}
+	c.col = col
+	c.cols = make(map[string]int)
+	if c.tmpl != nil {
+		// Columns are looked up by heading when the template runs;
+		// see cardTemplateData.Column.
+		c.colFor = colFor
+		return c, true, nil
+	}
+	for _, name := range exprIdents(c.expr) {
+		if _, ok := cardVarNames[strings.ToLower(name)]; ok {
+			continue
+		}
+		col, ok := colFor(identField(name))
+		if !ok {
+			return c, false, fmt.Errorf("in computed column %q: %q is neither card data nor a column", c.heading, name)
+		}
+		c.cols[name] = col
+	}
+	return c, true, nil
+}
+
+// value computes c's value for a row,
+// given the card's data (from cardVars).
+func (c computedColumn) value(vars map[string]any, row []any) (any, error) {
+	if c.tmpl != nil {
+		buf := new(strings.Builder)
+		if err := c.tmpl.Execute(buf, newCardTemplateData(vars, row, c.colFor)); err != nil {
+			return nil, err
+		}
+		return strings.TrimSpace(buf.String()), nil
+	}
+	return evalExpr(c.expr, func(name string) any {
+		if v, ok := vars[strings.ToLower(name)]; ok {
+			return v
+		}
+		if col, ok := c.cols[name]; ok && col < len(row) {
+			return fmt.Sprint(row[col])
+		}
+		return ""
+	})
+}
+
+// cardVarNames are the identifiers for card data in computed columns.
+// See cardVars.
+var cardVarNames = map[string]struct{}{
+	"name": {}, "set": {}, "set_name": {}, "rarity": {}, "type_line": {},
+	"collector_number": {}, "cmc": {}, "colors": {}, "foil": {}, "price": {},
+	"usd": {}, "usd_foil": {}, "usd_etched": {}, "eur": {}, "eur_foil": {}, "tix": {},
+}
+
+// cardVars is the card data for computed columns:
+// the card's name, set code, set name, rarity, type line,
+// collector number, mana value ("cmc"),
+// colors (like "WU"),
+// whether it's foil,
+// the price written to the Price column
+// (after any adjustment for condition),
+// and the card's prices by their names in scryfall's "prices" object
+// ("usd," "usd_foil," and so on).
+func cardVars(obj *respObj, res rowResult) map[string]any {
+	vars := map[string]any{
+		"name":             obj.Name,
+		"set":              obj.Set,
+		"set_name":         obj.SetName,
+		"rarity":           obj.Rarity,
+		"type_line":        obj.TypeLine,
+		"collector_number": obj.CollectorNumber,
+		"cmc":              obj.CMC,
+		"colors":           strings.Join(obj.Colors, ""),
+		"foil":             res.foil,
+		"price":            res.newPrice,
+	}
+	if vars["name"] == "" {
+		vars["name"] = res.cardName
+	}
+	if vars["set"] == "" {
+		vars["set"] = res.setCode
+	}
+	for _, field := range []string{"usd", "usd_foil", "usd_etched", "eur", "eur_foil", "tix"} {
+		vars[field], _ = obj.Prices.field(field)
+	}
+	return vars
+}
+
+// cardTemplateData is the data for template columns
+// (see computedColumn):
+// the same card data as for expressions (see cardVars),
+// under capitalized names,
+// so that {{.SetName}} is the card's set name
+// and {{.Prices.usd_foil}} its foil price.
+type cardTemplateData struct {
+	Name, Set, SetName, Rarity, TypeLine, CollectorNumber string
+	CMC                                                   float64
+	Colors                                                string // Like "WU".
+	Foil                                                  bool
+	Price                                                 string            // The price for the Price column.
+	Prices                                                map[string]string // Keyed by scryfall's names, like "usd_foil".
+
+	row    []any
+	colFor func(field string) (int, bool)
+}
+
+func newCardTemplateData(vars map[string]any, row []any, colFor func(field string) (int, bool)) cardTemplateData {
+	str := func(name string) string {
+		s, _ := vars[name].(string)
+		return s
+	}
+	d := cardTemplateData{
+		Name:            str("name"),
+		Set:             str("set"),
+		SetName:         str("set_name"),
+		Rarity:          str("rarity"),
+		TypeLine:        str("type_line"),
+		CollectorNumber: str("collector_number"),
+		Colors:          str("colors"),
+		Price:           str("price"),
+		Prices:          make(map[string]string),
+		row:             row,
+		colFor:          colFor,
+	}
+	d.CMC, _ = vars["cmc"].(float64)
+	d.Foil, _ = vars["foil"].(bool)
+	for _, field := range []string{"usd", "usd_foil", "usd_etched", "eur", "eur_foil", "tix"} {
+		d.Prices[field] = str(field)
+	}
+	return d
+}
+
+// Column is the value of the row's cell in the column with the given heading,
+// as in {{.Column "Purchase price"}},
+// or the empty string if there's no such column.
+func (d cardTemplateData) Column(heading string) string {
+	if d.colFor == nil {
+		return ""
+	}
+	col, ok := d.colFor(strings.ToLower(heading))
+	if !ok || col >= len(d.row) {
+		return ""
+	}
+	return fmt.Sprint(d.row[col])
+}
+
+// exprFuncs are the functions that can be called in expressions
+// (in computed columns, and in -filter).
+//
+// Transforms that need more than an expression
+// can be compiled in:
+// add a file to this package
+// (perhaps with a build tag of its own)
+// whose init function adds a Go function here,
+// and call it by name from computed_columns.
+var exprFuncs = map[string]func(args ...any) (any, error){
+	// min(x, y, …) is the smallest of its arguments.
+	"min": func(args ...any) (any, error) {
+		return extreme(args, func(a, b float64) bool { return a < b })
+	},
+
+	// max(x, y, …) is the largest of its arguments.
+	"max": func(args ...any) (any, error) {
+		return extreme(args, func(a, b float64) bool { return a > b })
+	},
+
+	// round(x) rounds x to a whole number,
+	// and round(x, n) to n decimal places.
+	"round": func(args ...any) (any, error) {
+		if len(args) < 1 || len(args) > 2 {
+			return nil, errors.New("want 1 or 2 arguments")
+		}
+		x, ok := number(args[0])
+		if !ok {
+			return nil, fmt.Errorf("%q is not a number", fmt.Sprint(args[0]))
+		}
+		var places float64
+		if len(args) == 2 {
+			if places, ok = number(args[1]); !ok {
+				return nil, fmt.Errorf("%q is not a number", fmt.Sprint(args[1]))
+			}
+		}
+		p := math.Pow(10, places)
+		return math.Round(x*p) / p, nil
+	},
+
+	// cond(c, x, y) is x if c is true, and y otherwise.
+	// (It can't be called "if," which Go syntax reserves.)
+	"cond": func(args ...any) (any, error) {
+		if len(args) != 3 {
+			return nil, errors.New("want 3 arguments")
+		}
+		if truthy(args[0]) {
+			return args[1], nil
+		}
+		return args[2], nil
+	},
+
+	// contains(s, t) tells whether t is in s,
+	// ignoring upper- and lowercase differences.
+	"contains": func(args ...any) (any, error) {
+		if len(args) != 2 {
+			return nil, errors.New("want 2 arguments")
+		}
+		return strings.Contains(strings.ToLower(fmt.Sprint(args[0])), strings.ToLower(fmt.Sprint(args[1]))), nil
+	},
+}
+
+// extreme returns the argument that is better than all the others
+// (according to better),
+// treating them as numbers.
+func extreme(args []any, better func(a, b float64) bool) (any, error) {
+	if len(args) == 0 {
+		return nil, errors.New("no arguments")
+	}
+	var result float64
+	for i, arg := range args {
+		x, ok := number(arg)
+		if !ok {
+			return nil, fmt.Errorf("%q is not a number", fmt.Sprint(arg))
+		}
+		if i == 0 || better(x, result) {
+			result = x
+		}
+	}
+	return result, nil
+}
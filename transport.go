@@ -1,9 +1,16 @@
 package main
 
 import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
-	"github.com/pkg/errors"
 	"golang.org/x/time/rate"
 )
 
@@ -14,25 +21,243 @@ import (
 // after the Wait,
 // delegates to its RoundTrip method.
 // If there is no wrapped RoundTripper,
-// http.DefaultTransport is used instead.
+// apiTransport is used instead.
+// The rate adjusts itself if the API says it's being called too often;
+// see RoundTrip.
 //
 // See https://pkg.go.dev/net/http#RoundTripper
 // for a description of the RoundTripper interface
 // that this type implements.
 type rateLimitedRoundTripper struct {
+	name    string // For logging, e.g. "scryfall".
 	limiter *rate.Limiter
 	next    http.RoundTripper
+	budget  *lookupBudget // If not nil, each call spends from this; see budget.go.
 }
 
+// apiTransport is the RoundTripper that makes the actual HTTP requests
+// for all the card APIs
+// (and for anything else using a rateLimitedRoundTripper with no next).
+// Sharing it means sharing its pool of connections,
+// so a run makes one connection to scryfall and keeps reusing it
+// rather than paying for a new TCP and TLS handshake on each call.
+// It's http.DefaultTransport with more idle connections kept per host
+// (the default is two),
+// TCP keep-alives,
+// and HTTP/2 where the server supports it.
+var apiTransport = &http.Transport{
+	Proxy: http.ProxyFromEnvironment,
+	DialContext: (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}).DialContext,
+	ForceAttemptHTTP2:     true,
+	MaxIdleConns:          100,
+	MaxIdleConnsPerHost:   16,
+	IdleConnTimeout:       90 * time.Second,
+	TLSHandshakeTimeout:   10 * time.Second,
+	ExpectContinueTimeout: time.Second,
+}
+
+// limiterWaits is the time spent waiting on each rate limiter,
+// by name,
+// since the start of the current run.
+// Comparing them shows which API's limit is holding up the run.
+// (In the Prometheus metrics,
+// limiterWaitSeconds has the same information
+// for the life of the process.)
+var limiterWaits waitTotals
+
+// waitTotals accumulates durations by name.
+type waitTotals struct {
+	mu sync.Mutex
+	m  map[string]time.Duration
+}
+
+func (w *waitTotals) add(name string, d time.Duration) {
+	limiterWaitSeconds.WithLabelValues(name).Add(d.Seconds())
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.m == nil {
+		w.m = make(map[string]time.Duration)
+	}
+	w.m[name] += d
+}
+
+func (w *waitTotals) reset() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.m = nil
+}
+
+// seconds returns the totals in seconds,
+// for the run report.
+func (w *waitTotals) seconds() map[string]float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	result := make(map[string]float64)
+	for name, d := range w.m {
+		result[name] = d.Seconds()
+	}
+	return result
+}
+
+// log logs the totals,
+// in order by name.
+func (w *waitTotals) log() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.m) == 0 {
+		return
+	}
+	var names []string
+	for name := range w.m {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var args []any
+	for _, name := range names {
+		args = append(args, name, w.m[name].Round(time.Millisecond))
+	}
+	slog.Info("Time spent waiting on rate limiters", args...)
+}
+
+// When an API responds with status 429 (Too Many Requests),
+// RoundTrip slows the limiter down
+// (see slowDown),
+// waits as long as the response's Retry-After header says
+// (or a second, if it doesn't say),
+// and tries again,
+// up to maxRateLimitRetries times.
+// Successful calls speed the limiter back up gradually
+// (see speedUp)
+// until it reaches its original rate.
 func (rt rateLimitedRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
+	ceiling := limiterCeiling(rt.limiter)
+
+	for attempt := 1; ; attempt++ {
+		resp, err := rt.roundTripOnce(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != http.StatusTooManyRequests {
+			rt.speedUp(ceiling)
+			return resp, nil
+		}
+		rt.slowDown(ceiling)
+
+		// A request with a body can be retried only if the body can be read again.
+		if attempt > maxRateLimitRetries || (req.Body != nil && req.GetBody == nil) {
+			return resp, nil
+		}
+		wait := retryAfter(resp)
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+
+		slog.Warn("Rate limited, retrying", "api", rt.name, "attempt", attempt, "wait", wait)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+
+		req = req.Clone(ctx)
+		if req.GetBody != nil {
+			req.Body, err = req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("rewinding request body: %w", err)
+			}
+		}
+	}
+}
+
+// maxRateLimitRetries is how many times RoundTrip retries a request
+// that got a 429 response.
+const maxRateLimitRetries = 3
+
+// limiterCeilings holds the original rate of each limiter used by a rateLimitedRoundTripper,
+// recorded the first time it's used,
+// so a limiter that slowDown has slowed can recover to it.
+var limiterCeilings sync.Map // *rate.Limiter -> rate.Limit
+
+func limiterCeiling(l *rate.Limiter) rate.Limit {
+	v, _ := limiterCeilings.LoadOrStore(l, l.Limit())
+	return v.(rate.Limit)
+}
+
+// slowDown halves the limiter's rate,
+// but not below a sixteenth of its original rate
+// (the ceiling).
+func (rt rateLimitedRoundTripper) slowDown(ceiling rate.Limit) {
+	limit := max(rt.limiter.Limit()/2, ceiling/16)
+	rt.limiter.SetLimit(limit)
+	slog.Warn("Rate limited, slowing down", "api", rt.name, "rate", float64(limit))
+}
+
+// speedUp raises the limiter's rate by a fiftieth of the ceiling,
+// up to the ceiling.
+// After slowDown halves the rate,
+// it takes about 25 successful calls to get back to full speed.
+func (rt rateLimitedRoundTripper) speedUp(ceiling rate.Limit) {
+	if limit := rt.limiter.Limit(); limit < ceiling {
+		rt.limiter.SetLimit(min(limit+ceiling/50, ceiling))
+	}
+}
+
+// retryAfter tells how long to wait before retrying,
+// according to a 429 response's Retry-After header,
+// which may be a number of seconds or a time.
+// The result is between one second and one minute.
+func retryAfter(resp *http.Response) time.Duration {
+	wait := time.Second
+	if h := resp.Header.Get("Retry-After"); h != "" {
+		if secs, err := strconv.Atoi(h); err == nil {
+			wait = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(h); err == nil {
+			wait = time.Until(t)
+		}
+	}
+	return min(max(wait, time.Second), time.Minute)
+}
+
+// roundTripOnce waits for the limiter and then makes the request.
+func (rt rateLimitedRoundTripper) roundTripOnce(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	waitStart := time.Now()
 	err := rt.limiter.Wait(ctx)
+	limiterWaits.add(rt.name, time.Since(waitStart))
 	if err != nil {
-		return nil, errors.Wrap(err, "waiting for the limiter to let us through")
+		if ctx.Err() == nil {
+			// The limiter fails right away
+			// if the wait would go past ctx's deadline
+			// (see -timeout).
+			// Wait for the deadline instead,
+			// so the caller sees the usual context error.
+			<-ctx.Done()
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("waiting for the limiter to let us through: %w", err)
 	}
+	rt.budget.spend()
 	next := rt.next
 	if next == nil {
-		next = http.DefaultTransport
+		next = apiTransport
+	}
+
+	start := time.Now()
+	resp, err := next.RoundTrip(req)
+	elapsed := time.Since(start)
+	apiLatency.WithLabelValues(rt.name).Observe(elapsed.Seconds())
+	if err != nil {
+		apiCalls.WithLabelValues(rt.name, "error").Inc()
+		slog.Debug("API call failed", "api", rt.name, "method", req.Method, "url", req.URL.Redacted(), "err", err)
+		return nil, err
 	}
-	return next.RoundTrip(req)
+	apiCalls.WithLabelValues(rt.name, strconv.Itoa(resp.StatusCode)).Inc()
+	slog.Debug("API call", "api", rt.name, "method", req.Method, "url", req.URL.Redacted(), "status", resp.StatusCode, "elapsed", elapsed)
+	return resp, nil
 }
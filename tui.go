@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// A tui is the full-screen terminal display used with -tui.
+// It shows the progress of the run,
+// the most recently processed rows with their prices,
+// and recent log messages,
+// and it lets the user pause and resume the run,
+// skip rows,
+// or stop
+// (which works just like Ctrl-C).
+//
+// The progress object draws the status line at the top
+// (see progress.show)
+// and passes log output along
+// (see progress.Write).
+// The row loop in processSheet
+// calls next before each row and row after it.
+type tui struct {
+	w io.Writer
+
+	mu      sync.Mutex
+	status  string
+	rows    []string // The most recently processed rows, oldest first.
+	logs    []string // The most recent log messages, oldest first.
+	resume  chan struct{}
+	skip    bool // Whether to skip the next row.
+	stopped bool // Whether the user asked to stop.
+}
+
+// These are the numbers of rows and log messages the tui shows.
+const (
+	tuiRows = 15
+	tuiLogs = 8
+)
+
+// newTUI puts the terminal in a mode where keypresses can be read one at a time
+// (using the stty command, which must be present)
+// and starts reading them.
+// It returns the tui and a function that restores the terminal.
+func newTUI() (*tui, func(), error) {
+	for _, f := range []*os.File{os.Stdin, os.Stderr} {
+		if info, err := f.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+			return nil, nil, fmt.Errorf("-tui needs a terminal")
+		}
+	}
+
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading terminal settings: %w", err)
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return nil, nil, fmt.Errorf("setting terminal mode: %w", err)
+	}
+
+	t := &tui{w: os.Stderr}
+	go t.readKeys(os.Stdin)
+
+	restore := func() {
+		stty(strings.TrimSpace(saved))
+		fmt.Fprintln(t.w)
+	}
+	return t, restore, nil
+}
+
+// stty runs the stty command on the terminal with the given arguments
+// and returns its output.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKeys handles keypresses:
+// p (or space) to pause and resume,
+// s to skip the next row,
+// and q to stop.
+func (t *tui) readKeys(r io.Reader) {
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+
+		t.mu.Lock()
+		switch b {
+		case 'p', 'P', ' ':
+			if t.resume == nil {
+				t.resume = make(chan struct{})
+			} else {
+				close(t.resume)
+				t.resume = nil
+			}
+		case 's', 'S':
+			t.skip = true
+		case 'q', 'Q':
+			t.stopped = true
+			if t.resume != nil {
+				close(t.resume)
+				t.resume = nil
+			}
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(os.Interrupt)
+			}
+		}
+		t.draw()
+		t.mu.Unlock()
+	}
+}
+
+// next is called before processing each row.
+// It waits while the run is paused,
+// then tells whether to skip the row.
+// It returns an error if ctx is canceled while waiting.
+func (t *tui) next(ctx context.Context) (bool, error) {
+	t.mu.Lock()
+	resume := t.resume
+	t.mu.Unlock()
+
+	if resume != nil {
+		select {
+		case <-resume:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	skip := t.skip
+	t.skip = false
+	return skip, nil
+}
+
+// row records the outcome of processing a row.
+func (t *tui) row(sheetName string, rownum int, res rowResult, err error) {
+	line := fmt.Sprintf("%s row %d: %s", sheetName, rownum+1, res.cardName)
+	if res.setCode != "" {
+		line += " (" + res.setCode + ")"
+	}
+	if res.foil {
+		line += " foil"
+	}
+	switch {
+	case err != nil:
+		line += ": " + err.Error()
+	case res.updated && res.oldPrice != "" && res.oldPrice != res.newPrice:
+		line += fmt.Sprintf(": %s → %s", res.oldPrice, res.newPrice)
+	case res.updated:
+		line += ": " + res.newPrice
+	default:
+		line += ": skipped"
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rows = appendLast(t.rows, line, tuiRows)
+	t.draw()
+}
+
+// skipped records a row skipped at the user's request.
+func (t *tui) skipped(sheetName string, rownum int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.rows = appendLast(t.rows, fmt.Sprintf("%s row %d: skipped by request", sheetName, rownum+1), tuiRows)
+	t.draw()
+}
+
+// show displays a new status line.
+func (t *tui) show(status string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status = status
+	t.draw()
+}
+
+// log displays log output.
+func (t *tui) log(buf []byte) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, line := range strings.Split(strings.TrimRight(string(buf), "\n"), "\n") {
+		t.logs = appendLast(t.logs, line, tuiLogs)
+	}
+	t.draw()
+}
+
+// draw redraws the whole screen.
+// The caller must hold t.mu.
+func (t *tui) draw() {
+	var b strings.Builder
+
+	// Move to the top left and clear the screen.
+	b.WriteString("\033[H\033[2J")
+
+	b.WriteString(t.status)
+	switch {
+	case t.stopped:
+		b.WriteString("  [STOPPING]")
+	case t.resume != nil:
+		b.WriteString("  [PAUSED]")
+	}
+	b.WriteString("\n\n")
+
+	for _, line := range t.rows {
+		b.WriteString(line + "\n")
+	}
+	for i := len(t.rows); i < tuiRows; i++ {
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	for _, line := range t.logs {
+		b.WriteString(line + "\n")
+	}
+	for i := len(t.logs); i < tuiLogs; i++ {
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString("p: pause/resume  s: skip next row  q: stop")
+	if t.skip {
+		b.WriteString("  (skipping next row)")
+	}
+
+	io.WriteString(t.w, b.String())
+}
+
+// appendLast appends s to lines,
+// keeping only the last n.
+func appendLast(lines []string, s string, n int) []string {
+	lines = append(lines, s)
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
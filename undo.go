@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bobg/majic/a1"
+)
+
+// An undoLog records the cells that recent runs changed,
+// with their values before the change,
+// so that "majic rollback" can put them back.
+// That's protection against a run that writes garbage,
+// as when a column heading is mapped to the wrong field.
+//
+// It's kept in a JSON file next to the history
+// (see -history),
+// and holds the last maxUndoRuns runs that changed anything.
+//
+// A nil *undoLog is valid and records nothing.
+type undoLog struct {
+	filename string
+
+	mu      sync.Mutex
+	runs    []undoRun // Oldest first.
+	current *undoRun  // The run in progress, if any.
+}
+
+// An undoRun is the record of the cells changed in one run.
+type undoRun struct {
+	Start    time.Time  `json:"start"`
+	SheetKey string     `json:"sheet_key"`
+	Cells    []undoCell `json:"cells"`
+}
+
+// An undoCell is a cell changed in a run.
+type undoCell struct {
+	Sheet string `json:"sheet"`
+	Row   int    `json:"row"` // Zero-based.
+	Col   int    `json:"col"` // Zero-based.
+	Old   any    `json:"old"` // Nil for a cell that was empty. A string beginning with "=" is a formula.
+
+	// New is the value written,
+	// so rollback can tell whether someone has changed the cell since.
+	// It's nil for a formula,
+	// whose value can't be known in advance.
+	New any `json:"new"`
+}
+
+// maxUndoRuns is how many runs an undoLog remembers.
+const maxUndoRuns = 10
+
+// loadUndoLog loads the undo log in the given file.
+// It's not an error for the file not to exist.
+func loadUndoLog(filename string) (*undoLog, error) {
+	u := &undoLog{filename: filename}
+
+	data, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return u, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading %s: %w", filename, err)
+	}
+	if err := json.Unmarshal(data, &u.runs); err != nil {
+		return nil, fmt.Errorf("decoding %s: %w", filename, err)
+	}
+	return u, nil
+}
+
+// start begins recording a run.
+func (u *undoLog) start(sheetKey string) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.current = &undoRun{Start: time.Now(), SheetKey: sheetKey}
+}
+
+// recording tells whether a run is being recorded.
+func (u *undoLog) recording() bool {
+	if u == nil {
+		return false
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.current != nil
+}
+
+// record notes the cells about to be changed by updates,
+// whose old values are in orig
+// (the rows of the sheet,
+// read with formulas rather than their results;
+// see writeUpdates).
+// It does nothing if no run is being recorded.
+func (u *undoLog) record(sheetName string, orig [][]any, updates []cellUpdate) {
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.current == nil {
+		return
+	}
+	for _, upd := range updates {
+		cell := undoCell{Sheet: sheetName, Row: upd.row, Col: upd.col}
+		if row := rowAt(orig, upd.row); upd.col < len(row) {
+			cell.Old = row[upd.col]
+		}
+		if _, ok := upd.val.(formula); !ok {
+			cell.New = upd.val
+		}
+		u.current.Cells = append(u.current.Cells, cell)
+	}
+}
+
+// finish ends the recording of a run
+// and, if it changed anything,
+// saves it.
+func (u *undoLog) finish() error {
+	if u == nil {
+		return nil
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	run := u.current
+	u.current = nil
+	if run == nil || len(run.Cells) == 0 {
+		return nil
+	}
+	u.runs = append(u.runs, *run)
+	if len(u.runs) > maxUndoRuns {
+		u.runs = u.runs[len(u.runs)-maxUndoRuns:]
+	}
+	return u.save()
+}
+
+// save writes the log to its file.
+// The caller must hold u.mu.
+func (u *undoLog) save() error {
+	if err := os.MkdirAll(filepath.Dir(u.filename), 0755); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", u.filename, err)
+	}
+	data, err := json.Marshal(u.runs)
+	if err != nil {
+		return fmt.Errorf("encoding undo log: %w", err)
+	}
+
+	// As in history.save,
+	// write to a temporary file and rename it.
+	tmpname := u.filename + ".tmp"
+	if err := os.WriteFile(tmpname, data, 0644); err != nil {
+		return fmt.Errorf("writing %s: %w", tmpname, err)
+	}
+	if err := os.Rename(tmpname, u.filename); err != nil {
+		return fmt.Errorf("renaming %s to %s: %w", tmpname, u.filename, err)
+	}
+	return nil
+}
+
+// rollback implements the "rollback" subcommand.
+// It restores the cells changed by the most recent run
+// (the latest one in the undo log)
+// to their earlier values,
+// then removes that run from the log,
+// so another rollback goes back one more run.
+//
+// Cells that have changed since the run
+// (because someone edited them)
+// are left alone,
+// with a warning.
+// So are cells in rows that change while rollback is working;
+// see writeUpdates.
+func (r *runner) rollback(ctx context.Context, dryRun bool, _ []string) error {
+	u := r.undo
+	if u == nil {
+		return fmt.Errorf("no undo log (rollback needs the history; see -history)")
+	}
+
+	// Don't hold u.mu while writing,
+	// since writeUpdates calls u.record.
+	u.mu.Lock()
+	if len(u.runs) == 0 {
+		u.mu.Unlock()
+		return fmt.Errorf("no runs to roll back")
+	}
+	run := u.runs[len(u.runs)-1]
+	u.mu.Unlock()
+
+	if run.SheetKey != r.sheetKey {
+		return fmt.Errorf("the last run recorded was for spreadsheet %s, not %s", run.SheetKey, r.sheetKey)
+	}
+
+	bySheet := make(map[string][]undoCell)
+	var sheetNames []string
+	for _, cell := range run.Cells {
+		if _, ok := bySheet[cell.Sheet]; !ok {
+			sheetNames = append(sheetNames, cell.Sheet)
+		}
+		bySheet[cell.Sheet] = append(bySheet[cell.Sheet], cell)
+	}
+
+	for _, sheetName := range sheetNames {
+		resp, err := r.readSheet(ctx, sheetName)
+		if err != nil {
+			return err
+		}
+
+		var updates []cellUpdate
+		for _, cell := range bySheet[sheetName] {
+			var current any
+			if row := rowAt(resp.Values, cell.Row); cell.Col < len(row) {
+				current = row[cell.Col]
+			}
+			if cell.New != nil && !sameCell(current, cell.New) {
+				slog.Warn("Cell changed since the run, not rolling it back", "cell", a1.Cell(sheetName, cell.Row, cell.Col), "value", current)
+				continue
+			}
+			updates = append(updates, cellUpdate{row: cell.Row, col: cell.Col, val: undoValue(cell)})
+		}
+
+		if dryRun {
+			for _, upd := range updates {
+				fmt.Printf("%s: %v\n", a1.Cell(sheetName, upd.row, upd.col), upd.val)
+			}
+			continue
+		}
+		if err := r.writeUpdates(ctx, sheetName, resp.Values, updates); err != nil {
+			return err
+		}
+		slog.Info("Rolled back cells", "sheet", sheetName, "cells", len(updates))
+	}
+
+	if dryRun {
+		return nil
+	}
+
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if n := len(u.runs); n > 0 && u.runs[n-1].Start.Equal(run.Start) {
+		u.runs = u.runs[:n-1]
+	}
+	return u.save()
+}
+
+// sameCell tells whether a cell's current value,
+// as read from the sheet,
+// is the value majic wrote there.
+// Prices are written as numbers but read back formatted
+// (e.g. as "$3.75"),
+// so numbers are compared as prices.
+// Booleans are read back as "TRUE" and "FALSE."
+func sameCell(current, wrote any) bool {
+	switch wrote := wrote.(type) {
+	case float64:
+		c, ok := parsePrice(fmt.Sprint(current))
+		return ok && c == wrote
+	case bool:
+		return strings.EqualFold(fmt.Sprint(current), strconv.FormatBool(wrote))
+	}
+	if current == nil {
+		current = ""
+	}
+	return fmt.Sprint(current) == fmt.Sprint(wrote)
+}
+
+// undoValue is the value to write to restore a cell.
+// A formula is written back as a formula.
+//
+// Undo logs from older versions of majic have formatted values
+// instead of formulas and unformatted numbers.
+// From those,
+// a cell that majic overwrote with a number
+// and that held a price before
+// (like "$3.00")
+// gets the number back,
+// not the text.
+func undoValue(cell undoCell) any {
+	if cell.Old == nil {
+		return ""
+	}
+	if s, ok := cell.Old.(string); ok && strings.HasPrefix(s, "=") {
+		return formula(s)
+	}
+	if _, ok := cell.New.(float64); ok {
+		if f, ok := parsePrice(fmt.Sprint(cell.Old)); ok {
+			return f
+		}
+	}
+	return cell.Old
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestSameCell(t *testing.T) {
+	cases := []struct {
+		current, wrote any
+		want           bool
+	}{
+		{current: "$3.75", wrote: 3.75, want: true},
+		{current: "3.75", wrote: 3.75, want: true},
+		{current: "$3.50", wrote: 3.75, want: false},
+		{current: "TRUE", wrote: true, want: true},
+		{current: "FALSE", wrote: false, want: true},
+		{current: "FALSE", wrote: true, want: false},
+		{current: nil, wrote: false, want: false},
+		{current: "NM", wrote: "NM", want: true},
+		{current: nil, wrote: "", want: true},
+	}
+	for _, c := range cases {
+		// Run the written value through JSON,
+		// as it is when it's read from the undo log.
+		data, err := json.Marshal(c.wrote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var wrote any
+		if err := json.Unmarshal(data, &wrote); err != nil {
+			t.Fatal(err)
+		}
+		if got := sameCell(c.current, wrote); got != c.want {
+			t.Errorf("sameCell(%#v, %#v) = %v, want %v", c.current, wrote, got, c.want)
+		}
+	}
+}
+
+func TestUndoValue(t *testing.T) {
+	cases := []struct {
+		cell undoCell
+		want any
+	}{
+		{cell: undoCell{Old: nil, New: 3.75}, want: ""},
+		{cell: undoCell{Old: "=A2*B2", New: 3.75}, want: formula("=A2*B2")},
+		{cell: undoCell{Old: 3.0, New: 3.75}, want: 3.0},
+		{cell: undoCell{Old: "$3.00", New: 3.75}, want: 3.0},
+		{cell: undoCell{Old: "NM", New: "LP"}, want: "NM"},
+	}
+	for _, c := range cases {
+		if got := undoValue(c.cell); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("undoValue(%+v) = %#v, want %#v", c.cell, got, c.want)
+		}
+	}
+}
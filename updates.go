@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+
+	"github.com/bobg/majic/a1"
+	"github.com/bobg/majic/majicerr"
+	"google.golang.org/api/sheets/v4"
+)
+
+// A cellUpdate is a new value for a single cell.
+// Row and col are zero-based.
+type cellUpdate struct {
+	row, col int
+	val      any // A value of type formula is written as a formula; anything else is written literally.
+}
+
+// maxRangesPerBatch limits the number of ranges written in a single API call,
+// to keep requests to a reasonable size.
+const maxRangesPerBatch = 1000
+
+// writeUpdates writes the given new cell values to a sheet,
+// using as few API calls as possible.
+//
+// Updating a sheet one cell
+// (or one row)
+// at a time uses up the Sheets API quota quickly.
+// So majic computes all the new values for a sheet in memory,
+// then writes them here,
+// with adjacent cells in the same row combined into a single range.
+// Formulas must be written separately from other values
+// (so that the others aren't interpreted as formulas),
+// so this normally takes two API calls:
+// one for formulas and one for everything else.
+//
+// Since a run can take a long time,
+// someone may have edited the sheet in the meantime.
+// To avoid clobbering their changes,
+// writeUpdates first reads the sheet again
+// and compares it with its original contents
+// (the rows in orig).
+// Updates to rows that have changed are dropped,
+// with a warning.
+// The rows will be updated on the next run.
+func (r *runner) writeUpdates(ctx context.Context, sheetName string, orig [][]any, updates []cellUpdate) error {
+	if len(updates) == 0 {
+		return nil
+	}
+
+	updates, err := r.dropConflicts(ctx, sheetName, orig, updates)
+	if err != nil {
+		return err
+	}
+
+	// Remember the old values,
+	// for "majic rollback."
+	// They're read again as formulas,
+	// since orig has formatted values,
+	// and a formula that rollback restores from those would come back as a constant.
+	// See undo.go.
+	if r.undo.recording() {
+		resp, err := r.readSheetAs(ctx, sheetName, "FORMULA")
+		if err != nil {
+			return fmt.Errorf("reading cells for the undo log: %w", err)
+		}
+		r.undo.record(sheetName, resp.Values, updates)
+	}
+
+	var literals, formulas []cellUpdate
+	for _, u := range updates {
+		if f, ok := u.val.(formula); ok {
+			u.val = string(f)
+			formulas = append(formulas, u)
+		} else {
+			literals = append(literals, u)
+		}
+	}
+
+	for _, b := range []struct {
+		inputOption string
+		updates     []cellUpdate
+	}{
+		{inputOption: "RAW", updates: literals},
+		{inputOption: "USER_ENTERED", updates: formulas},
+	} {
+		ranges := valueRanges(sheetName, b.updates)
+		for len(ranges) > 0 {
+			n := len(ranges)
+			if n > maxRangesPerBatch {
+				n = maxRangesPerBatch
+			}
+			batch := &sheets.BatchUpdateValuesRequest{
+				ValueInputOption: b.inputOption,
+				Data:             ranges[:n],
+			}
+			if err := r.svc.batchUpdateValues(ctx, r.sheetKey, batch); err != nil {
+				return majicerr.SheetWriteError{Sheet: sheetName, Err: err}
+			}
+			ranges = ranges[n:]
+		}
+		sheetWrites.Add(float64(len(b.updates)))
+	}
+
+	return nil
+}
+
+// dropConflicts rereads the sheet
+// and removes any updates to rows whose contents differ from those in orig.
+func (r *runner) dropConflicts(ctx context.Context, sheetName string, orig [][]any, updates []cellUpdate) ([]cellUpdate, error) {
+	resp, err := r.readSheet(ctx, sheetName)
+	if err != nil {
+		return nil, fmt.Errorf("checking for conflicting edits: %w", err)
+	}
+	current := resp.Values
+
+	changed := make(map[int]bool)
+	for _, u := range updates {
+		if _, ok := changed[u.row]; ok {
+			continue
+		}
+		changed[u.row] = !sameRow(rowAt(orig, u.row), rowAt(current, u.row))
+		if changed[u.row] {
+			slog.Warn("Row changed during the run, not updating it", "sheet", sheetName, "row", u.row+1)
+		}
+	}
+
+	var result []cellUpdate
+	for _, u := range updates {
+		if !changed[u.row] {
+			result = append(result, u)
+		}
+	}
+	return result, nil
+}
+
+// rowAt returns rows[rownum],
+// or nil if there is no such row.
+func rowAt(rows [][]any, rownum int) []any {
+	if rownum < len(rows) {
+		return rows[rownum]
+	}
+	return nil
+}
+
+// sameRow tells whether two rows have the same contents.
+// Missing cells at the end of a row are the same as empty ones.
+func sameRow(a, b []any) bool {
+	n := len(a)
+	if len(b) > n {
+		n = len(b)
+	}
+	for i := 0; i < n; i++ {
+		var av, bv string
+		if i < len(a) {
+			av = fmt.Sprint(a[i])
+		}
+		if i < len(b) {
+			bv = fmt.Sprint(b[i])
+		}
+		if av != bv {
+			return false
+		}
+	}
+	return true
+}
+
+// valueRanges turns a list of cell updates into ValueRanges,
+// combining updates to adjacent cells in the same row.
+func valueRanges(sheetName string, updates []cellUpdate) []*sheets.ValueRange {
+	sort.SliceStable(updates, func(i, j int) bool {
+		if updates[i].row != updates[j].row {
+			return updates[i].row < updates[j].row
+		}
+		return updates[i].col < updates[j].col
+	})
+
+	var (
+		result []*sheets.ValueRange
+		start  cellUpdate // The first cell of the current run of adjacent cells.
+		vals   []any      // The values in the current run.
+	)
+	flush := func() {
+		if len(vals) == 0 {
+			return
+		}
+		rangeName := a1.Range{
+			Sheet:    sheetName,
+			StartRow: start.row,
+			StartCol: start.col,
+			EndRow:   start.row,
+			EndCol:   start.col + len(vals) - 1,
+		}.String()
+		result = append(result, &sheets.ValueRange{Range: rangeName, Values: [][]any{vals}})
+		vals = nil
+	}
+	for _, u := range updates {
+		if len(vals) > 0 && u.row == start.row && u.col == start.col+len(vals)-1 {
+			// A second update to the same cell replaces the first.
+			vals[len(vals)-1] = u.val
+			continue
+		}
+		if len(vals) > 0 && u.row == start.row && u.col == start.col+len(vals) {
+			vals = append(vals, u.val)
+			continue
+		}
+		flush()
+		start = u
+		vals = []any{u.val}
+	}
+	flush()
+
+	return result
+}
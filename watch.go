@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// A watchTarget is the contents of a row's Watch column:
+// a price at which the card is worth buying or selling.
+// It's written as a price by itself, like "5.00,"
+// meaning the price moving past it in either direction is of interest,
+// or with "<" or ">" in front,
+// like "<2" (it's worth buying below 2)
+// or ">20" (it's worth selling above 20).
+type watchTarget struct {
+	price float64
+	dir   int // -1 for "<", 1 for ">", 0 for either direction.
+}
+
+// parseWatch parses the contents of a Watch cell.
+// The boolean result is false if the cell is blank or unparseable.
+func parseWatch(s string) (watchTarget, bool) {
+	var t watchTarget
+	s = strings.TrimSpace(s)
+	switch {
+	case strings.HasPrefix(s, "<"):
+		t.dir = -1
+	case strings.HasPrefix(s, ">"):
+		t.dir = 1
+	}
+	s = strings.TrimLeft(s, "<>= ")
+	p, ok := parsePrice(s)
+	if !ok {
+		return t, false
+	}
+	t.price = p
+	return t, true
+}
+
+// crossed tells whether a change in price from oldPrice to newPrice
+// reached the target from the other side.
+func (t watchTarget) crossed(oldPrice, newPrice float64) bool {
+	var (
+		up   = oldPrice < t.price && newPrice >= t.price
+		down = oldPrice > t.price && newPrice <= t.price
+	)
+	switch t.dir {
+	case -1:
+		return down
+	case 1:
+		return up
+	}
+	return up || down
+}
+
+func (t watchTarget) String() string {
+	s := strconv.FormatFloat(t.price, 'f', 2, 64)
+	switch t.dir {
+	case -1:
+		return "<" + s
+	case 1:
+		return ">" + s
+	}
+	return s
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A rowRequest asks for some rows of a sheet to be priced right away,
+// regardless of when they were last updated.
+// It's the body of a POST to /webhook.
+// A request with no rows asks for a full run instead.
+type rowRequest struct {
+	Sheet string `json:"sheet"` // Empty means the first sheet.
+	Rows  []int  `json:"rows"`  // One-based, as displayed in Google Sheets.
+}
+
+// maxRowRequests is how many row requests can wait
+// while a run is in progress.
+const maxRowRequests = 100
+
+// addWebhookHandler adds the /webhook endpoint to mux,
+// if r.webhookSecret is set.
+// It's for something like a Google Apps Script onEdit trigger
+// (see appscript.go)
+// to get new rows priced as soon as they're entered.
+// The request must have the header "Authorization: Bearer SECRET"
+// and a JSON rowRequest as its body.
+// The rows are queued and priced between regular runs.
+func addWebhookHandler(mux *http.ServeMux, r *runner) {
+	if r.webhookSecret == "" {
+		return
+	}
+	mux.HandleFunc("/webhook", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPost {
+			writeJSONError(w, http.StatusMethodNotAllowed, errors.New("use POST"))
+			return
+		}
+		if !r.hasSecret(req) {
+			writeJSONError(w, http.StatusUnauthorized, errors.New("bad or missing secret"))
+			return
+		}
+
+		var rr rowRequest
+		if err := json.NewDecoder(req.Body).Decode(&rr); err != nil {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("decoding request: %w", err))
+			return
+		}
+		if len(rr.Rows) == 0 {
+			select {
+			case r.runNow <- struct{}{}:
+				slog.Info("Run requested by webhook")
+			default:
+				// A run has already been requested.
+			}
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+			return
+		}
+		for _, row := range rr.Rows {
+			if row < 1 {
+				writeJSONError(w, http.StatusBadRequest, fmt.Errorf("bad row number %d", row))
+				return
+			}
+		}
+
+		select {
+		case r.rowRequests <- rr:
+			slog.Info("Rows requested by webhook", "sheet", rr.Sheet, "rows", rr.Rows)
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+		default:
+			writeJSONError(w, http.StatusServiceUnavailable, errors.New("too many requests queued"))
+		}
+	})
+}
+
+// hasSecret tells whether req carries r.webhookSecret
+// in the header "Authorization: Bearer SECRET."
+// It's always false if there's no secret.
+func (r *runner) hasSecret(req *http.Request) bool {
+	if r.webhookSecret == "" {
+		return false
+	}
+	token, ok := strings.CutPrefix(req.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(r.webhookSecret)) == 1
+}
+
+// mayStartRun tells whether req,
+// to the dashboard's /run or the server's /runs,
+// may start a pricing run.
+// A run calls APIs and writes to the spreadsheet,
+// so not just anyone who can reach the server should be able to start one.
+// The request must carry the webhook secret
+// (see hasSecret),
+// or else come from this machine.
+//
+// A request from this machine might still come from a browser
+// showing some other site's page,
+// which has submitted a form to the server
+// (cross-site request forgery).
+// So if the request has an Origin header,
+// as browsers send with a POST,
+// it must be the server's own.
+func (r *runner) mayStartRun(req *http.Request) bool {
+	if r.hasSecret(req) {
+		return true
+	}
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return false
+	}
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == req.Host
+}
+
+// runRequestedRows does a run that prices only the rows in rr
+// and any other row requests waiting in r.rowRequests.
+func (r *runner) runRequestedRows(ctx context.Context, rr rowRequest) {
+	r.only = make(map[string]map[int]bool)
+	defer func() { r.only = nil }()
+
+	add := func(rr rowRequest) {
+		rows, ok := r.only[rr.Sheet]
+		if !ok {
+			rows = make(map[int]bool)
+			r.only[rr.Sheet] = rows
+		}
+		for _, row := range rr.Rows {
+			rows[row-1] = true
+		}
+	}
+	add(rr)
+	for len(r.rowRequests) > 0 {
+		add(<-r.rowRequests)
+	}
+
+	r.runAndLog(ctx)
+}
+
+// onlySheets returns the names of the sheets in r.only,
+// in order.
+func (r *runner) onlySheets() []string {
+	var result []string
+	for name := range r.only {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bobg/majic/majicerr"
+)
+
+// yugiohGame is the -game name for Yu-Gi-Oh!
+const yugiohGame = "yugioh"
+
+const ygoprodeckAPIBase = "https://db.ygoprodeck.com/api/v7"
+
+// A ygoprodeckClient is the cardCatalog for Yu-Gi-Oh!,
+// using the YGOPRODeck API
+// (documented at https://ygoprodeck.com/api-guide/).
+//
+// A Yu-Gi-Oh! printing is identified by a code like "LOB-EN001."
+// The Set code column may contain the whole thing,
+// or just the part before the hyphen
+// ("LOB")
+// with the rest
+// ("EN001")
+// in the Collector number column.
+// With a set code,
+// the price is that of the printing;
+// without one,
+// it's the card's TCGplayer price.
+// Yu-Gi-Oh! cards have rarities instead of foil versions,
+// so the Foil column is ignored.
+type ygoprodeckClient struct {
+	client  *http.Client // Rate-limited; see main.go.
+	baseURL string       // Normally ygoprodeckAPIBase.
+	cache   *responseCache
+}
+
+func (yc *ygoprodeckClient) card(ctx context.Context, name, setCode, number, _ string, _, _ bool) (*respObj, error) {
+	key := cacheKey(yugiohGame, name, setCode, number)
+	if obj := yc.cache.get(key); obj != nil {
+		slog.Debug("Using cached YGOPRODeck response", "card", name, "set", setCode)
+		return obj, nil
+	}
+
+	u := yc.baseURL + "/cardinfo.php?" + url.Values{"name": {name}}.Encode()
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating YGOPRODeck request: %w", err)
+	}
+	resp, err := yc.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("querying YGOPRODeck API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var info struct {
+		Error string `json:"error"`
+		Data  []struct {
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Desc     string `json:"desc"`
+			URL      string `json:"ygoprodeck_url"`
+			CardSets []struct {
+				SetName   string `json:"set_name"`
+				SetCode   string `json:"set_code"`
+				SetRarity string `json:"set_rarity"`
+				SetPrice  string `json:"set_price"`
+			} `json:"card_sets"`
+			CardPrices []struct {
+				TCGplayerPrice string `json:"tcgplayer_price"`
+			} `json:"card_prices"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("JSON-decoding YGOPRODeck response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		// YGOPRODeck says 400 Bad Request when there's no such card.
+		if resp.StatusCode == http.StatusBadRequest {
+			return nil, fmt.Errorf("%s: %w", info.Error, majicerr.ErrCardNotFound)
+		}
+		return nil, apiStatusError("YGOPRODeck", resp.StatusCode, info.Error)
+	}
+	if len(info.Data) == 0 {
+		return nil, fmt.Errorf("no card named %q: %w", name, majicerr.ErrCardNotFound)
+	}
+
+	d := info.Data[0]
+	obj := &respObj{
+		Name:        d.Name,
+		TypeLine:    d.Type,
+		OracleText:  d.Desc,
+		ScryfallURI: d.URL, // For the Link column.
+	}
+
+	var price string
+	if setCode == "" {
+		if len(d.CardPrices) > 0 {
+			price = d.CardPrices[0].TCGplayerPrice
+		}
+	} else {
+		want := strings.ToUpper(setCode)
+		if number != "" {
+			want += "-" + strings.ToUpper(number)
+		}
+		var found bool
+		for _, s := range d.CardSets {
+			code := strings.ToUpper(s.SetCode)
+			if code != want && !(number == "" && strings.HasPrefix(code, want+"-")) {
+				continue
+			}
+			found = true
+			prefix, num, _ := strings.Cut(s.SetCode, "-")
+			obj.Set, obj.CollectorNumber = prefix, num
+			obj.SetName = s.SetName
+			obj.Rarity = s.SetRarity
+			price = s.SetPrice
+			break
+		}
+		if !found {
+			return nil, fmt.Errorf("no printing of %q with set code %s: %w", name, want, majicerr.ErrCardNotFound)
+		}
+	}
+
+	// YGOPRODeck writes a missing price as "0.00."
+	if p, ok := parsePrice(price); !ok || p == 0 {
+		price = ""
+	}
+	obj.Prices = pricesObj{USD: price, USDFoil: price}
+	obj.PriceSource, obj.PricedAt = sourceYGOPRODeck, time.Now()
+
+	yc.cache.put(key, obj)
+	return obj, nil
+}